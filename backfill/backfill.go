@@ -0,0 +1,160 @@
+// Package backfill loads recorded games straight into a store, without the
+// queue and the real-time replay of cmd/client.
+package backfill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	database "sync_score/cmd/database/db"
+	sp "sync_score/sport"
+)
+
+// Options of an import.
+type Options struct {
+	// StartedAt dates the imported games. When zero, they are dated by the
+	// store at the time of the import.
+	StartedAt time.Time
+	// Progress receives a line per game file, it may be nil.
+	Progress io.Writer
+}
+
+// FileError is a game file which could not be imported.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// Report sums up an import.
+type Report struct {
+	// Imported and Skipped are the games written and the games already in
+	// the store.
+	Imported []string
+	Skipped  []string
+	Actions  int
+	Errors   []FileError
+}
+
+// GameFiles returns the game files designated by path: every json file of a
+// directory, the files listed in a json list like gamesRecorded.json, or the
+// path itself when it is a game file. The relative paths of a list are
+// resolved from the directory of the list.
+func GameFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	if err := json.Unmarshal(content, &list); err == nil {
+		// The files listed are relative to the list.
+		for i, file := range list {
+			if !filepath.IsAbs(file) {
+				list[i] = filepath.Join(filepath.Dir(path), file)
+			}
+		}
+		return list, nil
+	}
+	return []string{path}, nil
+}
+
+// Import writes the games of the files into the store. Each game is written
+// in a single batch, so a game is either fully imported or not at all. The
+// recorded games are finished: a game without its end is ended, so its final
+// score is stored. The games already in the store are skipped, and a file
+// which fails is reported without stopping the import of the others.
+func Import(store database.Store, files []string, opts Options) Report {
+	var report Report
+	for i, path := range files {
+		imported, skipped, actions, err := importFile(store, path, opts)
+		report.Imported = append(report.Imported, imported...)
+		report.Skipped = append(report.Skipped, skipped...)
+		report.Actions += actions
+		if err != nil {
+			report.Errors = append(report.Errors, FileError{Path: path, Err: err})
+		}
+		if opts.Progress != nil {
+			status := fmt.Sprintf("%d actions imported", actions)
+			if len(skipped) > 0 {
+				status += fmt.Sprintf(", skipped %s", strings.Join(skipped, ", "))
+			}
+			if err != nil {
+				status += fmt.Sprintf(", error: %v", err)
+			}
+			fmt.Fprintf(opts.Progress, "[%d/%d] %s: %s\n", i+1, len(files), path, status)
+		}
+	}
+	return report
+}
+
+func importFile(store database.Store, path string, opts Options) (imported, skipped []string, actions int, err error) {
+	game, err := sp.ReadGameFile(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(game) == 0 {
+		return nil, nil, 0, errors.New("no action in the file")
+	}
+
+	// A file usually holds one game, but nothing prevents several.
+	var posters []string
+	byGame := make(map[string]sp.Actions)
+	for _, action := range game {
+		if action.GamePoster == "" {
+			return nil, nil, 0, errors.New("action without game")
+		}
+		if _, ok := byGame[action.GamePoster]; !ok {
+			posters = append(posters, action.GamePoster)
+		}
+		byGame[action.GamePoster] = append(byGame[action.GamePoster], action)
+	}
+
+	for _, poster := range posters {
+		_, err := store.QueryGameHistoric(poster)
+		if err == nil {
+			skipped = append(skipped, poster)
+			continue
+		}
+		if !errors.Is(err, database.ErrGameNotFound) {
+			return imported, skipped, actions, err
+		}
+		if !opts.StartedAt.IsZero() {
+			err := store.RegisterGame(sp.GameInfo{GamePoster: poster, StartedAt: opts.StartedAt})
+			if err != nil && !errors.Is(err, database.ErrGameExists) {
+				return imported, skipped, actions, err
+			}
+		}
+		batch := byGame[poster]
+		if last := batch[len(batch)-1]; last.Description != sp.EndOfGame {
+			batch = append(batch, sp.Action{GamePoster: poster, Description: sp.EndOfGame, Minute: last.Minute})
+		}
+		if err := store.SendBatchToTables(batch); err != nil {
+			return imported, skipped, actions, fmt.Errorf("%s: %w", poster, err)
+		}
+		imported = append(imported, poster)
+		actions += len(byGame[poster])
+	}
+	return imported, skipped, actions, nil
+}
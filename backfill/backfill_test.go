@@ -0,0 +1,111 @@
+package backfill
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	database "sync_score/cmd/database/db"
+	sp "sync_score/sport"
+)
+
+func writeFile(t *testing.T, path string, content any) {
+	t.Helper()
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func game(gamePoster string, n int) sp.Actions {
+	var actions sp.Actions
+	for i := 0; i < n; i++ {
+		actions = append(actions, sp.Action{GamePoster: gamePoster, Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: int32(i)})
+	}
+	return actions
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), game("Boston_Knicks", 3))
+	writeFile(t, filepath.Join(dir, "b.json"), game("Bulls_cavaliers", 2))
+	if err := os.WriteFile(filepath.Join(dir, "c.json"), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "d.json"), game("Sixers_Raptor", 4))
+
+	files, err := GameFiles(dir)
+	if err != nil {
+		t.Fatalf("GameFiles() error = %v", err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("GameFiles() = %v, want 4 files", files)
+	}
+
+	store := database.NewMemoryStore()
+	if err := store.SendToTables(game("Bulls_cavaliers", 1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	var progress bytes.Buffer
+	report := Import(store, files, Options{StartedAt: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), Progress: &progress})
+
+	if strings.Join(report.Imported, ",") != "Boston_Knicks,Sixers_Raptor" {
+		t.Errorf("Imported = %v", report.Imported)
+	}
+	if strings.Join(report.Skipped, ",") != "Bulls_cavaliers" {
+		t.Errorf("Skipped = %v", report.Skipped)
+	}
+	if report.Actions != 7 {
+		t.Errorf("Actions = %d, want 7", report.Actions)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Path != files[2] {
+		t.Errorf("Errors = %v, want an error for %s", report.Errors, files[2])
+	}
+	if lines := strings.Count(progress.String(), "\n"); lines != 4 {
+		t.Errorf("got %d lines of progress, want 4:\n%s", lines, progress.String())
+	}
+
+	actions, err := store.QueryGameHistoric("Bulls_cavaliers")
+	if err != nil || len(actions) != 1 {
+		t.Errorf("a skipped game should be left untouched, got %d actions, error %v", len(actions), err)
+	}
+	seasons, err := store.QueryPlayerSeasons("JD Davison")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seasons) != 2 || seasons[0].Season != "2024-25" || seasons[0].TwoPointSuccess != 7 {
+		t.Errorf("imported games should be dated, got seasons %+v", seasons)
+	}
+	results, err := store.QueryGameResults(database.ResultFilter{})
+	if err != nil || len(results) != 2 {
+		t.Errorf("imported games should be ended, got results %+v, error %v", results, err)
+	}
+
+	// A second import skips everything.
+	report = Import(store, files, Options{})
+	if len(report.Imported) != 0 || len(report.Skipped) != 3 {
+		t.Errorf("second import = %+v, want 3 games skipped", report)
+	}
+}
+
+func TestGameFilesFromList(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "gamesRecorded.json")
+	writeFile(t, list, []string{"data/Boston-Knicks.json", "/var/lib/games/Sixers-Raptor.json"})
+
+	files, err := GameFiles(list)
+	if err != nil {
+		t.Fatalf("GameFiles() error = %v", err)
+	}
+	// The files are relative to the list, not to the working directory.
+	if len(files) != 2 || files[0] != filepath.Join(dir, "data/Boston-Knicks.json") || files[1] != "/var/lib/games/Sixers-Raptor.json" {
+		t.Errorf("GameFiles() = %v", files)
+	}
+}
@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// AMQPChannel is the part of *amqp.Channel consumed by the AMQP broker. A
+// channel which survives the losses of the connection, as queue.Manager,
+// also reports the deliveries of a lost channel with
+// Stale(amqp.Delivery) bool.
+type AMQPChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Close() error
+}
+
+// PublishFunc publishes a message on an AMQP exchange with a routing key, as
+// the PublishMessage of a queue.Publisher which waits for the confirms.
+type PublishFunc func(exchange, key string, msg amqp.Publishing) error
+
+// AMQP is the broker of a RabbitMQ channel. The queues and the exchanges are
+// declared on the channel beforehand. The publishes are serialized: a
+// queue.Publisher waits for the confirm of each one, and takes the next
+// confirm as its own.
+type AMQP struct {
+	ch      AMQPChannel
+	publish PublishFunc
+	// publishing is held during a publish.
+	publishing sync.Mutex
+	// done is closed with the broker, to stop the subscribers.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAMQP returns the broker which consumes on ch and publishes with publish.
+func NewAMQP(ch AMQPChannel, publish PublishFunc) *AMQP {
+	return &AMQP{ch: ch, publish: publish, done: make(chan struct{})}
+}
+
+// Publish publishes the message on the exchange.
+func (b *AMQP) Publish(exchange string, msg Message) error {
+	b.publishing.Lock()
+	defer b.publishing.Unlock()
+	err := b.publish(exchange, msg.RoutingKey, amqp.Publishing{
+		Headers:     amqp.Table(msg.Headers),
+		ContentType: msg.ContentType,
+		Timestamp:   msg.Timestamp,
+		Body:        msg.Body,
+	})
+	return closedError(err)
+}
+
+// Subscribe sets the prefetch of the channel and consumes the queue, with
+// manual acknowledgements.
+func (b *AMQP) Subscribe(queue string, prefetch int) (<-chan Delivery, error) {
+	if err := b.ch.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("failed to set the prefetch: %v", err)
+	}
+	msgs, err := b.ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register consumer: %v", err)
+	}
+	stale, _ := b.ch.(interface{ Stale(amqp.Delivery) bool })
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for msg := range msgs {
+			d := Delivery{
+				Message: Message{
+					RoutingKey:  msg.RoutingKey,
+					Headers:     map[string]any(msg.Headers),
+					ContentType: msg.ContentType,
+					Timestamp:   msg.Timestamp,
+					Body:        msg.Body,
+				},
+				Redelivered:  msg.Redelivered,
+				Acknowledger: amqpAcknowledger{msg: msg, stale: stale},
+			}
+			select {
+			case deliveries <- d:
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// Close closes the channel, RabbitMQ requeues the deliveries not settled.
+func (b *AMQP) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return b.ch.Close()
+}
+
+type amqpAcknowledger struct {
+	msg   amqp.Delivery
+	stale interface{ Stale(amqp.Delivery) bool }
+}
+
+func (a amqpAcknowledger) Ack() error {
+	return closedError(a.msg.Ack(false))
+}
+
+func (a amqpAcknowledger) Nack(requeue bool) error {
+	return closedError(a.msg.Nack(false, requeue))
+}
+
+func (a amqpAcknowledger) Stale() bool {
+	return a.stale != nil && a.stale.Stale(a.msg)
+}
+
+// closedError returns ErrClosed for the errors of a closed channel.
+func closedError(err error) error {
+	if errors.Is(err, amqp.ErrClosed) {
+		return ErrClosed
+	}
+	return err
+}
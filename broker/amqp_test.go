@@ -0,0 +1,177 @@
+package broker
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fakeChannel delivers the messages given to deliver, and records the
+// settlements of the deliveries.
+type fakeChannel struct {
+	prefetch   int
+	deliveries chan amqp.Delivery
+	settled    []string
+	lost       bool
+}
+
+func (c *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.prefetch = prefetchCount
+	return nil
+}
+
+func (c *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if autoAck {
+		return nil, errors.New("the fake channel only supports manual acknowledgements")
+	}
+	return c.deliveries, nil
+}
+
+func (c *fakeChannel) Close() error {
+	close(c.deliveries)
+	return nil
+}
+
+func (c *fakeChannel) Stale(msg amqp.Delivery) bool {
+	return c.lost
+}
+
+func (c *fakeChannel) Ack(tag uint64, multiple bool) error {
+	if c.lost {
+		return amqp.ErrClosed
+	}
+	c.settled = append(c.settled, "ack")
+	return nil
+}
+
+func (c *fakeChannel) Nack(tag uint64, multiple, requeue bool) error {
+	if requeue {
+		c.settled = append(c.settled, "requeue")
+	} else {
+		c.settled = append(c.settled, "drop")
+	}
+	return nil
+}
+
+func (c *fakeChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func TestAMQPSubscribe(t *testing.T) {
+	ch := &fakeChannel{deliveries: make(chan amqp.Delivery, 3)}
+	b := NewAMQP(ch, nil)
+	deliveries, err := b.Subscribe("LiveGame", 4)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if ch.prefetch != 4 {
+		t.Errorf("prefetch = %d, want 4", ch.prefetch)
+	}
+	for _, body := range []string{"1", "2", "3"} {
+		ch.deliveries <- amqp.Delivery{
+			Acknowledger: ch,
+			Headers:      amqp.Table{"x-game": "Boston_Knicks"},
+			RoutingKey:   "LiveGame",
+			Redelivered:  body == "2",
+			Body:         []byte(body),
+		}
+	}
+
+	first := receive(t, deliveries)
+	want := Message{RoutingKey: "LiveGame", Headers: map[string]any{"x-game": "Boston_Knicks"}, Body: []byte("1")}
+	if !reflect.DeepEqual(first.Message, want) || first.Redelivered {
+		t.Errorf("delivery = %+v, want %+v", first, want)
+	}
+	second := receive(t, deliveries)
+	if !second.Redelivered {
+		t.Errorf("delivery %s should be redelivered", second.Body)
+	}
+	if err := first.Ack(); err != nil {
+		t.Errorf("Ack() error = %v", err)
+	}
+	if err := second.Nack(true); err != nil {
+		t.Errorf("Nack() error = %v", err)
+	}
+	if want := []string{"ack", "requeue"}; !reflect.DeepEqual(ch.settled, want) {
+		t.Errorf("settled = %v, want %v", ch.settled, want)
+	}
+
+	// The connection is lost: the deliveries of the lost channel are stale.
+	third := receive(t, deliveries)
+	ch.lost = true
+	if !third.Stale() {
+		t.Errorf("Stale() = false, want true once the channel is lost")
+	}
+	if err := third.Ack(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Ack() error = %v, want %v", err, ErrClosed)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Errorf("the deliveries should be closed with the broker")
+	}
+}
+
+func TestAMQPPublish(t *testing.T) {
+	type published struct {
+		exchange, key string
+		msg           amqp.Publishing
+	}
+	var got []published
+	b := NewAMQP(&fakeChannel{}, func(exchange, key string, msg amqp.Publishing) error {
+		got = append(got, published{exchange, key, msg})
+		if key == "lost" {
+			return amqp.ErrClosed
+		}
+		return nil
+	})
+	msg := Message{RoutingKey: "LiveGame", Headers: map[string]any{"x-game": "Boston_Knicks"}, ContentType: "application/json", Body: []byte("action")}
+	if err := b.Publish("", msg); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	want := published{"", "LiveGame", amqp.Publishing{Headers: amqp.Table{"x-game": "Boston_Knicks"}, ContentType: "application/json", Body: []byte("action")}}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("published %+v, want %+v", got, want)
+	}
+	if err := b.Publish("", Message{RoutingKey: "lost"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestAMQPPublishesOneAtATime(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	b := NewAMQP(&fakeChannel{}, func(exchange, key string, msg amqp.Publishing) error {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		// Waits for the confirm.
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.Publish("", Message{RoutingKey: "LiveGame"}); err != nil {
+				t.Errorf("Publish() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Errorf("%d publishes at once, want 1: the confirms of a publisher are not shared", most)
+	}
+}
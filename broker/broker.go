@@ -0,0 +1,80 @@
+// Package broker publishes and consumes the messages of the services behind
+// two small interfaces, so the services run against RabbitMQ in production
+// and against an in-process broker in the tests and the demos.
+package broker
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned when a message is settled on a closed broker, or on
+// a channel lost since its delivery. The broker delivers the message again.
+var ErrClosed = errors.New("broker: closed")
+
+// Message is a message published on an exchange. The exchange routes it to
+// the queues by its routing key, the default exchange "" to the queue named
+// by the key.
+type Message struct {
+	RoutingKey  string
+	Headers     map[string]any
+	ContentType string
+	Timestamp   time.Time
+	Body        []byte
+}
+
+// Delivery is a message delivered to a subscriber. It must be settled once
+// with Ack or Nack.
+type Delivery struct {
+	Message
+	// Redelivered is set when the message was delivered before and not
+	// acknowledged.
+	Redelivered  bool
+	Acknowledger Acknowledger
+}
+
+// Acknowledger settles the deliveries of a broker.
+type Acknowledger interface {
+	// Ack removes the message from its queue.
+	Ack() error
+	// Nack puts the message back at its place in its queue when requeue is
+	// set, and drops it otherwise.
+	Nack(requeue bool) error
+}
+
+// Ack acknowledges the delivery.
+func (d Delivery) Ack() error {
+	return d.Acknowledger.Ack()
+}
+
+// Nack rejects the delivery, and requeues it when requeue is set.
+func (d Delivery) Nack(requeue bool) error {
+	return d.Acknowledger.Nack(requeue)
+}
+
+// Stale reports whether the delivery comes from a channel lost since, which
+// cannot settle it: the broker delivers it again.
+func (d Delivery) Stale() bool {
+	s, ok := d.Acknowledger.(interface{ Stale() bool })
+	return ok && s.Stale()
+}
+
+// Publisher publishes messages.
+type Publisher interface {
+	Publish(exchange string, msg Message) error
+}
+
+// Subscriber consumes the messages of a queue. At most prefetch deliveries
+// wait to be settled, 0 does not bound them. The deliveries end when the
+// subscriber is closed.
+type Subscriber interface {
+	Subscribe(queue string, prefetch int) (<-chan Delivery, error)
+}
+
+// Broker publishes and consumes messages until it is closed. Closing it
+// requeues the deliveries not settled yet.
+type Broker interface {
+	Publisher
+	Subscriber
+	Close() error
+}
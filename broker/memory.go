@@ -0,0 +1,375 @@
+package broker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// Kinds of the exchanges of the in-memory broker.
+const (
+	// Direct routes a message to the queues bound with its routing key.
+	Direct = "direct"
+	// Fanout routes a message to every bound queue.
+	Fanout = "fanout"
+	// Topic routes a message to the queues bound with a pattern matching its
+	// routing key, as RabbitMQ: the words of a key are separated by dots, *
+	// matches one word and # zero or more.
+	Topic = "topic"
+)
+
+// Memory is an in-process broker. Like RabbitMQ, a subscriber receives no
+// more than prefetch deliveries not settled, a requeued message goes back at
+// its place in its queue, and closing the broker requeues the deliveries not
+// settled. The messages are lost when the process exits.
+type Memory struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queues    map[string]*memoryQueue
+	exchanges map[string]*memoryExchange
+	nextTag   uint64
+	closed    bool
+	// done is closed with the broker, to stop the subscribers.
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type memoryQueue struct {
+	ready   []memoryMessage
+	unacked map[uint64]memoryMessage
+	nextSeq int
+	stats   QueueStats
+}
+
+// memoryMessage is a message of a queue, seq is its place in the queue.
+type memoryMessage struct {
+	seq         int
+	msg         Message
+	redelivered bool
+}
+
+type memoryExchange struct {
+	kind     string
+	bindings []binding
+}
+
+type binding struct {
+	queue string
+	key   string
+}
+
+// QueueStats counts the messages of a queue of the in-memory broker.
+type QueueStats struct {
+	// Ready messages wait for a subscriber.
+	Ready int
+	// Unacked messages are delivered and not settled yet, MaxUnacked is the
+	// most of them at once.
+	Unacked    int
+	MaxUnacked int
+	// Acked messages were acknowledged.
+	Acked int
+}
+
+// NewMemory returns an in-memory broker without queues.
+func NewMemory() *Memory {
+	m := &Memory{
+		queues:    make(map[string]*memoryQueue),
+		exchanges: make(map[string]*memoryExchange),
+		done:      make(chan struct{}),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// DeclareQueue declares a queue, if it does not exist.
+func (m *Memory) DeclareQueue(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.queues[name]; !ok {
+		m.queues[name] = &memoryQueue{unacked: make(map[uint64]memoryMessage)}
+	}
+}
+
+// DeclareExchange declares an exchange of kind Direct, Fanout or Topic, if it
+// does not exist.
+func (m *Memory) DeclareExchange(name, kind string) error {
+	if kind != Direct && kind != Fanout && kind != Topic {
+		return fmt.Errorf("broker: unknown exchange kind %s", kind)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.exchanges[name]; ok {
+		if e.kind != kind {
+			return fmt.Errorf("broker: exchange %s is %s, not %s", name, e.kind, kind)
+		}
+		return nil
+	}
+	m.exchanges[name] = &memoryExchange{kind: kind}
+	return nil
+}
+
+// BindQueue routes the messages of the exchange with the routing key to the
+// queue. The key is a pattern for a Topic exchange, and is ignored by a
+// Fanout exchange.
+func (m *Memory) BindQueue(queue, key, exchange string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.queues[queue]; !ok {
+		return fmt.Errorf("broker: no queue %s", queue)
+	}
+	e, ok := m.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("broker: no exchange %s", exchange)
+	}
+	e.bindings = append(e.bindings, binding{queue: queue, key: key})
+	return nil
+}
+
+// Publish routes the message to the queues of the exchange. A message no
+// queue is bound for is dropped, as RabbitMQ does.
+func (m *Memory) Publish(exchange string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	queues, err := m.route(exchange, msg.RoutingKey)
+	if err != nil {
+		return err
+	}
+	for _, name := range queues {
+		q := m.queues[name]
+		headers := make(map[string]any, len(msg.Headers))
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		copied := msg
+		copied.Headers = headers
+		q.ready = append(q.ready, memoryMessage{seq: q.nextSeq, msg: copied})
+		q.nextSeq++
+	}
+	m.cond.Broadcast()
+	return nil
+}
+
+// route returns the queues of a message, once each. It must be called with
+// the mutex held.
+func (m *Memory) route(exchange, key string) ([]string, error) {
+	if exchange == "" {
+		if _, ok := m.queues[key]; !ok {
+			return nil, fmt.Errorf("broker: no queue %s", key)
+		}
+		return []string{key}, nil
+	}
+	e, ok := m.exchanges[exchange]
+	if !ok {
+		return nil, fmt.Errorf("broker: no exchange %s", exchange)
+	}
+	var queues []string
+	seen := make(map[string]bool)
+	for _, b := range e.bindings {
+		if seen[b.queue] || (e.kind == Direct && b.key != key) || (e.kind == Topic && !matchTopic(b.key, key)) {
+			continue
+		}
+		seen[b.queue] = true
+		queues = append(queues, b.queue)
+	}
+	return queues, nil
+}
+
+// matchTopic reports whether the routing key matches the pattern of a
+// binding of a Topic exchange.
+func matchTopic(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	}
+	return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+}
+
+// Subscribe consumes the queue until the broker is closed.
+func (m *Memory) Subscribe(queue string, prefetch int) (<-chan Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil, fmt.Errorf("broker: no queue %s", queue)
+	}
+	deliveries := make(chan Delivery)
+	m.wg.Add(1)
+	go m.consume(q, &subscription{prefetch: prefetch}, deliveries)
+	return deliveries, nil
+}
+
+// subscription counts the deliveries of a subscriber not settled yet.
+type subscription struct {
+	prefetch int
+	unacked  int
+}
+
+func (m *Memory) consume(q *memoryQueue, sub *subscription, deliveries chan<- Delivery) {
+	defer m.wg.Done()
+	defer close(deliveries)
+	for {
+		m.mu.Lock()
+		for !m.closed && (len(q.ready) == 0 || (sub.prefetch > 0 && sub.unacked >= sub.prefetch)) {
+			m.cond.Wait()
+		}
+		if m.closed {
+			m.mu.Unlock()
+			return
+		}
+		msg := q.ready[0]
+		q.ready = q.ready[1:]
+		m.nextTag++
+		tag := m.nextTag
+		q.unacked[tag] = msg
+		q.stats.MaxUnacked = max(q.stats.MaxUnacked, len(q.unacked))
+		sub.unacked++
+		m.mu.Unlock()
+
+		// The message is unacked while it is sent: Close requeues it when
+		// the subscriber does not take it.
+		d := Delivery{Message: msg.msg, Redelivered: msg.redelivered, Acknowledger: memoryAcknowledger{m, q, sub, tag}}
+		select {
+		case deliveries <- d:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+type memoryAcknowledger struct {
+	m   *Memory
+	q   *memoryQueue
+	sub *subscription
+	tag uint64
+}
+
+// settle removes the delivery from the unacked messages, and counts it as
+// acknowledged or requeues it.
+func (a memoryAcknowledger) settle(ack, requeue bool) error {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+	if a.m.closed {
+		return ErrClosed
+	}
+	msg, ok := a.q.unacked[a.tag]
+	if !ok {
+		return fmt.Errorf("broker: delivery %d already settled", a.tag)
+	}
+	delete(a.q.unacked, a.tag)
+	a.sub.unacked--
+	switch {
+	case ack:
+		a.q.stats.Acked++
+	case requeue:
+		a.q.requeue(msg)
+	}
+	a.m.cond.Broadcast()
+	return nil
+}
+
+func (a memoryAcknowledger) Ack() error {
+	return a.settle(true, false)
+}
+
+func (a memoryAcknowledger) Nack(requeue bool) error {
+	return a.settle(false, requeue)
+}
+
+// requeue puts a message back at its place.
+func (q *memoryQueue) requeue(msg memoryMessage) {
+	msg.redelivered = true
+	i := sort.Search(len(q.ready), func(i int) bool { return q.ready[i].seq > msg.seq })
+	q.ready = append(q.ready[:i], append([]memoryMessage{msg}, q.ready[i:]...)...)
+}
+
+// Close stops the subscribers, and requeues the deliveries not settled.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, q := range m.queues {
+		for tag, msg := range q.unacked {
+			delete(q.unacked, tag)
+			q.requeue(msg)
+		}
+	}
+	close(m.done)
+	m.cond.Broadcast()
+	m.mu.Unlock()
+	m.wg.Wait()
+	return nil
+}
+
+// Stats returns the counts of the queue.
+func (m *Memory) Stats(queue string) QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q, ok := m.queues[queue]
+	if !ok {
+		return QueueStats{}
+	}
+	stats := q.stats
+	stats.Ready = len(q.ready)
+	stats.Unacked = len(q.unacked)
+	return stats
+}
+
+// Messages returns the messages waiting in the queue, in order.
+func (m *Memory) Messages(queue string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil
+	}
+	messages := make([]Message, len(q.ready))
+	for i, msg := range q.ready {
+		messages[i] = msg.msg
+	}
+	return messages
+}
+
+// ExchangeDeclare, QueueDeclare and QueueBind are the declarations of an
+// AMQP channel, so the declarations shared with RabbitMQ, as the ones of the
+// queue package, apply to the in-memory broker. The flags and the arguments
+// are ignored.
+
+func (m *Memory) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return m.DeclareExchange(name, kind)
+}
+
+func (m *Memory) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	m.DeclareQueue(name)
+	stats := m.Stats(name)
+	return amqp.Queue{Name: name, Messages: stats.Ready}, nil
+}
+
+func (m *Memory) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return m.BindQueue(name, key, exchange)
+}
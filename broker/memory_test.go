@@ -0,0 +1,204 @@
+package broker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("the deliveries are closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+		return Delivery{}
+	}
+}
+
+func noDelivery(t *testing.T, deliveries <-chan Delivery) {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		t.Fatalf("delivery %s, want none", d.Body)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func publish(t *testing.T, b Publisher, exchange, key string, bodies ...string) {
+	t.Helper()
+	for _, body := range bodies {
+		if err := b.Publish(exchange, Message{RoutingKey: key, Body: []byte(body)}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+}
+
+func TestMemoryRequeuesAtItsPlace(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+	b.DeclareQueue("actions")
+	publish(t, b, "", "actions", "1", "2", "3")
+	deliveries, err := b.Subscribe("actions", 2)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	first, second := receive(t, deliveries), receive(t, deliveries)
+	// The prefetch holds the third one until a delivery is settled.
+	noDelivery(t, deliveries)
+	if stats := b.Stats("actions"); stats.Unacked != 2 || stats.Ready != 1 {
+		t.Errorf("Stats() = %+v, want 2 unacked and 1 ready", stats)
+	}
+	if err := second.Nack(true); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	again := receive(t, deliveries)
+	if string(again.Body) != "2" || !again.Redelivered {
+		t.Errorf("delivery = %s redelivered %v, want 2 redelivered before 3", again.Body, again.Redelivered)
+	}
+	if err := first.Ack(); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	for _, d := range []Delivery{again, receive(t, deliveries)} {
+		if err := d.Ack(); err != nil {
+			t.Errorf("Ack() error = %v", err)
+		}
+	}
+	if err := first.Ack(); err == nil {
+		t.Errorf("Ack() of a settled delivery should fail")
+	}
+	want := QueueStats{Acked: 3, MaxUnacked: 2}
+	if stats := b.Stats("actions"); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestMemoryCloseRequeues(t *testing.T) {
+	b := NewMemory()
+	b.DeclareQueue("actions")
+	publish(t, b, "", "actions", "1", "2", "3")
+	deliveries, err := b.Subscribe("actions", 0)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	first := receive(t, deliveries)
+	if err := first.Ack(); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	second := receive(t, deliveries)
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Errorf("the deliveries should be closed with the broker")
+	}
+	if err := second.Ack(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Ack() error = %v, want %v", err, ErrClosed)
+	}
+	if err := b.Publish("", Message{RoutingKey: "actions"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrClosed)
+	}
+	var bodies []string
+	for _, msg := range b.Messages("actions") {
+		bodies = append(bodies, string(msg.Body))
+	}
+	if want := []string{"2", "3"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("Messages() = %v, want %v", bodies, want)
+	}
+}
+
+func TestMemoryRoutes(t *testing.T) {
+	tests := map[string]struct {
+		kind string
+		key  string
+		want map[string]int
+	}{
+		"Fanout":             {Fanout, "any", map[string]int{"scores": 1, "stats": 1}},
+		"Direct":             {Direct, "score", map[string]int{"scores": 1, "stats": 0}},
+		"Direct without key": {Direct, "foul", map[string]int{"scores": 0, "stats": 0}},
+		"Topic":              {Topic, "stat", map[string]int{"scores": 0, "stats": 1}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := NewMemory()
+			defer b.Close()
+			b.DeclareQueue("scores")
+			b.DeclareQueue("stats")
+			if err := b.DeclareExchange("actions", tc.kind); err != nil {
+				t.Fatalf("DeclareExchange() error = %v", err)
+			}
+			for queue, key := range map[string]string{"scores": "score", "stats": "stat"} {
+				if err := b.BindQueue(queue, key, "actions"); err != nil {
+					t.Fatalf("BindQueue() error = %v", err)
+				}
+			}
+			headers := map[string]any{"x-game": "Boston_Knicks"}
+			if err := b.Publish("actions", Message{RoutingKey: tc.key, Headers: headers, Body: []byte("action")}); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			for queue, want := range tc.want {
+				messages := b.Messages(queue)
+				if len(messages) != want {
+					t.Fatalf("%d messages in %s, want %d", len(messages), queue, want)
+				}
+				if want > 0 && (messages[0].RoutingKey != tc.key || !reflect.DeepEqual(messages[0].Headers, headers)) {
+					t.Errorf("message = %+v, want the routing key and the headers published", messages[0])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryErrors(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+	b.DeclareQueue("actions")
+	if err := b.DeclareExchange("actions", "headers"); err == nil {
+		t.Errorf("DeclareExchange() of an unknown kind should fail")
+	}
+	if err := b.Publish("", Message{RoutingKey: "unknown"}); err == nil {
+		t.Errorf("Publish() to an unknown queue should fail")
+	}
+	if err := b.Publish("unknown", Message{}); err == nil {
+		t.Errorf("Publish() to an unknown exchange should fail")
+	}
+	if err := b.BindQueue("actions", "", "unknown"); err == nil {
+		t.Errorf("BindQueue() to an unknown exchange should fail")
+	}
+	if _, err := b.Subscribe("unknown", 0); err == nil {
+		t.Errorf("Subscribe() to an unknown queue should fail")
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := map[string]struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		"Same key":            {"game.Boston_Knicks.foul", "game.Boston_Knicks.foul", true},
+		"Other key":           {"game.Boston_Knicks.foul", "game.Lakers_Bulls.foul", false},
+		"One word":            {"game.*.foul", "game.Boston_Knicks.foul", true},
+		"One word, not two":   {"game.*", "game.Boston_Knicks.foul", false},
+		"One word, not zero":  {"game.*.foul", "game.foul", false},
+		"Zero or more words":  {"game.#", "game.Boston_Knicks.end-of-game", true},
+		"Zero words":          {"game.Boston_Knicks.#", "game.Boston_Knicks", true},
+		"Words in the middle": {"game.#.end-of-game", "game.Boston_Knicks.end-of-game", true},
+		"Every key":           {"#", "game.Boston_Knicks.foul", true},
+		"Other prefix":        {"game.#", "match.Boston_Knicks.foul", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := matchTopic(tc.pattern, tc.key); got != tc.want {
+				t.Errorf("matchTopic(%q, %q) = %v, want %v", tc.pattern, tc.key, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	database "sync_score/cmd/database/db"
+	"sync_score/config"
+	ut "sync_score/utils"
+)
+
+// Moves the finished games out of the live database into compressed archive
+// files, and back:
+//
+//	archive archive -database.path ./games.db -database.archiveDir ./archive -before 2025-08-01
+//	archive unarchive -database.path ./games.db -database.archiveDir ./archive Boston_Knicks
+//	archive list -database.archiveDir ./archive
+//
+// The database server reads the archived games from the same directory,
+// database.archiveDir of the configuration, but it must be stopped while
+// games are archived or unarchived.
+
+const usage = "usage: archive archive|unarchive|list [flags]"
+
+func main() {
+	if len(os.Args) < 2 {
+		ut.Fatal(usage)
+	}
+	ut.SetLogLevel(ut.InfoLevel)
+	switch os.Args[1] {
+	case "archive":
+		archive(os.Args[2:])
+	case "unarchive":
+		unarchive(os.Args[2:])
+	case "list":
+		list(os.Args[2:])
+	default:
+		ut.Fatal(usage)
+	}
+}
+
+// parse parses the flags of a command, with those of the configuration.
+func parse(flags *flag.FlagSet, args []string) *config.Config {
+	cfg := config.Default()
+	cfg.RegisterFlags(flags)
+	flags.Parse(args)
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Debugf("Configuration:\n%s", cfg)
+	return cfg
+}
+
+func archive(args []string) {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	before := flags.String("before", "", "archive the ended games started before this date, as 2025-08-01")
+	olderThan := flags.Duration("olderThan", 0, "archive the ended games started longer ago than this duration")
+	cfg := parse(flags, args)
+
+	var cutoff time.Time
+	switch {
+	case *before != "" && *olderThan > 0:
+		ut.Fatal("only one of -before and -olderThan can be given")
+	case *before != "":
+		date, err := time.Parse(time.DateOnly, *before)
+		if err != nil {
+			ut.Fatalf("Invalid date %q: %v", *before, err)
+		}
+		cutoff = date
+	case *olderThan > 0:
+		cutoff = time.Now().Add(-*olderThan)
+	default:
+		ut.Fatal("one of -before and -olderThan is required")
+	}
+
+	db := database.NewDBWrapper(cfg.Database.Path)
+	defer db.Close()
+	db.SetArchiveDir(cfg.Database.ArchiveDir)
+	archived, err := db.ArchiveGames(cutoff)
+	for _, game := range archived {
+		fmt.Println(game)
+	}
+	if err != nil {
+		ut.Fatalf("Archive failed: %v", err)
+	}
+	ut.Infof("%d games archived in %s", len(archived), cfg.Database.ArchiveDir)
+}
+
+func unarchive(args []string) {
+	flags := flag.NewFlagSet("unarchive", flag.ExitOnError)
+	cfg := parse(flags, args)
+	if flags.NArg() == 0 {
+		ut.Fatal("usage: archive unarchive [-database.path path] [-database.archiveDir path] game...")
+	}
+
+	db := database.NewDBWrapper(cfg.Database.Path)
+	defer db.Close()
+	db.SetArchiveDir(cfg.Database.ArchiveDir)
+	for _, game := range flags.Args() {
+		if err := db.UnarchiveGame(game); err != nil {
+			ut.Fatalf("Unarchive of %s failed: %v", game, err)
+		}
+		ut.Infof("%s unarchived", game)
+	}
+}
+
+func list(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	cfg := parse(flags, args)
+
+	paths, err := filepath.Glob(filepath.Join(cfg.Database.ArchiveDir, "*.json.gz"))
+	if err != nil {
+		ut.Fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "game\tseason\tstarted at\tactions")
+	for _, path := range paths {
+		archive, err := database.ReadArchive(path)
+		if err != nil {
+			ut.Fatal(err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", archive.Game.GamePoster, archive.Game.Season,
+			archive.Game.StartedAt.Format(time.DateTime), len(archive.Actions))
+	}
+	w.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"sync_score/backfill"
+	database "sync_score/cmd/database/db"
+	"sync_score/config"
+	ut "sync_score/utils"
+)
+
+// Loads historic games straight into the SQLite database, for example:
+//
+//	backfill -database.path ./games.db client/gamesRecorded.json client/data
+//
+// Every argument is a game file, a json list of game files or a directory.
+
+var (
+	date = flag.String("date", "", "the date of the imported games, as 2025-01-31, the day of the import when empty")
+	cfg  = config.Default()
+)
+
+func init() {
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
+func main() {
+	flag.Parse()
+	ut.SetLogLevel(ut.InfoLevel)
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Debugf("Configuration:\n%s", cfg)
+	if flag.NArg() == 0 {
+		ut.Fatal("No game file to import")
+	}
+
+	opts := backfill.Options{Progress: os.Stdout}
+	if *date != "" {
+		startedAt, err := time.Parse(time.DateOnly, *date)
+		if err != nil {
+			ut.Fatalf("Invalid date %q: %v", *date, err)
+		}
+		opts.StartedAt = startedAt
+	}
+
+	var files []string
+	for _, arg := range flag.Args() {
+		found, err := backfill.GameFiles(arg)
+		if err != nil {
+			ut.Fatalf("Failed to list the game files of %s: %v", arg, err)
+		}
+		files = append(files, found...)
+	}
+
+	db := database.NewDBWrapper(cfg.Database.Path)
+	report := backfill.Import(db, files, opts)
+	db.Close()
+
+	ut.Infof("%d games imported (%d actions), %d skipped, %d files in error",
+		len(report.Imported), report.Actions, len(report.Skipped), len(report.Errors))
+	for _, err := range report.Errors {
+		ut.Info(err)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
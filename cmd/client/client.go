@@ -1,24 +1,29 @@
 package main
 
 import (
-	// "context"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"sync_score/broker"
+	"sync_score/config"
 	// pb "sync_score/proto"
+	"sync_score/queue"
 	sp "sync_score/sport"
 	ut "sync_score/utils"
 
+	"github.com/streadway/amqp"
 	// "google.golang.org/grpc"
 	// "google.golang.org/grpc/credentials/insecure"
-	"github.com/streadway/amqp"
 )
 
 const (
@@ -26,27 +31,120 @@ const (
 )
 
 var (
-	addr = flag.String("addr", "localhost:5672", "the address to connect to")
-	fileGames = flag.String("fileGames", "client/gamesRecorded.json", "the filepath to a json list, with filepath to recorded games.")
+	memoryBroker = flag.Bool("memoryBroker", false, "publish the actions on an in-process broker and print them, without RabbitMQ")
+	shutdownTimeout = flag.Duration("shutdownTimeout", 10*time.Second, "the maximum wait for the actions being published once the client is asked to stop")
+	queueOptions = queue.DefaultOptions
+	cfg = config.Default()
 )
 
-func getRabbitMQConnection() *amqp.Connection {
-	connection, err := amqp.Dial(fmt.Sprintf("amqp://guest:guest@%s/", *addr))
+func init() {
+	queueOptions.RegisterFlags(flag.CommandLine)
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
+// getRabbitMQDialer returns the dialer of RabbitMQ, the games share one
+// connection which connects again when RabbitMQ restarts.
+func getRabbitMQDialer() func() (queue.Connection, error) {
+	return queue.SharedDial(queue.Dial(cfg.RabbitMQ.URL()))
+}
+
+// topology returns the exchange of the actions and the queues of the
+// consumer groups, declared like the consumers do.
+func topology() queue.Topology {
+	return queue.NewTopology(cfg.Routing.Exchange, cfg.Routing.Groups())
+}
+
+// openRabbitMQ returns the publisher of a game on its own channel of the
+// connection shared, and the function closing it. Once ctx is done, the
+// confirms are no longer waited for and the publisher is closed, which fails
+// a publish waiting for RabbitMQ to come back.
+func openRabbitMQ(ctx context.Context, dial func() (queue.Connection, error)) (broker.Publisher, func() error, error) {
+	channel := queue.NewManager(dial, queueOptions, ut.Infof)
+	// declaring the exchange and the queues with their properties over the
+	// channel opened
+	if err := topology().Declare(channel, queueOptions); err != nil {
+		channel.Close()
+		return nil, nil, err
+	}
+	publisher, err := queue.NewPublisher(channel, cfg.Routing.Exchange, queueOptions)
 	if err != nil {
-		panic(err)
+		channel.Close()
+		return nil, nil, err
+	}
+	b := broker.NewAMQP(channel, func(exchange, key string, msg amqp.Publishing) error {
+		return publisher.PublishMessageContext(ctx, exchange, key, msg)
+	})
+	closed := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.Close()
+		case <-closed:
+		}
+	}()
+	return b, func() error {
+		close(closed)
+		return b.Close()
+	}, nil
+}
+
+// openMemory returns an in-process broker shared by the games, whose actions
+// are printed once consumed by each group, for the demos without RabbitMQ.
+func openMemory() (*broker.Memory, error) {
+	b := broker.NewMemory()
+	topology := topology()
+	if err := topology.Declare(b, queueOptions); err != nil {
+		return nil, err
+	}
+	for _, group := range topology.Groups {
+		deliveries, err := b.Subscribe(group.Queue, 0)
+		if err != nil {
+			return nil, err
+		}
+		go func(name string) {
+			for d := range deliveries {
+				ut.Infof("Consumed by %s: %s %s", name, d.RoutingKey, d.Body)
+				d.Ack()
+			}
+		}(group.Name)
 	}
-	return connection
+	return b, nil
 }
 
 func main() {
 	flag.Parse()
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Infof("Configuration:\n%s", cfg)
 	log.Println("The filepath trailing", flag.Args())
-	queueConnection := getRabbitMQConnection()
-	defer queueConnection.Close()
+	// The actions being published once the client is asked to stop are
+	// given up on after shutdownTimeout.
+	publishCtx, cancelPublishes := context.WithCancel(context.Background())
+	defer cancelPublishes()
+	dial := getRabbitMQDialer()
+	openPublisher := func() (broker.Publisher, func() error, error) {
+		return openRabbitMQ(publishCtx, dial)
+	}
+	if *memoryBroker {
+		memory, err := openMemory()
+		if err != nil {
+			ut.Fatal(err)
+		}
+		defer memory.Close()
+		openPublisher = func() (broker.Publisher, func() error, error) {
+			return memory, func() error { return nil }, nil
+		}
+	}
+
+	// SIGINT and SIGTERM stop the games, the actions being published are
+	// confirmed before the client exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Set up a connection to the server.
 	var err error
-	gamesPath, err := readInputGameFile(*fileGames)
+	gamesPath, err := readInputGameFile(cfg.Client.Games)
 	if err != nil {
 		ut.Fatalf("Error while loading files with the recorded games: %s", err)
 	}
@@ -62,11 +160,33 @@ func main() {
 		}
 		wg.Add(1)
 		
-		go sendGame(queueConnection, namePath, game, wg, i)
+		go func(gameName string, game sp.Actions, threadNumber int) {
+			defer wg.Done()
+			if err := sendGame(ctx, openPublisher, gameName, game, threadNumber); err != nil {
+				ut.Infof("Game %s not sent: %v", gameName, err)
+			}
+		}(namePath, game, i)
 	}
 
-	wg.Wait()
-	ut.Info("The games have all ended")
+	ended := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+		ut.Info("The games have all ended")
+	case <-ctx.Done():
+		ut.Info("Stopping the games...")
+		select {
+		case <-ended:
+			ut.Info("The games are stopped")
+		case <-time.After(*shutdownTimeout):
+			ut.Infof("Actions still being published after %v", *shutdownTimeout)
+			cancelPublishes()
+			<-ended
+		}
+	}
 }
 
 func readInputGameFile(path string) ([]string, error){
@@ -79,53 +199,52 @@ func readInputGameFile(path string) ([]string, error){
 	if err != nil {
 		ut.Fatal(err)
 	}
+	// The games listed are relative to the list, as for cmd/backfill.
+	for i, file := range input {
+		if !filepath.IsAbs(file) {
+			input[i] = filepath.Join(filepath.Dir(path), file)
+		}
+	}
 	return input, nil
 }
 
-func sendGame(conn *amqp.Connection, gameName string, game sp.Actions, wg *sync.WaitGroup, threadNumber int) {
-	defer wg.Done()
-	channel, err := conn.Channel()
-	if err != nil {
-		panic(err)
-	}
-	defer channel.Close()
-	// declaring queue with its properties over the the channel opened
-	_, err = channel.QueueDeclare(
-		"LiveGame", // name
-		false,     // durable
-		false,     // auto delete
-		false,     // exclusive
-		false,     // no wait
-		nil,       // args
-	)
+func sendGame(ctx context.Context, openPublisher func() (broker.Publisher, func() error, error), gameName string, game sp.Actions, threadNumber int) error {
+	publisher, closePublisher, err := openPublisher()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to open a publisher: %v", err)
 	}
+	defer closePublisher()
 	
 	ut.Infof("Game %s started: %s ", gameName, threadNumber)
 
+	// The end of the game closes it in the database, which stores its final score.
+	if len(game) > 0 && game[len(game)-1].Description != sp.EndOfGame {
+		last := game[len(game)-1]
+		game = append(game, sp.Action{GamePoster: last.GamePoster, Description: sp.EndOfGame, Minute: last.Minute})
+	}
+
 	current_time := int32(0)
 	var diff int32
-	for _, action := range game {
+	for i, action := range game {
 		diff = action.Minute - current_time		
-		time.Sleep(time.Duration(action.Minute - current_time) * 500*  time.Millisecond)
+		select {
+		case <-time.After(time.Duration(action.Minute - current_time) * 500*  time.Millisecond):
+		case <-ctx.Done():
+			ut.Infof("Game %s stopped at minute %d", gameName, current_time)
+			return nil
+		}
 		current_time += diff
 		fmt.Println(action)
 		body, _ := json.Marshal(action)
 		
-		// publishing a message
-		err = channel.Publish(
-			"",        // exchange
-			"LiveGame", // key
-			false,     // mandatory
-			false,     // immediate
-			amqp.Publishing{
-				ContentType: "text/plain",
-				Body:        body,
-			},
-		)
+		// publishing a message, confirmed by the broker, with its place in
+		// the game so the live cache skips it when it is delivered again
+		key := queue.RoutingKey(action.GamePoster, action.Description)
+		headers := map[string]any{queue.HeaderSequence: int64(i + 1)}
+		err = publisher.Publish(cfg.Routing.Exchange, broker.Message{RoutingKey: key, Headers: headers, ContentType: "text/plain", Body: body})
 		if err != nil {
-			log.Fatalf("could not publish: %v", err)
+			return fmt.Errorf("could not publish: %v", err)
 		}
 	}
+	return nil
 }
@@ -3,22 +3,42 @@ package main
 import (
 	"context"
 	_ "database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	_ "fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	database "sync_score/cmd/database/db"
+	"sync_score/config"
 	pb "sync_score/proto" // Update with your actual proto package path
 	sp "sync_score/sport"
 	ut "sync_score/utils"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+var cfg = config.Default()
+
+func init() {
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
 type GameEventServer struct {
 	pb.UnimplementedGameCenterServer
-	db database.DBWrapper
+	db database.Store
+	// snapshots is nil when the store cannot be backed up.
+	snapshots *database.Snapshotter
+	// tiebreakers order the standings when the request has none.
+	tiebreakers []sp.Tiebreaker
 }
 
 func (s *GameEventServer) SendGameAction(ctx context.Context, event *pb.Action) (*pb.ActionReply, error) {
@@ -33,7 +53,13 @@ func (s *GameEventServer) SendGameAction(ctx context.Context, event *pb.Action)
 		Description: event.Description,
 		Minute:      event.Minute,
 	}
-	s.db.SendToTables(act)
+	if err := s.db.SendToTables(act); err != nil {
+		ut.Info(err)
+		if errors.Is(err, database.ErrGameArchived) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to store action: %v", err)
+	}
 	// Return the same event (you could modify or add additional logic)
 	return &pb.ActionReply{Status: "received"}, nil
 }
@@ -41,9 +67,12 @@ func (s *GameEventServer) SendGameAction(ctx context.Context, event *pb.Action)
 func (s *GameEventServer) GetGameRecord(ctx context.Context, event *pb.GameTitle) (*pb.Actions, error) {
 
 	spActions, err := s.db.QueryGameHistoric(event.GamePoster)
+	if errors.Is(err, database.ErrGameNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
 	if err != nil {
 		ut.Debug(err)
-		ut.Fatal(err)
+		return nil, status.Errorf(codes.Internal, "failed to query game: %v", err)
 	}
 	// transform into protobuf messages
 	var actions pb.Actions
@@ -59,10 +88,162 @@ func (s *GameEventServer) GetGameRecord(ctx context.Context, event *pb.GameTitle
 	return &actions, nil
 }
 
+func (s *GameEventServer) GetPlayerStatistics(ctx context.Context, filter *pb.StatisticFilter) (*pb.PlayerStatistics, error) {
+	spFilter := database.StatisticFilter{
+		GamePoster: filter.GamePoster,
+		Season:     filter.Season,
+		PlayerName: filter.PlayerName,
+	}
+	if filter.From != 0 {
+		spFilter.From = time.Unix(filter.From, 0)
+	}
+	if filter.To != 0 {
+		spFilter.To = time.Unix(filter.To, 0)
+	}
+	stats, err := s.db.QueryPlayerSplits(spFilter)
+	if err != nil {
+		ut.Debug(err)
+		return nil, status.Errorf(codes.Internal, "failed to query statistics: %v", err)
+	}
+	var res pb.PlayerStatistics
+	for _, stat := range stats {
+		res.Elements = append(res.Elements, toPbPlayerStatistic(stat))
+	}
+	return &res, nil
+}
+
+func (s *GameEventServer) GetPlayerSeasons(ctx context.Context, player *pb.PlayerName) (*pb.SeasonStatistics, error) {
+	seasons, err := s.db.QueryPlayerSeasons(player.PlayerName)
+	if err != nil {
+		ut.Debug(err)
+		return nil, status.Errorf(codes.Internal, "failed to query seasons: %v", err)
+	}
+	var res pb.SeasonStatistics
+	for _, season := range seasons {
+		res.Elements = append(res.Elements, &pb.SeasonStatistic{
+			Season:    season.Season,
+			Statistic: toPbPlayerStatistic(season.PlayerStatistic),
+		})
+	}
+	return &res, nil
+}
+
+func (s *GameEventServer) Backup(ctx context.Context, req *pb.BackupRequest) (*pb.BackupReply, error) {
+	if s.snapshots == nil {
+		return nil, status.Error(codes.Unimplemented, "the store of the server cannot be backed up")
+	}
+	path, err := s.snapshots.Snapshot()
+	if err != nil {
+		ut.Info(err)
+		return nil, status.Errorf(codes.Internal, "failed to back up: %v", err)
+	}
+	ut.Infof("Snapshot written to %s", path)
+	return &pb.BackupReply{Path: path}, nil
+}
+
+func (s *GameEventServer) GetGameResults(ctx context.Context, filter *pb.ResultFilter) (*pb.GameResults, error) {
+	results, err := s.db.QueryGameResults(database.ResultFilter{
+		Season:   filter.Season,
+		Team:     filter.Team,
+		Opponent: filter.Opponent,
+	})
+	if err != nil {
+		ut.Debug(err)
+		return nil, status.Errorf(codes.Internal, "failed to query results: %v", err)
+	}
+	var res pb.GameResults
+	for _, result := range results {
+		res.Elements = append(res.Elements, toPbGameResult(result))
+	}
+	return &res, nil
+}
+
+func (s *GameEventServer) GetStandings(ctx context.Context, req *pb.StandingsRequest) (*pb.Standings, error) {
+	tiebreakers := s.tiebreakers
+	if len(req.Tiebreakers) > 0 {
+		var err error
+		tiebreakers, err = sp.ParseTiebreakers(strings.Join(req.Tiebreakers, ","))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	results, err := s.db.QueryGameResults(database.ResultFilter{Season: req.Season})
+	if err != nil {
+		ut.Debug(err)
+		return nil, status.Errorf(codes.Internal, "failed to query results: %v", err)
+	}
+	var res pb.Standings
+	for _, standing := range sp.Standings(results, tiebreakers) {
+		res.Elements = append(res.Elements, toPbStanding(standing))
+	}
+	return &res, nil
+}
+
+func (s *GameEventServer) GetHeadToHead(ctx context.Context, req *pb.HeadToHeadRequest) (*pb.HeadToHead, error) {
+	if req.Team == "" || req.Opponent == "" {
+		return nil, status.Error(codes.InvalidArgument, "both the team and the opponent are required")
+	}
+	results, err := s.db.QueryGameResults(database.ResultFilter{
+		Season:   req.Season,
+		Team:     req.Team,
+		Opponent: req.Opponent,
+	})
+	if err != nil {
+		ut.Debug(err)
+		return nil, status.Errorf(codes.Internal, "failed to query results: %v", err)
+	}
+	record := sp.HeadToHeadOf(results, req.Team, req.Opponent)
+	res := &pb.HeadToHead{Record: toPbStanding(record.Standing), Opponent: record.Opponent}
+	for _, game := range record.Games {
+		res.Games = append(res.Games, toPbGameResult(game))
+	}
+	return res, nil
+}
+
+func toPbGameResult(result sp.GameResult) *pb.GameResult {
+	return &pb.GameResult{
+		GamePoster: result.GamePoster,
+		Season:     result.Season,
+		StartedAt:  result.StartedAt.Unix(),
+		TeamA:      result.TeamA,
+		TeamB:      result.TeamB,
+		ScoreA:     result.ScoreA,
+		ScoreB:     result.ScoreB,
+	}
+}
+
+func toPbStanding(standing sp.Standing) *pb.Standing {
+	return &pb.Standing{
+		Team:          standing.Team,
+		Wins:          standing.Wins,
+		Losses:        standing.Losses,
+		WinPct:        standing.WinPct,
+		PointsFor:     standing.PointsFor,
+		PointsAgainst: standing.PointsAgainst,
+		Streak:        standing.Streak,
+	}
+}
+
+func toPbPlayerStatistic(stat sp.PlayerStatistic) *pb.PlayerStatistic {
+	return &pb.PlayerStatistic{
+		PlayerName:        stat.PlayerName,
+		TwoPointTry:       stat.TwoPointTry,
+		TwoPointSuccess:   stat.TwoPointSuccess,
+		ThreePointTry:     stat.ThreePointTry,
+		ThreePointSuccess: stat.ThreePointSuccess,
+		FreeThrowTry:      stat.FreeThrowTry,
+		FreeThrowSuccess:  stat.FreeThrowSuccess,
+		Foul:              stat.Foul,
+	}
+}
+
 func GetGameEventServer(dbName string) *GameEventServer {
-	db := database.NewDBWrapper(dbName)
-	t := &GameEventServer{db: db}
-	return t
+	return NewGameEventServer(database.NewDBWrapper(dbName))
+}
+
+// NewGameEventServer returns a server backed by any implementation of the store.
+func NewGameEventServer(store database.Store) *GameEventServer {
+	return &GameEventServer{db: store, tiebreakers: sp.DefaultTiebreakers}
 }
 
 func PrintSomething() {
@@ -70,8 +251,19 @@ func PrintSomething() {
 }
 
 func main() {
+	flag.Parse()
+	if err := cfg.Load(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	log.Printf("Configuration:\n%s", cfg)
+
+	// SIGINT and SIGTERM stop the server once the calls in flight are
+	// answered, and the pending writes are flushed to the database.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Create a listener on TCP port
-	lis, err := net.Listen("tcp", ":50051")
+	lis, err := net.Listen("tcp", cfg.Database.Listen)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
@@ -81,12 +273,60 @@ func main() {
 
 	// db := database.NewDBWrapper()
 
-	// Attach the GameCenterService implementation  TODO NewServer
-	pb.RegisterGameCenterServer(grpcServer, GetGameEventServer("./games.db"))
+	sqlite := database.NewDBWrapper(cfg.Database.Path)
+	sqlite.SetArchiveDir(cfg.Database.ArchiveDir)
+	var store database.Store = sqlite
+	if cfg.Database.BatchSize > 0 {
+		store = database.NewBatchWriter(sqlite, cfg.Database.BatchSize, cfg.Database.BatchDelay)
+	}
+
+	server := NewGameEventServer(store)
+	server.tiebreakers, err = sp.ParseTiebreakers(cfg.Database.Tiebreakers)
+	if err != nil {
+		store.Close()
+		log.Fatalf("Invalid database.tiebreakers: %v", err)
+	}
+	server.snapshots = database.NewSnapshotter(sqlite, cfg.Database.SnapshotDir, cfg.Database.SnapshotKeep)
+	if cfg.Database.SnapshotEvery > 0 {
+		go server.snapshots.Run(ctx, cfg.Database.SnapshotEvery)
+	}
+
+	// Attach the GameCenterService implementation
+	pb.RegisterGameCenterServer(grpcServer, server)
 
 	// Start serving
-	log.Println("Starting gRPC server on port 50051...")
-	if err := grpcServer.Serve(lis); err != nil {
+	log.Printf("Starting gRPC server on %s...", cfg.Database.Listen)
+	served := make(chan error, 1)
+	go func() {
+		served <- grpcServer.Serve(lis)
+	}()
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		log.Println("Shutting down, waiting for the calls in flight...")
+		stopGracefully(grpcServer, cfg.Database.ShutdownTimeout)
+	}
+	if closeErr := store.Close(); closeErr != nil {
+		log.Printf("Failed to close the database: %v", closeErr)
+	}
+	if err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
+	log.Println("Server stopped")
+}
+
+// stopGracefully stops the server once the calls in flight are answered, or
+// cancels them after timeout.
+func stopGracefully(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("Calls still in flight after %v, cancelling them", timeout)
+		server.Stop()
+	}
 }
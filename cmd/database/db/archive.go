@@ -0,0 +1,359 @@
+package db
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+// The actions of the finished games can be moved out of the live database
+// into an archive file per game. The games table keeps the metadata of an
+// archived game and playerGameStatistic its statistics, so the splits still
+// count it; only the per game table is dropped. The history of an archived
+// game is read from its file, when the wrapper knows the archive directory.
+
+const (
+	archiveFormat  = "statistic-syncer/game-archive"
+	archiveVersion = 1
+)
+
+var (
+	// ErrNoArchiveDir is returned when an archived game is needed but the
+	// archive directory of the wrapper is not set.
+	ErrNoArchiveDir = errors.New("archive directory not set")
+	// ErrGameLive is returned when unarchiving a game which is in the live database.
+	ErrGameLive = errors.New("game is in the live database")
+	// ErrGameArchived is returned when storing an action of an archived game.
+	ErrGameArchived = errors.New("game is archived")
+)
+
+// GameArchive is the content of an archive file: a gzip compressed json
+// document, which can be read without the database.
+type GameArchive struct {
+	Format   string            `json:"format"`
+	Version  int               `json:"version"`
+	Game     sp.GameInfo       `json:"game"`
+	Actions  sp.Actions        `json:"actions"`
+	BoxScore []sp.BoxScoreLine `json:"boxScore"`
+}
+
+// SetArchiveDir sets the directory of the archive files. It must be called
+// before the wrapper is used.
+func (db *DBWrapper) SetArchiveDir(dir string) {
+	db.archiveDir = dir
+}
+
+func (db *DBWrapper) archivePath(gamePoster string) (string, error) {
+	if db.archiveDir == "" {
+		return "", ErrNoArchiveDir
+	}
+	return filepath.Join(db.archiveDir, gamePoster+".json.gz"), nil
+}
+
+// ReadArchive reads an archive file.
+func ReadArchive(path string) (GameArchive, error) {
+	var archive GameArchive
+	f, err := os.Open(path)
+	if err != nil {
+		return archive, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return archive, err
+	}
+	defer zr.Close()
+	if err := json.NewDecoder(zr).Decode(&archive); err != nil {
+		return archive, err
+	}
+	if archive.Format != archiveFormat || archive.Version != archiveVersion {
+		return archive, fmt.Errorf("%s: unknown archive format %s version %d", path, archive.Format, archive.Version)
+	}
+	return archive, nil
+}
+
+func writeArchive(path string, archive GameArchive) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	zw.Name = filepath.Base(path)
+	enc := json.NewEncoder(zw)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(archive)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readArchivedActions returns the history of an archived game.
+func (db *DBWrapper) readArchivedActions(gamePoster string) (sp.Actions, error) {
+	path, err := db.archivePath(gamePoster)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ReadArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	return archive.Actions, nil
+}
+
+// ArchiveGames moves the ended games started before the cutoff out of the
+// live database, and returns their names. A game still in progress is left
+// live. The archive file of a game is written before its table is dropped: a
+// failure leaves the game live.
+func (db *DBWrapper) ArchiveGames(cutoff time.Time) ([]string, error) {
+	if db.archiveDir == "" {
+		return nil, ErrNoArchiveDir
+	}
+	var archived []string
+	err := db.writer.do(func() error {
+		games, err := db.endedGamesBefore(cutoff)
+		if err != nil {
+			return err
+		}
+		for _, game := range games {
+			if err := db.archiveGame(game); err != nil {
+				return fmt.Errorf("%s: %w", game.GamePoster, err)
+			}
+			archived = append(archived, game.GamePoster)
+		}
+		return nil
+	})
+	return archived, err
+}
+
+func (db *DBWrapper) endedGamesBefore(cutoff time.Time) ([]sp.GameInfo, error) {
+	rows, err := db.clientDB.Query(`SELECT gamePoster, season, startedAt FROM games
+		WHERE archived = 0 AND startedAt < ? ORDER BY startedAt, gamePoster;`, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var games []sp.GameInfo
+	for rows.Next() {
+		var game sp.GameInfo
+		var startedAt int64
+		if err := rows.Scan(&game.GamePoster, &game.Season, &startedAt); err != nil {
+			return nil, err
+		}
+		game.StartedAt = time.Unix(startedAt, 0).UTC()
+		games = append(games, game)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// A registered game without any action has no table to archive.
+	var ended []sp.GameInfo
+	for _, game := range games {
+		exists, err := db.hasTable(game.GamePoster)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		over, err := db.gameEnded(game.GamePoster)
+		if err != nil {
+			return nil, err
+		}
+		if over {
+			ended = append(ended, game)
+		}
+	}
+	return ended, nil
+}
+
+// gameEnded reports whether a live game has a result or an end of game action.
+func (db *DBWrapper) gameEnded(gamePoster string) (bool, error) {
+	var ended bool
+	err := db.clientDB.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM gameResults WHERE gamePoster = ?)
+		OR EXISTS (SELECT 1 FROM %s WHERE description = ?);`, gamePoster), gamePoster, sp.EndOfGame).Scan(&ended)
+	return ended, err
+}
+
+// checkNotArchived fails with ErrGameArchived when the game is archived: a
+// late action would start a new table beside its archive.
+func checkNotArchived(tx *writeTx, gamePoster string) error {
+	var archived bool
+	err := tx.QueryRow(`SELECT archived FROM games WHERE gamePoster = ?;`, gamePoster).Scan(&archived)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if archived {
+		return fmt.Errorf("%w: %s", ErrGameArchived, gamePoster)
+	}
+	return nil
+}
+
+// archiveGame must only be called by the owner goroutine of the writes.
+func (db *DBWrapper) archiveGame(game sp.GameInfo) error {
+	actions, err := queryGameActions(db.clientDB, game.GamePoster)
+	if err != nil {
+		return err
+	}
+	path, err := db.archivePath(game.GamePoster)
+	if err != nil {
+		return err
+	}
+	err = writeArchive(path, GameArchive{
+		Format:   archiveFormat,
+		Version:  archiveVersion,
+		Game:     game,
+		Actions:  actions,
+		BoxScore: sp.BoxScore(actions),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.beginWrite()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`DROP TABLE %s;`, game.GamePoster))
+	if err == nil {
+		_, err = tx.Exec(`UPDATE games SET archived = 1 WHERE gamePoster = ?;`, game.GamePoster)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := db.commitWrite(tx); err != nil {
+		return err
+	}
+	delete(db.cachedTableNames, game.GamePoster)
+	return nil
+}
+
+// UnarchiveGame moves an archived game back into the live database. Its
+// statistics were kept, so only its per game table is restored.
+func (db *DBWrapper) UnarchiveGame(gamePoster string) error {
+	path, err := db.archivePath(gamePoster)
+	if err != nil {
+		return err
+	}
+	return db.writer.do(func() error {
+		exists, err := db.hasTable(gamePoster)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: %s", ErrGameLive, gamePoster)
+		}
+		archive, err := ReadArchive(path)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.beginWrite()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE games SET archived = 0 WHERE gamePoster = ?;`, gamePoster)
+		if err == nil {
+			for _, action := range archive.Actions {
+				if err = db.addEntryToPerGameTable(tx, action); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := db.commitWrite(tx); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	})
+}
+
+// replayGame is a game whose actions are replayed by the rebuilds.
+type replayGame struct {
+	name     string
+	archived bool
+}
+
+// gamesInReplayOrder returns the live and archived games by start of the
+// game, so the players get their ids in the order they first played.
+func (db *DBWrapper) gamesInReplayOrder() ([]replayGame, error) {
+	tables, err := db.gameTableNames()
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool)
+	for _, table := range tables {
+		live[table] = true
+	}
+
+	rows, err := db.clientDB.Query(`SELECT gamePoster, startedAt, archived FROM games;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	startedAt := make(map[string]int64)
+	var games []replayGame
+	for rows.Next() {
+		var game replayGame
+		var start int64
+		if err := rows.Scan(&game.name, &start, &game.archived); err != nil {
+			return nil, err
+		}
+		startedAt[game.name] = start
+		if game.archived && !live[game.name] {
+			if db.archiveDir == "" {
+				return nil, fmt.Errorf("%w: %s is archived", ErrNoArchiveDir, game.name)
+			}
+			games = append(games, game)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		games = append(games, replayGame{name: table})
+	}
+	sort.SliceStable(games, func(i, j int) bool {
+		if startedAt[games[i].name] != startedAt[games[j].name] {
+			return startedAt[games[i].name] < startedAt[games[j].name]
+		}
+		return games[i].name < games[j].name
+	})
+	return games, nil
+}
+
+func (db *DBWrapper) replayActions(q querier, game replayGame) (sp.Actions, error) {
+	if game.archived {
+		return db.readArchivedActions(game.name)
+	}
+	return queryGameActions(q, game.name)
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sp "sync_score/sport"
+)
+
+func TestArchiveGames(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db := NewDBWrapper(filepath.Join(dir, "games.db"))
+	defer db.Close()
+	db.SetArchiveDir(filepath.Join(dir, "archive"))
+	registerSampleSeasons(t, db)
+	endGame(t, db, "Boston_Knicks")
+	before, err := db.QueryPlayerSplits(StatisticFilter{})
+	if err != nil {
+		t.Fatalf("QueryPlayerSplits() error = %v", err)
+	}
+
+	archived, err := db.ArchiveGames(date(2025, 8, 1))
+	if err != nil {
+		t.Fatalf("ArchiveGames() error = %v", err)
+	}
+	// Bulls_cavaliers is still in progress.
+	if want := []string{"Boston_Knicks"}; !reflect.DeepEqual(archived, want) {
+		t.Errorf("ArchiveGames() = %v, want %v", archived, want)
+	}
+	if exists, _ := db.hasTable("Bulls_cavaliers"); !exists {
+		t.Errorf("the table of a game in progress should be kept")
+	}
+	if exists, _ := db.hasTable("Boston_Knicks"); exists {
+		t.Errorf("the table of an archived game should be dropped")
+	}
+	archive, err := ReadArchive(filepath.Join(dir, "archive", "Boston_Knicks.json.gz"))
+	if err != nil {
+		t.Fatalf("ReadArchive() error = %v", err)
+	}
+	if archive.Game.Season != "2024-25" || len(archive.BoxScore) != 4 {
+		t.Errorf("ReadArchive() = season %s and %d box score lines, want 2024-25 and 4", archive.Game.Season, len(archive.BoxScore))
+	}
+
+	// The history and the statistics of an archived game are still served.
+	got, err := db.QueryGameHistoric("Boston_Knicks")
+	if err != nil {
+		t.Fatalf("QueryGameHistoric() error = %v", err)
+	}
+	want := append(sampleGame("Boston_Knicks"), sp.Action{GamePoster: "Boston_Knicks", Description: sp.EndOfGame, Minute: 48})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryGameHistoric() = %v, want %v", got, want)
+	}
+
+	// A late action of an archived game is refused.
+	if err := db.SendToTables(sampleGame("Boston_Knicks")[0]); !errors.Is(err, ErrGameArchived) {
+		t.Errorf("SendToTables() of an archived game error = %v, want %v", err, ErrGameArchived)
+	}
+	if exists, _ := db.hasTable("Boston_Knicks"); exists {
+		t.Errorf("a late action should not create the table of an archived game")
+	}
+	if err := db.RebuildSplits(); err != nil {
+		t.Fatalf("RebuildSplits() error = %v", err)
+	}
+	after, err := db.QueryPlayerSplits(StatisticFilter{})
+	if err != nil || !reflect.DeepEqual(after, before) {
+		t.Errorf("QueryPlayerSplits() after archive = %v, %v, want %v", after, err, before)
+	}
+	if diffs, err := db.RebuildPlayerStatistic(true); err != nil || len(diffs) != 0 {
+		t.Errorf("RebuildPlayerStatistic() after archive = %v, %v, want no difference", diffs, err)
+	}
+
+	if archived, err := db.ArchiveGames(date(2025, 8, 1)); err != nil || len(archived) != 0 {
+		t.Errorf("second ArchiveGames() = %v, %v, want nothing archived", archived, err)
+	}
+}
+
+func TestUnarchiveGame(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db := NewDBWrapper(filepath.Join(dir, "games.db"))
+	defer db.Close()
+	db.SetArchiveDir(dir)
+	registerSampleSeasons(t, db)
+	endGame(t, db, "Boston_Knicks")
+	if _, err := db.ArchiveGames(date(2025, 8, 1)); err != nil {
+		t.Fatalf("ArchiveGames() error = %v", err)
+	}
+	before, err := db.QueryPlayerStatistics()
+	if err != nil {
+		t.Fatalf("QueryPlayerStatistics() error = %v", err)
+	}
+
+	if err := db.UnarchiveGame("Boston_Knicks"); err != nil {
+		t.Fatalf("UnarchiveGame() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Boston_Knicks.json.gz")); !os.IsNotExist(err) {
+		t.Errorf("the archive file should be removed, stat error = %v", err)
+	}
+	if exists, _ := db.hasTable("Boston_Knicks"); !exists {
+		t.Errorf("the table of an unarchived game should be restored")
+	}
+	after, err := db.QueryPlayerStatistics()
+	if err != nil || !reflect.DeepEqual(after, before) {
+		t.Errorf("QueryPlayerStatistics() after unarchive = %v, %v, want %v", after, err, before)
+	}
+	if err := db.UnarchiveGame("Boston_Knicks"); !errors.Is(err, ErrGameLive) {
+		t.Errorf("UnarchiveGame() of a live game error = %v, want %v", err, ErrGameLive)
+	}
+
+	// A new action of an unarchived game goes to its table.
+	sendAll(t, db, sampleGame("Boston_Knicks")[:1])
+	got, err := db.QueryGameHistoric("Boston_Knicks")
+	if err != nil || len(got) != len(sampleGame("Boston_Knicks"))+2 {
+		t.Errorf("QueryGameHistoric() = %d actions, %v, want %d", len(got), err, len(sampleGame("Boston_Knicks"))+2)
+	}
+}
+
+func TestArchiveNeedsArchiveDir(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "games.db")
+	db := NewDBWrapper(dbPath)
+	db.SetArchiveDir(dir)
+	registerSampleSeasons(t, db)
+	endGame(t, db, "Boston_Knicks")
+	if _, err := db.ArchiveGames(date(2025, 8, 1)); err != nil {
+		t.Fatalf("ArchiveGames() error = %v", err)
+	}
+	db.Close()
+
+	db = NewDBWrapper(dbPath)
+	defer db.Close()
+	if _, err := db.ArchiveGames(date(2026, 8, 1)); !errors.Is(err, ErrNoArchiveDir) {
+		t.Errorf("ArchiveGames() error = %v, want %v", err, ErrNoArchiveDir)
+	}
+	if _, err := db.QueryGameHistoric("Boston_Knicks"); !errors.Is(err, ErrNoArchiveDir) {
+		t.Errorf("QueryGameHistoric() error = %v, want %v", err, ErrNoArchiveDir)
+	}
+	if err := db.RebuildSplits(); !errors.Is(err, ErrNoArchiveDir) {
+		t.Errorf("RebuildSplits() error = %v, want %v", err, ErrNoArchiveDir)
+	}
+	if _, err := db.QueryGameHistoric("Unknown_Game"); !errors.Is(err, ErrGameNotFound) {
+		t.Errorf("QueryGameHistoric() of an unknown game error = %v, want %v", err, ErrGameNotFound)
+	}
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	ut "sync_score/utils"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// schemaVersion is stored in the user_version of the database. It must be
+// increased with every change of the tables, so an old snapshot is never
+// restored over a newer schema.
+const schemaVersion = 3
+
+// ErrSchemaVersion is returned when restoring a snapshot of another schema,
+// or opening a database of a newer one.
+var ErrSchemaVersion = errors.New("snapshot schema version mismatch")
+
+// readSchemaVersion returns the schema version of the database, 0 for a new
+// database or one older than the versions.
+func (db *DBWrapper) readSchemaVersion() int {
+	var version int
+	if err := db.clientDB.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		ut.Fatal(err)
+	}
+	return version
+}
+
+// checkSchemaVersion fails on a database of a newer schema, which this
+// version of the server must not alter.
+func checkSchemaVersion(version int) error {
+	if version > schemaVersion {
+		return fmt.Errorf("%w: database %d, expected at most %d", ErrSchemaVersion, version, schemaVersion)
+	}
+	return nil
+}
+
+// initSchemaVersion stores the current schema version in a database of an
+// older one, once its tables are created. Their creation migrates it: the
+// tables and the columns it misses are added.
+func (db *DBWrapper) initSchemaVersion(version int) {
+	if version == schemaVersion {
+		return
+	}
+	if _, err := db.clientDB.Exec(fmt.Sprintf(`PRAGMA user_version = %d;`, schemaVersion)); err != nil {
+		ut.Fatal(err)
+	}
+	if version > 0 {
+		ut.Infof("Database schema migrated from version %d to %d", version, schemaVersion)
+	}
+}
+
+// Backup writes a consistent snapshot of the database to path with the
+// online backup API of SQLite. The writes go on while the snapshot is taken.
+func (db *DBWrapper) Backup(path string) error {
+	ctx := context.Background()
+	src, err := db.clientDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	return dest.Raw(func(destConn any) error {
+		return src.Raw(func(srcConn any) error {
+			backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// Step returns false without error while the
+				// source is locked by a writer.
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	})
+}
+
+// Restore replaces the database at dbPath with a snapshot, after checking the
+// snapshot is sound and of the current schema version. The database server
+// must be stopped: the file is swapped under its feet.
+func Restore(snapshotPath, dbPath string) error {
+	if err := checkSnapshot(snapshotPath); err != nil {
+		return err
+	}
+
+	// Copy next to the database, so the rename is atomic.
+	content, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// The journal of the replaced database must not be replayed on the snapshot.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), dbPath)
+}
+
+func checkSnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	snapshot, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	var version int
+	if err := snapshot.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		return err
+	}
+	if version != schemaVersion {
+		return fmt.Errorf("%w: snapshot %d, expected %d", ErrSchemaVersion, version, schemaVersion)
+	}
+	var integrity string
+	if err := snapshot.QueryRow(`PRAGMA integrity_check;`).Scan(&integrity); err != nil {
+		return err
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("snapshot %s is corrupted: %s", path, integrity)
+	}
+	return nil
+}
+
+// Snapshotter takes snapshots of a database into a directory, and keeps only
+// the most recent ones.
+type Snapshotter struct {
+	db   *DBWrapper
+	dir  string
+	keep int
+	now  func() time.Time
+}
+
+// snapshotLayout names the snapshots, so they sort by date.
+const snapshotLayout = "20060102T150405.000Z"
+
+func NewSnapshotter(db *DBWrapper, dir string, keep int) *Snapshotter {
+	return &Snapshotter{db: db, dir: dir, keep: keep, now: time.Now}
+}
+
+// Snapshot writes a new snapshot, removes the oldest ones beyond the
+// retention, and returns the path of the new one.
+func (s *Snapshotter) Snapshot() (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("games-%s.db", s.now().UTC().Format(snapshotLayout)))
+	if err := s.db.Backup(path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, s.prune()
+}
+
+// Snapshots returns the snapshots of the directory, the oldest first.
+func (s *Snapshotter) Snapshots() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "games-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (s *Snapshotter) prune() error {
+	if s.keep <= 0 {
+		return nil
+	}
+	paths, err := s.Snapshots()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for len(paths) > s.keep {
+		errs = append(errs, os.Remove(paths[0]))
+		paths = paths[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// Run takes a snapshot every interval until the context is done.
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := s.Snapshot()
+			if err != nil {
+				ut.Infof("Snapshot failed: %v", err)
+				continue
+			}
+			ut.Infof("Snapshot written to %s", path)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+func TestBackupWhileWriting(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db := NewDBWrapper(filepath.Join(dir, "games.db"))
+	defer db.Close()
+	sendAll(t, db, sampleGame("Boston_Knicks"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			action := sp.Action{GamePoster: "Bulls_cavaliers", Team: "Bulls", PlayerName: "Coby White", Description: "2pts succes", Minute: int32(i)}
+			if err := db.SendToTables(action); err != nil {
+				t.Errorf("SendToTables() error = %v", err)
+				return
+			}
+		}
+	}()
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if err := db.Backup(snapshot); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	wg.Wait()
+
+	restored := NewDBWrapper(snapshot)
+	defer restored.Close()
+	got, err := restored.QueryGameHistoric("Boston_Knicks")
+	if err != nil || len(got) != len(sampleGame("Boston_Knicks")) {
+		t.Errorf("QueryGameHistoric() on the snapshot = %d actions, error %v", len(got), err)
+	}
+	// The snapshot holds a prefix of the concurrent game, and its
+	// statistics agree with it.
+	bulls, err := restored.QueryGameHistoric("Bulls_cavaliers")
+	if err != nil && !errors.Is(err, ErrGameNotFound) {
+		t.Fatalf("QueryGameHistoric() error = %v", err)
+	}
+	if len(bulls) > 0 {
+		stat, err := restored.QueryPlayerStatistic("Coby White")
+		if err != nil || int(stat.TwoPointSuccess) != len(bulls) {
+			t.Errorf("snapshot has %d actions of Coby White and statistic %+v, error %v", len(bulls), stat, err)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "games.db")
+	db := NewDBWrapper(dbPath)
+	sendAll(t, db, sampleGame("Boston_Knicks"))
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if err := db.Backup(snapshot); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	sendAll(t, db, sampleGame("Bulls_cavaliers"))
+	db.Close()
+
+	if err := Restore(snapshot, dbPath); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	db = NewDBWrapper(dbPath)
+	defer db.Close()
+	if _, err := db.QueryGameHistoric("Bulls_cavaliers"); !errors.Is(err, ErrGameNotFound) {
+		t.Errorf("the game written after the snapshot should be gone, error = %v", err)
+	}
+	if got, err := db.QueryGameHistoric("Boston_Knicks"); err != nil || len(got) != len(sampleGame("Boston_Knicks")) {
+		t.Errorf("QueryGameHistoric() after restore = %d actions, error %v", len(got), err)
+	}
+}
+
+func TestRestoreChecksSchemaVersion(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	old := NewDBWrapper(filepath.Join(dir, "old.db"))
+	if _, err := old.clientDB.Exec(fmt.Sprintf(`PRAGMA user_version = %d;`, schemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	dbPath := filepath.Join(dir, "games.db")
+	db := NewDBWrapper(dbPath)
+	sendAll(t, db, sampleGame("Boston_Knicks"))
+	db.Close()
+
+	if err := Restore(filepath.Join(dir, "old.db"), dbPath); !errors.Is(err, ErrSchemaVersion) {
+		t.Fatalf("Restore() error = %v, want %v", err, ErrSchemaVersion)
+	}
+	db = NewDBWrapper(dbPath)
+	defer db.Close()
+	if _, err := db.QueryGameHistoric("Boston_Knicks"); err != nil {
+		t.Errorf("a refused restore should leave the database, error = %v", err)
+	}
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := map[string]struct {
+		version int
+		wantErr error
+	}{
+		"New database":   {0, nil},
+		"Older schema":   {schemaVersion - 1, nil},
+		"Current schema": {schemaVersion, nil},
+		"Newer schema":   {schemaVersion + 1, ErrSchemaVersion},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := checkSchemaVersion(tc.version); !errors.Is(err, tc.wantErr) {
+				t.Errorf("checkSchemaVersion(%d) = %v, want %v", tc.version, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenMigratesAnOlderSchema(t *testing.T) {
+	t.Parallel()
+	dbPath := filepath.Join(t.TempDir(), "games.db")
+	db := NewDBWrapper(dbPath)
+	sendAll(t, db, sampleGame("Boston_Knicks"))
+	// The database of version 2 has no results yet.
+	for _, query := range []string{`DROP TABLE gameResults;`, `PRAGMA user_version = 2;`} {
+		if _, err := db.clientDB.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db = NewDBWrapper(dbPath)
+	defer db.Close()
+	if version := db.readSchemaVersion(); version != schemaVersion {
+		t.Errorf("schema version = %d, want %d", version, schemaVersion)
+	}
+	if _, err := db.QueryGameResults(ResultFilter{}); err != nil {
+		t.Errorf("QueryGameResults() error = %v, want the table of the results added", err)
+	}
+	if got, err := db.QueryGameHistoric("Boston_Knicks"); err != nil || len(got) != len(sampleGame("Boston_Knicks")) {
+		t.Errorf("QueryGameHistoric() after the migration = %d actions, error %v", len(got), err)
+	}
+}
+
+func TestSnapshotterRetention(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db := NewDBWrapper(filepath.Join(dir, "games.db"))
+	defer db.Close()
+
+	s := NewSnapshotter(db, filepath.Join(dir, "snapshots"), 2)
+	now := time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	var written []string
+	for i := 0; i < 4; i++ {
+		path, err := s.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot() error = %v", err)
+		}
+		written = append(written, path)
+		now = now.Add(time.Hour)
+	}
+
+	got, err := s.Snapshots()
+	if err != nil {
+		t.Fatalf("Snapshots() error = %v", err)
+	}
+	if len(got) != 2 || got[0] != written[2] || got[1] != written[3] {
+		t.Errorf("Snapshots() = %v, want the last 2 of %v", got, written)
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+// ErrWriterClosed is returned when an action is sent to a closed BatchWriter.
+var ErrWriterClosed = errors.New("batch writer closed")
+
+// BatchWriter groups the actions sent concurrently to a DBWrapper and writes
+// them in a single transaction. A batch is written once it holds maxBatch
+// actions or maxDelay after its first action, whichever comes first, so an
+// action never waits more than maxDelay before being committed.
+//
+// SendToTables blocks until the action is committed. The queries are answered
+// by the wrapped DBWrapper.
+type BatchWriter struct {
+	*DBWrapper
+	maxBatch int
+	maxDelay time.Duration
+
+	requests  chan batchRequest
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+}
+
+type batchRequest struct {
+	action sp.Action
+	result chan error
+}
+
+func NewBatchWriter(db *DBWrapper, maxBatch int, maxDelay time.Duration) *BatchWriter {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	w := &BatchWriter{
+		DBWrapper: db,
+		maxBatch:  maxBatch,
+		maxDelay:  maxDelay,
+		requests:  make(chan batchRequest, maxBatch),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *BatchWriter) SendToTables(action sp.Action) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrWriterClosed
+	}
+	result := make(chan error, 1)
+	w.requests <- batchRequest{action: action, result: result}
+	w.mu.RUnlock()
+	return <-result
+}
+
+// Close writes the pending actions and closes the database.
+func (w *BatchWriter) Close() error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.requests)
+		w.mu.Unlock()
+		<-w.done
+	})
+	return w.DBWrapper.Close()
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+	batch := make([]batchRequest, 0, w.maxBatch)
+	for first := range w.requests {
+		batch = append(batch[:0], first)
+		timer := time.NewTimer(w.maxDelay)
+	collect:
+		for len(batch) < w.maxBatch {
+			select {
+			case req, ok := <-w.requests:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		w.write(batch)
+	}
+}
+
+// write commits the batch. When the transaction fails, the actions are
+// written one by one so a single bad action only fails its own sender.
+func (w *BatchWriter) write(batch []batchRequest) {
+	actions := make(sp.Actions, len(batch))
+	for i, req := range batch {
+		actions[i] = req.action
+	}
+	err := w.DBWrapper.SendBatchToTables(actions)
+	if err == nil || len(batch) == 1 {
+		for _, req := range batch {
+			req.result <- err
+		}
+		return
+	}
+	for _, req := range batch {
+		req.result <- w.DBWrapper.SendToTables(req.action)
+	}
+}
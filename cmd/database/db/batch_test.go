@@ -0,0 +1,101 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+)
+
+func TestBatchWriterConcurrentSends(t *testing.T) {
+	t.Parallel()
+	w := NewBatchWriter(NewDBWrapper(filepath.Join(t.TempDir(), "games.db")), 8, 5*time.Millisecond)
+	defer w.Close()
+
+	const numGoroutines, numActions = 10, 20
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		go func(idx int) {
+			defer wg.Done()
+			for j := 0; j < numActions; j++ {
+				err := w.SendToTables(sp.Action{
+					GamePoster:  "Boston_Knicks",
+					Team:        "Boston",
+					PlayerName:  fmt.Sprintf("Player %d", idx),
+					Description: "2pts succes",
+					Minute:      int32(j),
+				})
+				if err != nil {
+					t.Errorf("SendToTables() error = %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := w.QueryGameHistoric("Boston_Knicks")
+	if err != nil {
+		t.Fatalf("QueryGameHistoric() error = %v", err)
+	}
+	if len(got) != numGoroutines*numActions {
+		t.Errorf("got %d actions, want %d", len(got), numGoroutines*numActions)
+	}
+	stat, err := w.QueryPlayerStatistic("Player 3")
+	if err != nil {
+		t.Fatalf("QueryPlayerStatistic() error = %v", err)
+	}
+	if stat.TwoPointSuccess != numActions {
+		t.Errorf("2pts success = %d, want %d", stat.TwoPointSuccess, numActions)
+	}
+}
+
+func TestBatchWriterClosed(t *testing.T) {
+	t.Parallel()
+	w := NewBatchWriter(NewDBWrapper(filepath.Join(t.TempDir(), "games.db")), 8, time.Millisecond)
+	w.Close()
+	if err := w.SendToTables(sampleGame("Boston_Knicks")[0]); err != ErrWriterClosed {
+		t.Errorf("SendToTables() error = %v, want %v", err, ErrWriterClosed)
+	}
+}
+
+// The benchmarks write from 16 concurrent senders, as the gRPC handlers do.
+// Run them with:
+//
+//	go test -run XXX -bench SendToTables ./cmd/database/db
+func BenchmarkSendToTables(b *testing.B) {
+	ut.SetLogLevel(ut.InfoLevel)
+	defer ut.SetLogLevel(ut.DebugLevel)
+
+	b.Run("transaction per action", func(b *testing.B) {
+		db := NewDBWrapper(filepath.Join(b.TempDir(), "games.db"))
+		defer db.Close()
+		benchmarkSends(b, db.SendToTables)
+	})
+	b.Run("batched", func(b *testing.B) {
+		w := NewBatchWriter(NewDBWrapper(filepath.Join(b.TempDir(), "games.db")), 64, 2*time.Millisecond)
+		defer w.Close()
+		benchmarkSends(b, w.SendToTables)
+	})
+}
+
+func benchmarkSends(b *testing.B, send func(sp.Action) error) {
+	game := sampleGame("Boston_Knicks")
+	var n atomic.Int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			if err := send(game[int(i)%len(game)]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -3,30 +3,60 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
-	ut "sync_score/utils"
 	sp "sync_score/sport"
+	ut "sync_score/utils"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
+// DBWrapper is the SQLite implementation of Store. Every game is stored in its
+// own table, and the aggregated statistics in the playerStatistic table.
+//
+// A DBWrapper is safe for concurrent use. The writes are serialized on one
+// goroutine, which alone reads and writes the caches below.
 type DBWrapper struct {
 	clientDB         *sql.DB
+	writer           *writer
 	cachedTableNames map[string]bool
 	cachePlayerID    map[string]int
+	// now dates the games which were not registered before their first action.
+	now func() time.Time
+	// archiveDir holds the files of the archived games, see ArchiveGames.
+	archiveDir string
 }
 
-func NewDBWrapper(dbName string) DBWrapper{
-	db := DBWrapper{
+func NewDBWrapper(dbName string) *DBWrapper {
+	db := &DBWrapper{
 		clientDB:         getSQLDB(dbName),
 		cachedTableNames: make(map[string]bool),
 		cachePlayerID:    make(map[string]int),
+		now:              time.Now,
 	}
 	db.initDB()
+	db.writer = newWriter()
 	return db
 }
 
+// playerStatisticSchema creates a table of players' statistic, given its name.
+const playerStatisticSchema = `CREATE TABLE IF NOT EXISTS %s (
+				id INTEGER PRIMARY KEY,
+				playerName STRING,
+				twoPointTry INTEGER,
+				twoPointSuccess INTEGER,
+				threePointTry INTEGER,
+				threePointSuccess INTEGER,
+				freeThrowTry INTEGER,
+				freeThrowSuccess INTEGER,
+				foul INTEGER
+			);`
+
 func (db *DBWrapper) initDB() {
+	version := db.readSchemaVersion()
+	if err := checkSchemaVersion(version); err != nil {
+		ut.Fatal(err)
+	}
 	tableName := "playerStatistic"
 	query := fmt.Sprintf("SELECT name FROM sqlite_master WHERE type='table' AND name='%s';", tableName)
 	var name string
@@ -39,17 +69,7 @@ func (db *DBWrapper) initDB() {
 	} else { // The table does not exist
 		if err == sql.ErrNoRows {
 			ut.Infof("Table %s does not exist. So it is created.\n", tableName)
-			query := `CREATE TABLE IF NOT EXISTS playerStatistic (
-				id INTEGER PRIMARY KEY,
-				playerName STRING,
-				twoPointTry INTEGER,
-				twoPointSuccess INTEGER,
-				threePointTry INTEGER,
-				threePointSuccess INTEGER,
-				freeThrowTry INTEGER,
-				freeThrowSuccess INTEGER,
-				foul INTEGER
-			);`
+			query := fmt.Sprintf(playerStatisticSchema, tableName)
 			_, err := db.clientDB.Exec(query)
 			if err != nil {
 				fmt.Println(err)
@@ -62,6 +82,9 @@ func (db *DBWrapper) initDB() {
 			ut.Fatal(err)
 		}
 	}
+	db.initSplitTables()
+	db.initResultTable()
+	db.initSchemaVersion(version)
 }
 
 func (db *DBWrapper) queryPlayerIdMap() map[string]int {
@@ -85,10 +108,46 @@ func (db *DBWrapper) queryPlayerIdMap() map[string]int {
 	return mapping
 }
 
+func (db *DBWrapper) hasTable(tableName string) (bool, error) {
+	var name string
+	err := db.clientDB.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?;`, tableName).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (db *DBWrapper) QueryGameHistoric(gamePoster string) (sp.Actions, error) {
+	exists, err := db.hasTable(gamePoster)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return queryGameActions(db.clientDB, gamePoster)
+	}
+	var archived bool
+	err = db.clientDB.QueryRow(`SELECT archived FROM games WHERE gamePoster = ?;`, gamePoster).Scan(&archived)
+	if err == sql.ErrNoRows || (err == nil && !archived) {
+		return nil, fmt.Errorf("%w: %s", ErrGameNotFound, gamePoster)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.readArchivedActions(gamePoster)
+}
+
+// querier is implemented by both the database and its transactions.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryGameActions(q querier, gamePoster string) (sp.Actions, error) {
 	query := fmt.Sprintf(`SELECT * FROM %s;`, gamePoster)
 
-	rows, err := db.clientDB.Query(query)
+	rows, err := q.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +158,7 @@ func (db *DBWrapper) QueryGameHistoric(gamePoster string) (sp.Actions, error) {
 	var minute int32
 	for rows.Next() {
 		if err := rows.Scan(&team, &playerName, &description, &minute); err != nil {
-			ut.Fatal(err)
+			return nil, err
 		}
 		actions = append(actions, sp.Action{
 			GamePoster:  gamePoster,
@@ -108,125 +167,222 @@ func (db *DBWrapper) QueryGameHistoric(gamePoster string) (sp.Actions, error) {
 			Description: description,
 			Minute:      minute})
 	}
-	return actions, nil
+	return actions, rows.Err()
+}
+
+const selectPlayerStatistic = `SELECT playerName, twoPointTry, twoPointSuccess, threePointTry, threePointSuccess, freeThrowTry, freeThrowSuccess, foul FROM playerStatistic`
+
+func scanPlayerStatistic(row interface{ Scan(...any) error }) (sp.PlayerStatistic, error) {
+	var stat sp.PlayerStatistic
+	err := row.Scan(&stat.PlayerName, &stat.TwoPointTry, &stat.TwoPointSuccess, &stat.ThreePointTry,
+		&stat.ThreePointSuccess, &stat.FreeThrowTry, &stat.FreeThrowSuccess, &stat.Foul)
+	return stat, err
+}
+
+func (db *DBWrapper) QueryPlayerStatistic(playerName string) (sp.PlayerStatistic, error) {
+	row := db.clientDB.QueryRow(selectPlayerStatistic+` WHERE playerName = ?;`, playerName)
+	stat, err := scanPlayerStatistic(row)
+	if err == sql.ErrNoRows {
+		return sp.PlayerStatistic{}, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerName)
+	}
+	return stat, err
+}
+
+func (db *DBWrapper) QueryPlayerStatistics() ([]sp.PlayerStatistic, error) {
+	rows, err := db.clientDB.Query(selectPlayerStatistic + ` ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []sp.PlayerStatistic
+	for rows.Next() {
+		stat, err := scanPlayerStatistic(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+func (db *DBWrapper) addPlayerStat(tx *writeTx, action sp.Action) error {
+	return addPlayerStatToTable(tx, "playerStatistic", db.cachePlayerID, tx.newPlayers, action)
 }
 
-func (db DBWrapper) addPlayerStat(action sp.Action) {
-	fmt.Println(action.Description)
-	id, ok := db.cachePlayerID[action.PlayerName]
+// addPlayerStatToTable applies the action to a table with the schema of
+// playerStatistic. ids are the ids of the players already in the table, the
+// players inserted are added to newIDs.
+func addPlayerStatToTable(tx *writeTx, table string, ids, newIDs map[string]int, action sp.Action) error {
+	ut.Debug(action.Description)
+	if action.Description == sp.EndOfGame {
+		return nil
+	}
+	id, ok := ids[action.PlayerName]
+	if !ok {
+		id, ok = newIDs[action.PlayerName]
+	}
 	if !ok {
-		query := `INSERT INTO playerStatistic (playerName, twoPointTry, twoPointSuccess, threePointTry, threePointSuccess, freeThrowTry, freeThrowSuccess, foul)
-				  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-		result, err := db.clientDB.Exec(query, action.PlayerName, 0, 0, 0, 0, 0, 0, 0)
+		query := fmt.Sprintf(`INSERT INTO %s (playerName, twoPointTry, twoPointSuccess, threePointTry, threePointSuccess, freeThrowTry, freeThrowSuccess, foul)
+				  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, table)
+		result, err := tx.Exec(query, action.PlayerName, 0, 0, 0, 0, 0, 0, 0)
 		if err != nil {
-			fmt.Println(err)
-			panic(err)
+			return err
 		}
 
 		// Get the last inserted row ID
 		lastID, err := result.LastInsertId()
 		if err != nil {
-			panic(err)
+			return err
 		}
-		db.cachePlayerID[action.PlayerName] = int(lastID)
+		newIDs[action.PlayerName] = int(lastID)
 		id = int(lastID)
 	}
 
 	// query to update from the sp.Action
+	var err error
 	switch action.Description {
 	case "2pts try":
-		updateSQL := `UPDATE playerStatistic SET twoPointTry = twoPointTry + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
-		}
+		updateSQL := fmt.Sprintf(`UPDATE %s SET twoPointTry = twoPointTry + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, id)
 	case "2pts succes":
-		updateSQL := `UPDATE playerStatistic SET twoPointTry = twoPointTry + ?, twoPointSuccess = twoPointSuccess + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
-		}
+		updateSQL := fmt.Sprintf(`UPDATE %s SET twoPointTry = twoPointTry + ?, twoPointSuccess = twoPointSuccess + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, 1, id)
 	case "3pts try":
-		updateSQL := `UPDATE playerStatistic SET threePointTry = threePointTry + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
-		}
+		updateSQL := fmt.Sprintf(`UPDATE %s SET threePointTry = threePointTry + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, id)
 	case "3pts succes":
-		updateSQL := `UPDATE playerStatistic SET threePointTry = threePointTry + ?, threePointSuccess = threePointSuccess + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
-		}
-		
+		updateSQL := fmt.Sprintf(`UPDATE %s SET threePointTry = threePointTry + ?, threePointSuccess = threePointSuccess + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, 1, id)
 	case "free throw succes":
-		updateSQL := `UPDATE playerStatistic SET freeThrowSuccess = freeThrowSuccess + ?, freeThrowTry = freeThrowTry + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
-		}
+		updateSQL := fmt.Sprintf(`UPDATE %s SET freeThrowSuccess = freeThrowSuccess + ?, freeThrowTry = freeThrowTry + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, 1, id)
 	case "free throw try":
-		updateSQL := `UPDATE playerStatistic SET freeThrowTry = freeThrowTry + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
+		updateSQL := fmt.Sprintf(`UPDATE %s SET freeThrowTry = freeThrowTry + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, id)
+	case "foul":
+		updateSQL := fmt.Sprintf(`UPDATE %s SET foul = foul + ? WHERE id = ?`, table)
+		_, err = tx.Exec(updateSQL, 1, id)
+	default:
+		ut.Debugf("Ignored for now %s \n", action.Description)
+	}
+	return err
+}
+
+// SendToTables applies an action to the per game table and to the players'
+// statistic in a single transaction: either both are written or none.
+func (db *DBWrapper) SendToTables(action sp.Action) error {
+	return db.SendBatchToTables(sp.Actions{action})
+}
+
+// SendBatchToTables applies several actions in a single transaction.
+func (db *DBWrapper) SendBatchToTables(actions sp.Actions) error {
+	return db.writer.do(func() error {
+		return db.sendBatchToTables(actions)
+	})
+}
+
+// sendBatchToTables must only be called by the owner goroutine of the writes.
+func (db *DBWrapper) sendBatchToTables(actions sp.Actions) error {
+	tx, err := db.beginWrite()
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		// send to per game DB
+		if err := db.addEntryToPerGameTable(tx, action); err != nil {
+			tx.Rollback()
+			return err
 		}
-	case  "foul":
-		updateSQL := `UPDATE playerStatistic SET foul = foul + ? WHERE id = ?`
-		_, err := db.clientDB.Exec(updateSQL, 1, id)
-		if err != nil {
-			fmt.Println(err)
-			ut.Fatal(err)
+
+		// Send to tables for players statistic.
+		if err := db.addPlayerStat(tx, action); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := db.addSplitStat(tx, action); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if action.Description == sp.EndOfGame {
+			if err := db.addGameResult(tx, action.GamePoster); err != nil {
+				tx.Rollback()
+				return err
+			}
 		}
-	default:
-		fmt.Printf("Ignored for now %s \n", action.Description)
 	}
+	return db.commitWrite(tx)
+}
 
+// Close waits for the pending writes and closes the database.
+func (db *DBWrapper) Close() error {
+	db.writer.close()
+	return db.clientDB.Close()
 }
 
-func (db *DBWrapper) SendToTables(action sp.Action) {
-	// send to per game DB	
-	db.addEntryToPerGameTable(action)
+// writeTx is a write transaction together with the entries it adds to the
+// caches of the wrapper. They are only kept once the transaction commits, so
+// a rolled back transaction leaves no table or player id behind.
+type writeTx struct {
+	*sql.Tx
+	newTables  map[string]bool
+	newPlayers map[string]int
+}
 
-	// Send to tables for players statistic.
-	db.addPlayerStat(action)
+func (db *DBWrapper) beginWrite() (*writeTx, error) {
+	tx, err := db.clientDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &writeTx{
+		Tx:         tx,
+		newTables:  make(map[string]bool),
+		newPlayers: make(map[string]int),
+	}, nil
+}
 
+func (db *DBWrapper) commitWrite(tx *writeTx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for name := range tx.newTables {
+		db.cachedTableNames[name] = true
+	}
+	for name, id := range tx.newPlayers {
+		db.cachePlayerID[name] = id
+	}
+	return nil
 }
 
-func (db *DBWrapper) addEntryToPerGameTable(action sp.Action) {
+func (db *DBWrapper) addEntryToPerGameTable(tx *writeTx, action sp.Action) error {
 	ut.Debugf("Received event: Game=%s, Team=%s, Player=%s, Description=%s, Time=%d",
 		action.GamePoster, action.Team, action.PlayerName, action.Description, action.Minute)
 
 	var query string
-	if !db.cachedTableNames[action.GamePoster] {
-
+	if !db.cachedTableNames[action.GamePoster] && !tx.newTables[action.GamePoster] {
+		if err := checkNotArchived(tx, action.GamePoster); err != nil {
+			return err
+		}
 		query = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 				team STRING,
 				playerName STRING,
 				description STRING,
 				minute INTEGER
 			);`, action.GamePoster)
-		_, err := db.clientDB.Exec(query)
+		_, err := tx.Exec(query)
 		if err != nil {
-			ut.Info(err)
-			ut.Fatal(err)
+			return err
 		}
-		db.cachedTableNames[action.GamePoster] = true
+		if err := db.addGameInfo(tx, action); err != nil {
+			return err
+		}
+		tx.newTables[action.GamePoster] = true
 	}
 
 	query = fmt.Sprintf(`INSERT INTO %s (team, playerName, description, minute) 
-		VALUES ('%s', '%s', '%s', %d);`, action.GamePoster, action.Team, action.PlayerName, action.Description, action.Minute)
+		VALUES (?, ?, ?, ?);`, action.GamePoster)
 
-	_, err := db.clientDB.Exec(query)
-	if err != nil {
-		ut.Fatal(err)
-	}
+	_, err := tx.Exec(query, action.Team, action.PlayerName, action.Description, action.Minute)
+	return err
 }
 
 func (db *DBWrapper) queryGameRecord(gameName string) []sp.Action {
@@ -247,7 +403,7 @@ func (db *DBWrapper) queryGameRecord(gameName string) []sp.Action {
 	// 	db.cachedTableNames[action.GamePoster] = true
 	// }
 
-	// query = fmt.Sprintf(`INSERT INTO %s (team, playerName, description, minute) 
+	// query = fmt.Sprintf(`INSERT INTO %s (team, playerName, description, minute)
 	// 	VALUES ('%s', '%s', '%s', %d);`, gameName, action.Team, action.PlayerName, action.Description, action.Minute)
 
 	// _, err := db.clientDB.Exec(query)
@@ -257,9 +413,18 @@ func (db *DBWrapper) queryGameRecord(gameName string) []sp.Action {
 	return make([]sp.Action, 0)
 }
 
+// busyTimeout is how long a connection waits for a lock held by another one,
+// in milliseconds. In WAL mode it only happens at checkpoints.
+const busyTimeout = 5000
+
 func getSQLDB(dbName string) *sql.DB {
-	// Open a connection pool to SQLite database
-	db, err := sql.Open("sqlite3", dbName) //"./games.db")
+	// Open a connection pool to SQLite database. In WAL mode, the readers
+	// do not block the writer nor the writer the readers. The transactions
+	// take the write lock at BEGIN, instead of failing when upgrading a
+	// read lock. Each commit is synced to disk before it returns: an action
+	// is acknowledged once stored, it must survive a power loss.
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=%d&_txlock=immediate", dbName, busyTimeout)
+	db, err := sql.Open("sqlite3", dsn) //"./games.db")
 	if err != nil {
 		fmt.Println(err)
 		ut.Fatal(err)
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	sp "sync_score/sport"
+)
+
+func TestSendToTablesIsAtomic(t *testing.T) {
+	t.Parallel()
+	db := NewDBWrapper(filepath.Join(t.TempDir(), "games.db"))
+	defer db.Close()
+
+	game := sampleGame("Boston_Knicks")
+	sendAll(t, db, game[:2])
+
+	// Without the playerStatistic table, the second write of the action fails.
+	if _, err := db.clientDB.Exec(`ALTER TABLE playerStatistic RENAME TO playerStatisticBackup;`); err != nil {
+		t.Fatal(err)
+	}
+	newPlayer := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "Sam Hauser", Description: "foul", Minute: 6}
+	if err := db.SendToTables(newPlayer); err == nil {
+		t.Fatal("SendToTables() should fail without the playerStatistic table")
+	}
+	if _, err := db.clientDB.Exec(`ALTER TABLE playerStatisticBackup RENAME TO playerStatistic;`); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.QueryGameHistoric("Boston_Knicks")
+	if err != nil {
+		t.Fatalf("QueryGameHistoric() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %d actions after a failed write, want 2", len(got))
+	}
+	if _, err := db.QueryPlayerStatistic("Sam Hauser"); !errors.Is(err, ErrPlayerNotFound) {
+		t.Errorf("QueryPlayerStatistic() error = %v, want %v", err, ErrPlayerNotFound)
+	}
+
+	// The rolled back player must not stay in the cache of ids.
+	sendAll(t, db, sp.Actions{newPlayer})
+	stat, err := db.QueryPlayerStatistic("Sam Hauser")
+	if err != nil {
+		t.Fatalf("QueryPlayerStatistic() error = %v", err)
+	}
+	if stat.Foul != 1 {
+		t.Errorf("foul of Sam Hauser = %d, want 1", stat.Foul)
+	}
+}
+
+func TestRebuildSplitsFromActions(t *testing.T) {
+	t.Parallel()
+	db := NewDBWrapper(filepath.Join(t.TempDir(), "games.db"))
+	defer db.Close()
+	registerSampleSeasons(t, db)
+
+	// The splits drift away from the per game tables.
+	if _, err := db.clientDB.Exec(`UPDATE playerSeasonStatistic SET threePointSuccess = 42;`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.clientDB.Exec(`DELETE FROM playerGameStatistic WHERE gamePoster = 'Bulls_cavaliers';`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RebuildSplits(); err != nil {
+		t.Fatalf("RebuildSplits() error = %v", err)
+	}
+	got, err := db.QueryPlayerSplits(StatisticFilter{Season: "2024-25", PlayerName: "JD Davison"})
+	if err != nil {
+		t.Fatalf("QueryPlayerSplits() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ThreePointSuccess != 2 {
+		t.Errorf("QueryPlayerSplits() = %+v, want 2 3pts success", got)
+	}
+	got, err = db.QueryPlayerSplits(StatisticFilter{GamePoster: "Bulls_cavaliers", PlayerName: "JD Davison"})
+	if err != nil {
+		t.Fatalf("QueryPlayerSplits() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ThreePointSuccess != 1 {
+		t.Errorf("QueryPlayerSplits() = %+v, want 1 3pts success", got)
+	}
+}
+
+// TestConcurrentIngestionAndQueries is meant to be run with -race: the gRPC
+// handlers call the wrapper from many goroutines at once.
+func TestConcurrentIngestionAndQueries(t *testing.T) {
+	t.Parallel()
+	db := NewDBWrapper(filepath.Join(t.TempDir(), "games.db"))
+	defer db.Close()
+
+	const numWriters, numReaders, numActions = 8, 4, 50
+	games := []string{"Boston_Knicks", "Bulls_cavaliers", "Sixers_Raptor"}
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+
+	writers.Add(numWriters)
+	for i := 0; i < numWriters; i++ {
+		go func(idx int) {
+			defer writers.Done()
+			for j := 0; j < numActions; j++ {
+				err := db.SendToTables(sp.Action{
+					GamePoster:  games[(idx+j)%len(games)],
+					Team:        "Boston",
+					PlayerName:  fmt.Sprintf("Player %d", j%10),
+					Description: "3pts succes",
+					Minute:      int32(j),
+				})
+				if err != nil {
+					t.Errorf("SendToTables() error = %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	readers.Add(numReaders)
+	for i := 0; i < numReaders; i++ {
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := db.QueryGameHistoric(games[0]); err != nil && !errors.Is(err, ErrGameNotFound) {
+					t.Errorf("QueryGameHistoric() error = %v", err)
+					return
+				}
+				if _, err := db.QueryPlayerStatistics(); err != nil {
+					t.Errorf("QueryPlayerStatistics() error = %v", err)
+					return
+				}
+				if _, err := db.QueryPlayerSplits(StatisticFilter{GamePoster: games[1]}); err != nil {
+					t.Errorf("QueryPlayerSplits() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	stats, err := db.QueryPlayerStatistics()
+	if err != nil {
+		t.Fatalf("QueryPlayerStatistics() error = %v", err)
+	}
+	var total int32
+	for _, stat := range stats {
+		total += stat.ThreePointSuccess
+	}
+	if len(stats) != 10 || total != numWriters*numActions {
+		t.Errorf("got %d players and %d 3pts success, want 10 and %d", len(stats), total, numWriters*numActions)
+	}
+}
+
+func TestRebuildPlayerStatistic(t *testing.T) {
+	t.Parallel()
+	db := NewDBWrapper(filepath.Join(t.TempDir(), "games.db"))
+	defer db.Close()
+	registerSampleSeasons(t, db)
+	want, err := db.QueryPlayerStatistics()
+	if err != nil {
+		t.Fatalf("QueryPlayerStatistics() error = %v", err)
+	}
+
+	// playerStatistic drifts away from the per game tables.
+	if _, err := db.clientDB.Exec(`UPDATE playerStatistic SET foul = 7 WHERE playerName = 'JD Davison';`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.clientDB.Exec(`DELETE FROM playerStatistic WHERE playerName = 'Jaylen Brown';`); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := db.RebuildPlayerStatistic(true)
+	if err != nil {
+		t.Fatalf("RebuildPlayerStatistic(dry run) error = %v", err)
+	}
+	if len(diffs) != 2 || diffs[0].PlayerName != "JD Davison" || diffs[1].PlayerName != "Jaylen Brown" {
+		t.Fatalf("RebuildPlayerStatistic(dry run) = %+v, want JD Davison and Jaylen Brown", diffs)
+	}
+	if diffs[0].Stored.Foul != 7 || diffs[0].Rebuilt.Foul != 0 || diffs[1].Stored.ThreePointTry != 0 {
+		t.Errorf("RebuildPlayerStatistic(dry run) = %+v", diffs)
+	}
+	if stat, _ := db.QueryPlayerStatistic("JD Davison"); stat.Foul != 7 {
+		t.Errorf("a dry run should not write, foul of JD Davison = %d", stat.Foul)
+	}
+
+	if _, err := db.RebuildPlayerStatistic(false); err != nil {
+		t.Fatalf("RebuildPlayerStatistic() error = %v", err)
+	}
+	got, err := db.QueryPlayerStatistics()
+	if err != nil {
+		t.Fatalf("QueryPlayerStatistics() error = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d players, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("player %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The cache of ids follows the rebuilt table.
+	sendAll(t, db, sp.Actions{{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "Jaylen Brown", Description: "foul", Minute: 9}})
+	if stat, _ := db.QueryPlayerStatistic("Jaylen Brown"); stat.Foul != 1 || stat.ThreePointTry != 3 {
+		t.Errorf("statistic of Jaylen Brown = %+v, want 1 foul and 3 3pts try", stat)
+	}
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+// MemoryStore is an in-memory implementation of Store. Nothing is persisted,
+// it is meant for the tests and for demos.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	games   map[string]sp.Actions
+	players map[string]*sp.PlayerStatistic
+	// order in which the players were first seen, like the ids of playerStatistic
+	playerOrder []string
+	gameInfos   map[string]sp.GameInfo
+	// statistics of the players per game, the seasons are summed on read
+	gameStats map[string]map[string]*sp.PlayerStatistic
+	// final scores of the ended games, dated on read
+	results map[string]sp.GameResult
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		games:     make(map[string]sp.Actions),
+		players:   make(map[string]*sp.PlayerStatistic),
+		gameInfos: make(map[string]sp.GameInfo),
+		gameStats: make(map[string]map[string]*sp.PlayerStatistic),
+		results:   make(map[string]sp.GameResult),
+		now:       time.Now,
+	}
+}
+
+func (m *MemoryStore) SendToTables(action sp.Action) error {
+	return m.SendBatchToTables(sp.Actions{action})
+}
+
+func (m *MemoryStore) SendBatchToTables(actions sp.Actions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, action := range actions {
+		m.appendAction(action)
+	}
+	return nil
+}
+
+func (m *MemoryStore) appendAction(action sp.Action) {
+	m.games[action.GamePoster] = append(m.games[action.GamePoster], action)
+
+	if action.Description != sp.EndOfGame {
+		stat, ok := m.players[action.PlayerName]
+		if !ok {
+			stat = &sp.PlayerStatistic{PlayerName: action.PlayerName}
+			m.players[action.PlayerName] = stat
+			m.playerOrder = append(m.playerOrder, action.PlayerName)
+		}
+		stat.Apply(action.Description)
+	}
+
+	if _, ok := m.gameInfos[action.GamePoster]; !ok {
+		startedAt := time.Unix(m.now().Unix(), 0)
+		m.gameInfos[action.GamePoster] = sp.GameInfo{
+			GamePoster: action.GamePoster,
+			Season:     sp.SeasonOf(startedAt),
+			StartedAt:  startedAt,
+		}
+	}
+	m.addSplitStat(action)
+}
+
+func (m *MemoryStore) addSplitStat(action sp.Action) {
+	if action.Description == sp.EndOfGame {
+		m.results[action.GamePoster] = sp.FinalScore(sp.GameInfo{GamePoster: action.GamePoster}, m.games[action.GamePoster])
+		return
+	}
+	players, ok := m.gameStats[action.GamePoster]
+	if !ok {
+		players = make(map[string]*sp.PlayerStatistic)
+		m.gameStats[action.GamePoster] = players
+	}
+	stat, ok := players[action.PlayerName]
+	if !ok {
+		stat = &sp.PlayerStatistic{PlayerName: action.PlayerName}
+		players[action.PlayerName] = stat
+	}
+	stat.Apply(action.Description)
+}
+
+func (m *MemoryStore) RegisterGame(game sp.GameInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.gameInfos[game.GamePoster]; ok {
+		return fmt.Errorf("%w: %s", ErrGameExists, game.GamePoster)
+	}
+	if game.Season == "" {
+		game.Season = sp.SeasonOf(game.StartedAt)
+	}
+	// Dates are stored to the second, like in the SQLite tables.
+	game.StartedAt = time.Unix(game.StartedAt.Unix(), 0)
+	m.gameInfos[game.GamePoster] = game
+	return nil
+}
+
+func (m *MemoryStore) QueryPlayerSplits(filter StatisticFilter) ([]sp.PlayerStatistic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sums := make(map[string]*sp.PlayerStatistic)
+	for game, players := range m.gameStats {
+		info := m.gameInfos[game]
+		if filter.GamePoster != "" && game != filter.GamePoster ||
+			filter.Season != "" && info.Season != filter.Season ||
+			!filter.From.IsZero() && info.StartedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !info.StartedAt.Before(filter.To) {
+			continue
+		}
+		for name, stat := range players {
+			if filter.PlayerName != "" && name != filter.PlayerName {
+				continue
+			}
+			sum, ok := sums[name]
+			if !ok {
+				sum = &sp.PlayerStatistic{PlayerName: name}
+				sums[name] = sum
+			}
+			sum.Add(*stat)
+		}
+	}
+	stats := make([]sp.PlayerStatistic, 0, len(sums))
+	for _, sum := range sums {
+		stats = append(stats, *sum)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].PlayerName < stats[j].PlayerName })
+	return stats, nil
+}
+
+func (m *MemoryStore) QueryPlayerSeasons(playerName string) ([]sp.SeasonStatistic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sums := make(map[string]*sp.SeasonStatistic)
+	for game, players := range m.gameStats {
+		stat, ok := players[playerName]
+		if !ok {
+			continue
+		}
+		season := m.gameInfos[game].Season
+		sum, ok := sums[season]
+		if !ok {
+			sum = &sp.SeasonStatistic{Season: season, PlayerStatistic: sp.PlayerStatistic{PlayerName: playerName}}
+			sums[season] = sum
+		}
+		sum.Add(*stat)
+	}
+	seasons := make([]sp.SeasonStatistic, 0, len(sums))
+	for _, sum := range sums {
+		seasons = append(seasons, *sum)
+	}
+	sort.Slice(seasons, func(i, j int) bool { return seasons[i].Season < seasons[j].Season })
+	return seasons, nil
+}
+
+func (m *MemoryStore) RebuildSplits() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gameStats = make(map[string]map[string]*sp.PlayerStatistic)
+	m.results = make(map[string]sp.GameResult)
+	for _, actions := range m.games {
+		for _, action := range actions {
+			m.addSplitStat(action)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) QueryGameResults(filter ResultFilter) ([]sp.GameResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	results := []sp.GameResult{}
+	for game, result := range m.results {
+		info := m.gameInfos[game]
+		result.Season = info.Season
+		result.StartedAt = info.StartedAt
+		if filter.match(result) {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].StartedAt.Equal(results[j].StartedAt) {
+			return results[i].StartedAt.Before(results[j].StartedAt)
+		}
+		return results[i].GamePoster < results[j].GamePoster
+	})
+	return results, nil
+}
+
+func (m *MemoryStore) QueryGameHistoric(gamePoster string) (sp.Actions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	game, ok := m.games[gamePoster]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrGameNotFound, gamePoster)
+	}
+	actions := make(sp.Actions, len(game))
+	copy(actions, game)
+	return actions, nil
+}
+
+func (m *MemoryStore) QueryPlayerStatistic(playerName string) (sp.PlayerStatistic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stat, ok := m.players[playerName]
+	if !ok {
+		return sp.PlayerStatistic{}, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerName)
+	}
+	return *stat, nil
+}
+
+func (m *MemoryStore) QueryPlayerStatistics() ([]sp.PlayerStatistic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := make([]sp.PlayerStatistic, 0, len(m.playerOrder))
+	for _, name := range m.playerOrder {
+		stats = append(stats, *m.players[name])
+	}
+	return stats, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+
+	sp "sync_score/sport"
+)
+
+// rebuildTable is the table the statistics are replayed into before the swap.
+const rebuildTable = "playerStatisticRebuild"
+
+// PlayerStatisticDiff is a player whose stored statistic differs from the one
+// replayed from the actions. A player missing on one side has a zero
+// statistic on that side.
+type PlayerStatisticDiff struct {
+	PlayerName string
+	Stored     sp.PlayerStatistic
+	Rebuilt    sp.PlayerStatistic
+}
+
+// RebuildPlayerStatistic recomputes the playerStatistic table by replaying
+// every action of the per game tables and of the archive through the rules
+// of addPlayerStat. The actions are replayed into a new table, which
+// replaces playerStatistic in the same transaction, so the readers see
+// either the old or the new table.
+//
+// It returns the players whose statistic changed. With dryRun, nothing is
+// written and only the differences are reported.
+//
+// The cache of player ids of other processes is not refreshed: run it while
+// the database server is stopped.
+func (db *DBWrapper) RebuildPlayerStatistic(dryRun bool) ([]PlayerStatisticDiff, error) {
+	var diffs []PlayerStatisticDiff
+	err := db.writer.do(func() error {
+		games, err := db.gamesInReplayOrder()
+		if err != nil {
+			return err
+		}
+		tx, err := db.beginWrite()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, query := range []string{
+			fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, rebuildTable),
+			fmt.Sprintf(playerStatisticSchema, rebuildTable),
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+		ids := make(map[string]int)
+		for _, game := range games {
+			actions, err := db.replayActions(tx, game)
+			if err != nil {
+				return err
+			}
+			for _, action := range actions {
+				if err := addPlayerStatToTable(tx, rebuildTable, map[string]int{}, ids, action); err != nil {
+					return err
+				}
+			}
+		}
+
+		diffs, err = diffPlayerStatistic(tx)
+		if err != nil || dryRun {
+			return err
+		}
+		for _, query := range []string{
+			`DROP TABLE playerStatistic;`,
+			fmt.Sprintf(`ALTER TABLE %s RENAME TO playerStatistic;`, rebuildTable),
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		db.cachePlayerID = ids
+		return nil
+	})
+	return diffs, err
+}
+
+// diffPlayerStatistic compares playerStatistic with the rebuilt table.
+func diffPlayerStatistic(q querier) ([]PlayerStatisticDiff, error) {
+	stored, err := queryStatisticTable(q, "playerStatistic")
+	if err != nil {
+		return nil, err
+	}
+	rebuilt, err := queryStatisticTable(q, rebuildTable)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for name := range stored {
+		names[name] = true
+	}
+	for name := range rebuilt {
+		names[name] = true
+	}
+	var diffs []PlayerStatisticDiff
+	for name := range names {
+		if stored[name] != rebuilt[name] {
+			diffs = append(diffs, PlayerStatisticDiff{PlayerName: name, Stored: stored[name], Rebuilt: rebuilt[name]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].PlayerName < diffs[j].PlayerName })
+	return diffs, nil
+}
+
+func queryStatisticTable(q querier, table string) (map[string]sp.PlayerStatistic, error) {
+	rows, err := q.Query(fmt.Sprintf(`SELECT playerName, %s FROM %s;`, statColumns, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make(map[string]sp.PlayerStatistic)
+	for rows.Next() {
+		stat, err := scanPlayerStatistic(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats[stat.PlayerName] = stat
+	}
+	return stats, rows.Err()
+}
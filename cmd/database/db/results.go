@@ -0,0 +1,110 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+)
+
+// ResultFilter selects the final scores of the ended games. The zero value
+// selects every game.
+type ResultFilter struct {
+	Season string
+	// Team selects the games of a team, and Opponent those against an
+	// opponent: both give the head to head games of two teams.
+	Team, Opponent string
+}
+
+func (f ResultFilter) match(r sp.GameResult) bool {
+	plays := func(team string) bool { return team == r.TeamA || team == r.TeamB }
+	return (f.Season == "" || r.Season == f.Season) &&
+		(f.Team == "" || plays(f.Team)) &&
+		(f.Opponent == "" || plays(f.Opponent))
+}
+
+func (db *DBWrapper) initResultTable() {
+	_, err := db.clientDB.Exec(`CREATE TABLE IF NOT EXISTS gameResults (
+			gamePoster STRING PRIMARY KEY,
+			teamA STRING,
+			teamB STRING,
+			scoreA INTEGER,
+			scoreB INTEGER
+		);`)
+	if err != nil {
+		ut.Fatal(err)
+	}
+}
+
+// addGameResult stores the final score of the game from its actions. A game
+// ended twice gets the score of its last end.
+func (db *DBWrapper) addGameResult(tx *writeTx, gamePoster string) error {
+	actions, err := queryGameActions(tx, gamePoster)
+	if err != nil {
+		return err
+	}
+	return putGameResult(tx, sp.FinalScore(sp.GameInfo{GamePoster: gamePoster}, actions))
+}
+
+func putGameResult(tx *writeTx, result sp.GameResult) error {
+	_, err := tx.Exec(`INSERT INTO gameResults (gamePoster, teamA, teamB, scoreA, scoreB) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (gamePoster) DO UPDATE SET teamA = excluded.teamA, teamB = excluded.teamB,
+		scoreA = excluded.scoreA, scoreB = excluded.scoreB;`,
+		result.GamePoster, result.TeamA, result.TeamB, result.ScoreA, result.ScoreB)
+	return err
+}
+
+// endedGame reports whether the actions hold the end of their game.
+func endedGame(actions sp.Actions) bool {
+	for _, action := range actions {
+		if action.Description == sp.EndOfGame {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DBWrapper) QueryGameResults(filter ResultFilter) ([]sp.GameResult, error) {
+	var conditions []string
+	var args []any
+	if filter.Season != "" {
+		conditions = append(conditions, `g.season = ?`)
+		args = append(args, filter.Season)
+	}
+	for _, team := range []string{filter.Team, filter.Opponent} {
+		if team != "" {
+			conditions = append(conditions, `(r.teamA = ? OR r.teamB = ?)`)
+			args = append(args, team, team)
+		}
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = `WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	rows, err := db.clientDB.Query(`SELECT r.gamePoster, g.season, g.startedAt, r.teamA, r.teamB, r.scoreA, r.scoreB
+		FROM gameResults r JOIN games g ON g.gamePoster = r.gamePoster `+where+`
+		ORDER BY g.startedAt, r.gamePoster;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []sp.GameResult{}
+	for rows.Next() {
+		result, err := scanGameResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func scanGameResult(rows *sql.Rows) (sp.GameResult, error) {
+	var r sp.GameResult
+	var startedAt int64
+	err := rows.Scan(&r.GamePoster, &r.Season, &startedAt, &r.TeamA, &r.TeamB, &r.ScoreA, &r.ScoreB)
+	r.StartedAt = time.Unix(startedAt, 0)
+	return r, err
+}
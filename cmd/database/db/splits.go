@@ -0,0 +1,310 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrGameExists is returned when registering a game which is already known.
+var ErrGameExists = errors.New("game already exists")
+
+// StatisticFilter selects the games summed in the statistics of the players.
+// The zero value selects every game.
+type StatisticFilter struct {
+	GamePoster string
+	Season     string
+	// From and To bound the start of the games, To is excluded. A zero
+	// time leaves the range open on that side.
+	From, To   time.Time
+	PlayerName string
+}
+
+// onlySeason reports whether the filter can be answered from the per season table.
+func (f StatisticFilter) onlySeason() bool {
+	return f.Season != "" && f.GamePoster == "" && f.From.IsZero() && f.To.IsZero()
+}
+
+// statColumns are the counters shared by every table of player statistics.
+const statColumns = `twoPointTry, twoPointSuccess, threePointTry, threePointSuccess, freeThrowTry, freeThrowSuccess, foul`
+
+// internalTables are the tables of the database which do not hold a game.
+var internalTables = map[string]bool{
+	"playerStatistic":       true,
+	"games":                 true,
+	"playerGameStatistic":   true,
+	"playerSeasonStatistic": true,
+	"gameResults":           true,
+	rebuildTable:            true,
+}
+
+func (db *DBWrapper) initSplitTables() {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS games (
+			gamePoster STRING PRIMARY KEY,
+			season STRING,
+			startedAt INTEGER,
+			archived INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE TABLE IF NOT EXISTS playerGameStatistic (
+			gamePoster STRING,
+			playerName STRING,
+			twoPointTry INTEGER,
+			twoPointSuccess INTEGER,
+			threePointTry INTEGER,
+			threePointSuccess INTEGER,
+			freeThrowTry INTEGER,
+			freeThrowSuccess INTEGER,
+			foul INTEGER,
+			PRIMARY KEY (gamePoster, playerName)
+		);`,
+		`CREATE TABLE IF NOT EXISTS playerSeasonStatistic (
+			season STRING,
+			playerName STRING,
+			twoPointTry INTEGER,
+			twoPointSuccess INTEGER,
+			threePointTry INTEGER,
+			threePointSuccess INTEGER,
+			freeThrowTry INTEGER,
+			freeThrowSuccess INTEGER,
+			foul INTEGER,
+			PRIMARY KEY (season, playerName)
+		);`,
+	}
+	for _, query := range queries {
+		if _, err := db.clientDB.Exec(query); err != nil {
+			ut.Fatal(err)
+		}
+	}
+	// The games of a database older than the archive are all live.
+	var archived int
+	err := db.clientDB.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('games') WHERE name = 'archived';`).Scan(&archived)
+	if err == nil && archived == 0 {
+		_, err = db.clientDB.Exec(`ALTER TABLE games ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;`)
+	}
+	if err != nil {
+		ut.Fatal(err)
+	}
+}
+
+// RegisterGame records the metadata of a game before its first action. A game
+// which is not registered is dated by the arrival of its first action.
+func (db *DBWrapper) RegisterGame(game sp.GameInfo) error {
+	if game.Season == "" {
+		game.Season = sp.SeasonOf(game.StartedAt)
+	}
+	err := db.writer.do(func() error {
+		_, err := db.clientDB.Exec(`INSERT INTO games (gamePoster, season, startedAt) VALUES (?, ?, ?);`,
+			game.GamePoster, game.Season, game.StartedAt.Unix())
+		return err
+	})
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		return fmt.Errorf("%w: %s", ErrGameExists, game.GamePoster)
+	}
+	return err
+}
+
+// addGameInfo registers the game of the action, unless it already is.
+func (db *DBWrapper) addGameInfo(tx *writeTx, action sp.Action) error {
+	startedAt := db.now()
+	_, err := tx.Exec(`INSERT OR IGNORE INTO games (gamePoster, season, startedAt) VALUES (?, ?, ?);`,
+		action.GamePoster, sp.SeasonOf(startedAt), startedAt.Unix())
+	return err
+}
+
+// addSplitStat adds the action to the per game and per season statistics of its player.
+func (db *DBWrapper) addSplitStat(tx *writeTx, action sp.Action) error {
+	if action.Description == sp.EndOfGame {
+		return nil
+	}
+	var delta sp.PlayerStatistic
+	delta.Apply(action.Description)
+	values := []any{delta.TwoPointTry, delta.TwoPointSuccess, delta.ThreePointTry, delta.ThreePointSuccess,
+		delta.FreeThrowTry, delta.FreeThrowSuccess, delta.Foul}
+
+	query := fmt.Sprintf(`INSERT INTO playerGameStatistic (gamePoster, playerName, %s)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (gamePoster, playerName) DO UPDATE SET %s;`, statColumns, incrementColumns())
+	if _, err := tx.Exec(query, append([]any{action.GamePoster, action.PlayerName}, values...)...); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`INSERT INTO playerSeasonStatistic (season, playerName, %s)
+		SELECT season, ?, ?, ?, ?, ?, ?, ?, ? FROM games WHERE gamePoster = ?
+		ON CONFLICT (season, playerName) DO UPDATE SET %s;`, statColumns, incrementColumns())
+	_, err := tx.Exec(query, append(append([]any{action.PlayerName}, values...), action.GamePoster)...)
+	return err
+}
+
+// incrementColumns returns the SET clause adding the excluded row of an upsert.
+func incrementColumns() string {
+	columns := strings.Split(statColumns, ", ")
+	for i, c := range columns {
+		columns[i] = fmt.Sprintf("%s = %s + excluded.%s", c, c, c)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// RebuildSplits recomputes the per game and per season statistics, and the
+// final scores of the ended games, from the actions stored in the per game
+// tables and in the archive.
+func (db *DBWrapper) RebuildSplits() error {
+	return db.writer.do(func() error {
+		games, err := db.gamesInReplayOrder()
+		if err != nil {
+			return err
+		}
+		tx, err := db.beginWrite()
+		if err != nil {
+			return err
+		}
+		if err := db.rebuildSplits(tx, games); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return db.commitWrite(tx)
+	})
+}
+
+func (db *DBWrapper) rebuildSplits(tx *writeTx, games []replayGame) error {
+	for _, table := range []string{"playerGameStatistic", "playerSeasonStatistic", "gameResults"} {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s;`, table)); err != nil {
+			return err
+		}
+	}
+	for _, game := range games {
+		actions, err := db.replayActions(tx, game)
+		if err != nil {
+			return err
+		}
+		for _, action := range actions {
+			if err := db.addGameInfo(tx, action); err != nil {
+				return err
+			}
+			if err := db.addSplitStat(tx, action); err != nil {
+				return err
+			}
+		}
+		if endedGame(actions) {
+			result := sp.FinalScore(sp.GameInfo{GamePoster: game.name}, actions)
+			if err := putGameResult(tx, result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gameTableNames returns the names of the per game tables.
+func (db *DBWrapper) gameTableNames() ([]string, error) {
+	rows, err := db.clientDB.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !internalTables[name] {
+			names = append(names, name)
+		}
+	}
+	return names, rows.Err()
+}
+
+func (db *DBWrapper) QueryPlayerSplits(filter StatisticFilter) ([]sp.PlayerStatistic, error) {
+	var rows *sql.Rows
+	var err error
+	if filter.onlySeason() {
+		query := `SELECT playerName, ` + statColumns + ` FROM playerSeasonStatistic WHERE season = ?`
+		args := []any{filter.Season}
+		if filter.PlayerName != "" {
+			query += ` AND playerName = ?`
+			args = append(args, filter.PlayerName)
+		}
+		rows, err = db.clientDB.Query(query+` ORDER BY playerName;`, args...)
+	} else {
+		var conditions []string
+		var args []any
+		if filter.GamePoster != "" {
+			conditions = append(conditions, `p.gamePoster = ?`)
+			args = append(args, filter.GamePoster)
+		}
+		if filter.Season != "" {
+			conditions = append(conditions, `g.season = ?`)
+			args = append(args, filter.Season)
+		}
+		if !filter.From.IsZero() {
+			conditions = append(conditions, `g.startedAt >= ?`)
+			args = append(args, filter.From.Unix())
+		}
+		if !filter.To.IsZero() {
+			conditions = append(conditions, `g.startedAt < ?`)
+			args = append(args, filter.To.Unix())
+		}
+		if filter.PlayerName != "" {
+			conditions = append(conditions, `p.playerName = ?`)
+			args = append(args, filter.PlayerName)
+		}
+		where := ""
+		if len(conditions) > 0 {
+			where = `WHERE ` + strings.Join(conditions, ` AND `)
+		}
+		query := fmt.Sprintf(`SELECT p.playerName, %s FROM playerGameStatistic p JOIN games g ON g.gamePoster = p.gamePoster
+			%s GROUP BY p.playerName ORDER BY p.playerName;`, sumColumns(), where)
+		rows, err = db.clientDB.Query(query, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []sp.PlayerStatistic{}
+	for rows.Next() {
+		stat, err := scanPlayerStatistic(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// sumColumns returns the counters summed over the rows of a group.
+func sumColumns() string {
+	columns := strings.Split(statColumns, ", ")
+	for i, c := range columns {
+		columns[i] = fmt.Sprintf("SUM(p.%s)", c)
+	}
+	return strings.Join(columns, ", ")
+}
+
+func (db *DBWrapper) QueryPlayerSeasons(playerName string) ([]sp.SeasonStatistic, error) {
+	rows, err := db.clientDB.Query(`SELECT season, playerName, `+statColumns+`
+		FROM playerSeasonStatistic WHERE playerName = ? ORDER BY season;`, playerName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seasons := []sp.SeasonStatistic{}
+	for rows.Next() {
+		var s sp.SeasonStatistic
+		err := rows.Scan(&s.Season, &s.PlayerName, &s.TwoPointTry, &s.TwoPointSuccess, &s.ThreePointTry,
+			&s.ThreePointSuccess, &s.FreeThrowTry, &s.FreeThrowSuccess, &s.Foul)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+	return seasons, rows.Err()
+}
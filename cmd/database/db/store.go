@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+
+	sp "sync_score/sport"
+)
+
+var (
+	// ErrGameNotFound is returned when no action was stored for a game.
+	ErrGameNotFound = errors.New("game not found")
+	// ErrPlayerNotFound is returned when no statistic was stored for a player.
+	ErrPlayerNotFound = errors.New("player not found")
+)
+
+// Store is the storage behind the GameCenter server. It appends the actions
+// of the games and answers the queries on their history and on the
+// statistics of the players.
+type Store interface {
+	// SendToTables appends an action to the history of its game and
+	// updates the statistic of its player.
+	SendToTables(action sp.Action) error
+	// SendBatchToTables appends several actions at once: either all of
+	// them are stored or none.
+	SendBatchToTables(actions sp.Actions) error
+	// QueryGameHistoric returns the actions of a game in the order they
+	// were appended.
+	QueryGameHistoric(gamePoster string) (sp.Actions, error)
+	// QueryPlayerStatistic returns the statistic of one player.
+	QueryPlayerStatistic(playerName string) (sp.PlayerStatistic, error)
+	// QueryPlayerStatistics returns the statistic of every player, in the
+	// order the players were first seen.
+	QueryPlayerStatistics() ([]sp.PlayerStatistic, error)
+
+	// RegisterGame records the date and season of a game before its first
+	// action. Otherwise the game is dated when its first action is stored.
+	RegisterGame(game sp.GameInfo) error
+	// QueryPlayerSplits sums the statistics of the players over the games
+	// selected by the filter, ordered by player name.
+	QueryPlayerSplits(filter StatisticFilter) ([]sp.PlayerStatistic, error)
+	// QueryPlayerSeasons returns the line of a player for every season with
+	// a game of the player, ordered by season.
+	QueryPlayerSeasons(playerName string) ([]sp.SeasonStatistic, error)
+	// RebuildSplits recomputes the per game and per season statistics from
+	// the stored actions.
+	RebuildSplits() error
+
+	// QueryGameResults returns the final scores of the ended games selected
+	// by the filter, by start of the game. A game ends with an action of
+	// description sp.EndOfGame.
+	QueryGameResults(filter ResultFilter) ([]sp.GameResult, error)
+
+	Close() error
+}
+
+var (
+	_ Store = (*DBWrapper)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*BatchWriter)(nil)
+)
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+func TestDBWrapperStore(t *testing.T) {
+	t.Parallel()
+	testStore(t, func(t *testing.T) Store {
+		return NewDBWrapper(filepath.Join(t.TempDir(), "games.db"))
+	})
+}
+
+func TestBatchWriterStore(t *testing.T) {
+	t.Parallel()
+	testStore(t, func(t *testing.T) Store {
+		return NewBatchWriter(NewDBWrapper(filepath.Join(t.TempDir(), "games.db")), 16, time.Millisecond)
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+// testStore is the conformance suite every Store implementation must pass.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := map[string]func(*testing.T, Store){
+		"Game history keeps the order of the actions": func(t *testing.T, s Store) {
+			game := sampleGame("Boston_Knicks")
+			sendAll(t, s, game)
+
+			got, err := s.QueryGameHistoric("Boston_Knicks")
+			if err != nil {
+				t.Fatalf("QueryGameHistoric() error = %v", err)
+			}
+			if len(got) != len(game) {
+				t.Fatalf("got %d actions, want %d", len(got), len(game))
+			}
+			for i := range game {
+				if got[i] != game[i] {
+					t.Errorf("action %d = %+v, want %+v", i, got[i], game[i])
+				}
+			}
+		},
+		"Games are kept apart": func(t *testing.T, s Store) {
+			sendAll(t, s, sampleGame("Boston_Knicks"))
+			sendAll(t, s, sampleGame("Bulls_cavaliers")[:2])
+
+			got, err := s.QueryGameHistoric("Bulls_cavaliers")
+			if err != nil {
+				t.Fatalf("QueryGameHistoric() error = %v", err)
+			}
+			if len(got) != 2 {
+				t.Errorf("got %d actions, want 2", len(got))
+			}
+		},
+		"Unknown game": func(t *testing.T, s Store) {
+			_, err := s.QueryGameHistoric("Unknown_Game")
+			if !errors.Is(err, ErrGameNotFound) {
+				t.Errorf("QueryGameHistoric() error = %v, want %v", err, ErrGameNotFound)
+			}
+		},
+		"Player statistic": func(t *testing.T, s Store) {
+			sendAll(t, s, sampleGame("Boston_Knicks"))
+			sendAll(t, s, sampleGame("Bulls_cavaliers"))
+
+			got, err := s.QueryPlayerStatistic("JD Davison")
+			if err != nil {
+				t.Fatalf("QueryPlayerStatistic() error = %v", err)
+			}
+			want := sp.PlayerStatistic{
+				PlayerName:        "JD Davison",
+				TwoPointTry:       2,
+				ThreePointTry:     2,
+				ThreePointSuccess: 2,
+				FreeThrowTry:      2,
+				FreeThrowSuccess:  2,
+			}
+			if got != want {
+				t.Errorf("QueryPlayerStatistic() = %+v, want %+v", got, want)
+			}
+		},
+		"Unknown player": func(t *testing.T, s Store) {
+			_, err := s.QueryPlayerStatistic("Nobody")
+			if !errors.Is(err, ErrPlayerNotFound) {
+				t.Errorf("QueryPlayerStatistic() error = %v, want %v", err, ErrPlayerNotFound)
+			}
+		},
+		"Player statistics in order of appearance": func(t *testing.T, s Store) {
+			sendAll(t, s, sampleGame("Boston_Knicks"))
+
+			got, err := s.QueryPlayerStatistics()
+			if err != nil {
+				t.Fatalf("QueryPlayerStatistics() error = %v", err)
+			}
+			want := []string{"JD Davison", "Donte divicenzo", "Kevin Mccullar jr", "Jaylen Brown"}
+			if len(got) != len(want) {
+				t.Fatalf("got %d players, want %d", len(got), len(want))
+			}
+			for i, name := range want {
+				if got[i].PlayerName != name {
+					t.Errorf("player %d = %s, want %s", i, got[i].PlayerName, name)
+				}
+			}
+			if got[1].TwoPointSuccess != 1 || got[1].ThreePointSuccess != 1 || got[1].Foul != 1 {
+				t.Errorf("statistic of %s = %+v", got[1].PlayerName, got[1])
+			}
+		},
+		"Splits per game, season and date range": func(t *testing.T, s Store) {
+			registerSampleSeasons(t, s)
+
+			tests := map[string]struct {
+				filter StatisticFilter
+				want   int32
+			}{
+				"Game":       {StatisticFilter{GamePoster: "Boston_Knicks"}, 1},
+				"Season":     {StatisticFilter{Season: "2024-25"}, 2},
+				"Date range": {StatisticFilter{From: date(2025, 1, 1), To: date(2026, 1, 1)}, 2},
+				"All games":  {StatisticFilter{}, 3},
+			}
+			for name, tc := range tests {
+				tc.filter.PlayerName = "JD Davison"
+				got, err := s.QueryPlayerSplits(tc.filter)
+				if err != nil {
+					t.Fatalf("%s: QueryPlayerSplits() error = %v", name, err)
+				}
+				if len(got) != 1 || got[0].ThreePointSuccess != tc.want {
+					t.Errorf("%s: QueryPlayerSplits() = %+v, want %d 3pts success", name, got, tc.want)
+				}
+			}
+
+			got, err := s.QueryPlayerSplits(StatisticFilter{GamePoster: "Boston_Knicks"})
+			if err != nil {
+				t.Fatalf("QueryPlayerSplits() error = %v", err)
+			}
+			if len(got) != 4 || got[0].PlayerName != "Donte divicenzo" {
+				t.Errorf("QueryPlayerSplits() = %+v, want the 4 players ordered by name", got)
+			}
+		},
+		"Season over season": func(t *testing.T, s Store) {
+			registerSampleSeasons(t, s)
+
+			got, err := s.QueryPlayerSeasons("JD Davison")
+			if err != nil {
+				t.Fatalf("QueryPlayerSeasons() error = %v", err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("got %d seasons, want 2", len(got))
+			}
+			if got[0].Season != "2024-25" || got[0].ThreePointSuccess != 2 {
+				t.Errorf("first season = %+v", got[0])
+			}
+			if got[1].Season != "2025-26" || got[1].ThreePointSuccess != 1 {
+				t.Errorf("second season = %+v", got[1])
+			}
+		},
+		"Register an existing game": func(t *testing.T, s Store) {
+			sendAll(t, s, sampleGame("Boston_Knicks"))
+			err := s.RegisterGame(sp.GameInfo{GamePoster: "Boston_Knicks", StartedAt: date(2024, 11, 1)})
+			if !errors.Is(err, ErrGameExists) {
+				t.Errorf("RegisterGame() error = %v, want %v", err, ErrGameExists)
+			}
+		},
+		"Rebuild splits": func(t *testing.T, s Store) {
+			registerSampleSeasons(t, s)
+			want, err := s.QueryPlayerSeasons("JD Davison")
+			if err != nil {
+				t.Fatalf("QueryPlayerSeasons() error = %v", err)
+			}
+
+			if err := s.RebuildSplits(); err != nil {
+				t.Fatalf("RebuildSplits() error = %v", err)
+			}
+			got, err := s.QueryPlayerSeasons("JD Davison")
+			if err != nil {
+				t.Fatalf("QueryPlayerSeasons() error = %v", err)
+			}
+			if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+				t.Errorf("QueryPlayerSeasons() after rebuild = %+v, want %+v", got, want)
+			}
+		},
+		"Final score on game end": func(t *testing.T, s Store) {
+			registerSampleSeasons(t, s)
+			endGame(t, s, "Boston_Knicks")
+			endGame(t, s, "Sixers_Raptor")
+
+			got, err := s.QueryGameResults(ResultFilter{})
+			if err != nil {
+				t.Fatalf("QueryGameResults() error = %v", err)
+			}
+			want := sp.GameResult{GamePoster: "Boston_Knicks", Season: "2024-25", StartedAt: date(2024, 11, 1),
+				TeamA: "Boston", TeamB: "Knicks", ScoreA: 4, ScoreB: 5}
+			if len(got) != 2 || !got[0].StartedAt.Equal(want.StartedAt) {
+				t.Fatalf("QueryGameResults() = %+v, want 2 results starting with %+v", got, want)
+			}
+			got[0].StartedAt = want.StartedAt
+			if got[0] != want {
+				t.Errorf("QueryGameResults()[0] = %+v, want %+v", got[0], want)
+			}
+
+			filters := map[string]struct {
+				filter ResultFilter
+				want   int
+			}{
+				"Season":       {ResultFilter{Season: "2025-26"}, 1},
+				"Team":         {ResultFilter{Team: "Knicks"}, 1},
+				"Head to head": {ResultFilter{Team: "Knicks", Opponent: "Boston"}, 1},
+				"No game":      {ResultFilter{Team: "Knicks", Opponent: "Raptor"}, 0},
+			}
+			for name, tc := range filters {
+				got, err := s.QueryGameResults(tc.filter)
+				if err != nil || len(got) != tc.want {
+					t.Errorf("%s: QueryGameResults() = %+v, %v, want %d results", name, got, err, tc.want)
+				}
+			}
+
+			stats, err := s.QueryPlayerStatistics()
+			if err != nil || len(stats) != 4 {
+				t.Errorf("the end of a game should not count as a player, got %+v, %v", stats, err)
+			}
+			if err := s.RebuildSplits(); err != nil {
+				t.Fatalf("RebuildSplits() error = %v", err)
+			}
+			if rebuilt, err := s.QueryGameResults(ResultFilter{}); err != nil || len(rebuilt) != 2 || rebuilt[0].ScoreB != 5 {
+				t.Errorf("QueryGameResults() after rebuild = %+v, %v", rebuilt, err)
+			}
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newStore(t)
+			defer s.Close()
+			tc(t, s)
+		})
+	}
+}
+
+func sendAll(t *testing.T, s Store, actions sp.Actions) {
+	t.Helper()
+	for _, action := range actions {
+		if err := s.SendToTables(action); err != nil {
+			t.Fatalf("SendToTables(%+v) error = %v", action, err)
+		}
+	}
+}
+
+func endGame(t *testing.T, s Store, gamePoster string) {
+	t.Helper()
+	if err := s.SendToTables(sp.Action{GamePoster: gamePoster, Description: sp.EndOfGame, Minute: 48}); err != nil {
+		t.Fatalf("SendToTables(end of %s) error = %v", gamePoster, err)
+	}
+}
+
+// registerSampleSeasons stores two games in the 2024-25 season and one in 2025-26.
+func registerSampleSeasons(t *testing.T, s Store) {
+	t.Helper()
+	games := []sp.GameInfo{
+		{GamePoster: "Boston_Knicks", StartedAt: date(2024, 11, 1)},
+		{GamePoster: "Bulls_cavaliers", StartedAt: date(2025, 1, 15)},
+		{GamePoster: "Sixers_Raptor", StartedAt: date(2025, 11, 2)},
+	}
+	for _, game := range games {
+		if err := s.RegisterGame(game); err != nil {
+			t.Fatalf("RegisterGame(%s) error = %v", game.GamePoster, err)
+		}
+		sendAll(t, s, sampleGame(game.GamePoster))
+	}
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 20, 0, 0, 0, time.UTC)
+}
+
+func sampleGame(gamePoster string) sp.Actions {
+	return sp.Actions{
+		{GamePoster: gamePoster, Team: "Boston", PlayerName: "JD Davison", Description: "3pts succes", Minute: 0},
+		{GamePoster: gamePoster, Team: "Knicks", PlayerName: "Donte divicenzo", Description: "2pts succes", Minute: 0},
+		{GamePoster: gamePoster, Team: "Knicks", PlayerName: "Kevin Mccullar jr", Description: "free throw try", Minute: 1},
+		{GamePoster: gamePoster, Team: "Knicks", PlayerName: "Donte divicenzo", Description: "3pts succes", Minute: 2},
+		{GamePoster: gamePoster, Team: "Boston", PlayerName: "JD Davison", Description: "free throw succes", Minute: 2},
+		{GamePoster: gamePoster, Team: "Boston", PlayerName: "Jaylen Brown", Description: "3pts try", Minute: 4},
+		{GamePoster: gamePoster, Team: "Boston", PlayerName: "JD Davison", Description: "2pts try", Minute: 4},
+		{GamePoster: gamePoster, Team: "Knicks", PlayerName: "Donte divicenzo", Description: "foul", Minute: 5},
+	}
+}
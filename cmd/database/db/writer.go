@@ -0,0 +1,70 @@
+package db
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned when writing to a closed DBWrapper.
+var ErrClosed = errors.New("database closed")
+
+// The writes of a DBWrapper are all run by a single goroutine, the owner of
+// the write transactions and of the caches of table names and player ids.
+// SQLite accepts one writer at a time anyway, and this way the caches need no
+// lock. The reads do not go through the owner: in WAL mode they run
+// concurrently with the writes on the other connections of the pool.
+
+// writeRequest is a write run by the owner goroutine.
+type writeRequest struct {
+	write  func() error
+	result chan error
+}
+
+type writer struct {
+	requests chan writeRequest
+	done     chan struct{}
+	// mu guards closed, so no request is sent once requests is closed.
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+func newWriter() *writer {
+	w := &writer{
+		requests: make(chan writeRequest),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *writer) run() {
+	defer close(w.done)
+	for req := range w.requests {
+		req.result <- req.write()
+	}
+}
+
+// do runs the write on the owner goroutine and waits for its result.
+func (w *writer) do(write func() error) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrClosed
+	}
+	result := make(chan error, 1)
+	w.requests <- writeRequest{write: write, result: result}
+	w.mu.RUnlock()
+	return <-result
+}
+
+// close waits for the pending writes and stops the owner goroutine.
+func (w *writer) close() {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.requests)
+		w.mu.Unlock()
+		<-w.done
+	})
+}
@@ -8,21 +8,25 @@ import (
 	"strings"
 	"testing"
 
+	database "sync_score/cmd/database/db"
 	pb "sync_score/proto"
+	sp "sync_score/sport"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
 
-func newServer() (pb.GameCenterClient, func()) {
+func newServer(store database.Store) (pb.GameCenterClient, func()) {
 	lis := bufconn.Listen(1024 * 1024)
 
 	srv := grpc.NewServer()
 
 	pb.RegisterGameCenterServer(
 		srv,
-		GetGameEventServer("./test_games3.db"),
+		NewGameEventServer(store),
 	)
 
 	go func() {
@@ -48,14 +52,34 @@ func newServer() (pb.GameCenterClient, func()) {
 		lis.Close()
 		srv.Stop()
 		conn.Close()
+		store.Close()
 	}
 
 	client := pb.NewGameCenterClient(conn)
 	return client, closer
 }
 
+// newTestingGameStore returns an in-memory store holding the reference game.
+func newTestingGameStore(t *testing.T) database.Store {
+	store := database.NewMemoryStore()
+	for _, act := range referenceGameRecorded().Elements {
+		err := store.SendToTables(sp.Action{
+			GamePoster:  act.GamePoster,
+			Team:        act.Team,
+			PlayerName:  act.PlayerName,
+			Description: act.Description,
+			Minute:      act.Minute,
+		})
+		if err != nil {
+			t.Fatalf("store.SendToTables %v", err)
+		}
+	}
+	return store
+}
+
 func TestGameCenterServer_GetGameRecord(t *testing.T) {
-	client, closer := newServer()
+	t.Parallel()
+	client, closer := newServer(newTestingGameStore(t))
 	defer closer()
 	res, err := client.GetGameRecord(context.Background(), &pb.GameTitle{GamePoster: "testingGame"})
 	if err != nil {
@@ -63,6 +87,9 @@ func TestGameCenterServer_GetGameRecord(t *testing.T) {
 	}
 	// Parse the rows into a slice of Action structs
 	ref := referenceGameRecorded()
+	if len(res.Elements) != len(ref.Elements) {
+		t.Fatalf("Unexpected number of actions: %d should be %d", len(res.Elements), len(ref.Elements))
+	}
 
 	for i, elemt := range res.Elements {
 		if elemt.GamePoster != ref.Elements[i].GamePoster {
@@ -84,6 +111,63 @@ func TestGameCenterServer_GetGameRecord(t *testing.T) {
 
 }
 
+func TestGameCenterServer_GetGameRecordUnknownGame(t *testing.T) {
+	t.Parallel()
+	client, closer := newServer(database.NewMemoryStore())
+	defer closer()
+	_, err := client.GetGameRecord(context.Background(), &pb.GameTitle{GamePoster: "unknownGame"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Unexpected error for an unknown game: %v", err)
+	}
+}
+
+func TestGameCenterServer_GetStandings(t *testing.T) {
+	t.Parallel()
+	store := database.NewMemoryStore()
+	games := map[string][]string{
+		"Boston_Knicks": {"Boston", "Knicks"},
+		"Knicks_Bulls":  {"Knicks", "Knicks", "Bulls"},
+	}
+	for poster, scorers := range games {
+		for _, team := range scorers {
+			if err := store.SendToTables(sp.Action{GamePoster: poster, Team: team, PlayerName: team + " player", Description: "2pts succes"}); err != nil {
+				t.Fatalf("store.SendToTables %v", err)
+			}
+		}
+		if err := store.SendToTables(sp.Action{GamePoster: poster, Description: sp.EndOfGame}); err != nil {
+			t.Fatalf("store.SendToTables %v", err)
+		}
+	}
+	client, closer := newServer(store)
+	defer closer()
+
+	res, err := client.GetStandings(context.Background(), &pb.StandingsRequest{})
+	if err != nil {
+		t.Fatalf("client.GetStandings %v", err)
+	}
+	// Boston_Knicks is a tie, the Knicks win the other game.
+	var order []string
+	for _, standing := range res.Elements {
+		order = append(order, standing.Team)
+	}
+	if strings.Join(order, ",") != "Knicks,Boston,Bulls" {
+		t.Errorf("Unexpected standings: %v", order)
+	}
+
+	h2h, err := client.GetHeadToHead(context.Background(), &pb.HeadToHeadRequest{Team: "Knicks", Opponent: "Bulls"})
+	if err != nil {
+		t.Fatalf("client.GetHeadToHead %v", err)
+	}
+	if h2h.Record.Wins != 1 || len(h2h.Games) != 1 {
+		t.Errorf("Unexpected head to head: %v", h2h)
+	}
+
+	_, err = client.GetStandings(context.Background(), &pb.StandingsRequest{Tiebreakers: []string{"coinToss"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Unexpected error for an unknown tiebreaker: %v", err)
+	}
+}
+
 func referenceGameRecorded() *pb.Actions {
 	rows := []string{
 		"Boston\tJD Davison\t3pts succes\t0",
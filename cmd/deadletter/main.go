@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"sync_score/config"
+	"sync_score/queue"
+	ut "sync_score/utils"
+
+	"github.com/streadway/amqp"
+)
+
+// Inspects and replays the actions the queue server could not store:
+//
+//	deadletter inspect -n 20
+//	deadletter replay -reason "rejected by the database"
+//
+// inspect leaves the dead letters in their queue. replay publishes them back
+// on the queue they were consumed from, once the cause of the failure is
+// fixed, and removes them from the dead letters when RabbitMQ confirmed it.
+
+const usage = "usage: deadletter inspect|replay [flags]"
+
+func main() {
+	if len(os.Args) < 2 {
+		ut.Fatal(usage)
+	}
+	ut.SetLogLevel(ut.InfoLevel)
+	switch os.Args[1] {
+	case "inspect":
+		inspect(os.Args[2:])
+	case "replay":
+		replay(os.Args[2:])
+	default:
+		ut.Fatal(usage)
+	}
+}
+
+// parse parses the flags of a command, with those of the configuration.
+func parse(flags *flag.FlagSet, args []string) *config.Config {
+	cfg := config.Default()
+	cfg.RegisterFlags(flags)
+	flags.Parse(args)
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Debugf("Configuration:\n%s", cfg)
+	return cfg
+}
+
+func dial(cfg *config.Config) (*amqp.Connection, *amqp.Channel) {
+	conn, err := amqp.Dial(cfg.RabbitMQ.URL())
+	if err != nil {
+		ut.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		ut.Fatalf("Failed to open channel: %v", err)
+	}
+	if err := queue.DeclareDeadLetters(ch); err != nil {
+		ut.Fatal(err)
+	}
+	return conn, ch
+}
+
+func inspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	n := flags.Int("n", 50, "the maximum number of dead letters printed")
+	cfg := parse(flags, args)
+
+	conn, ch := dial(cfg)
+	defer conn.Close()
+	// The dead letters read are not acknowledged: closing the channel puts
+	// them back in the queue, in order.
+	defer ch.Close()
+
+	q, err := ch.QueueInspect(queue.DeadLetters)
+	if err != nil {
+		ut.Fatal(err)
+	}
+	fmt.Printf("%d dead letters in %s\n\n", q.Messages, queue.DeadLetters)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "failed at\tqueue\treason\tbody")
+	for i := 0; i < *n; i++ {
+		msg, ok, err := ch.Get(queue.DeadLetters, false)
+		if err != nil {
+			ut.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		fmt.Fprintf(w, "%v\t%s\t%v\t%s\n", msg.Headers[queue.HeaderFailedAt], queue.OriginalQueue(msg),
+			msg.Headers[queue.HeaderReason], msg.Body)
+	}
+	w.Flush()
+}
+
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	n := flags.Int("n", 0, "the maximum number of dead letters replayed, 0 replays them all")
+	reason := flags.String("reason", "", "only replay the dead letters whose reason contains this text")
+	cfg := parse(flags, args)
+
+	conn, ch := dial(cfg)
+	defer conn.Close()
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		ut.Fatalf("Failed to enable publisher confirms: %v", err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	// The queue length bounds the loop: the dead letters kept are only
+	// requeued when the channel closes.
+	q, err := ch.QueueInspect(queue.DeadLetters)
+	if err != nil {
+		ut.Fatal(err)
+	}
+	replayed, kept := 0, 0
+	for i := 0; i < q.Messages && (*n == 0 || replayed < *n); i++ {
+		msg, ok, err := ch.Get(queue.DeadLetters, false)
+		if err != nil {
+			ut.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		failure, _ := msg.Headers[queue.HeaderReason].(string)
+		if !strings.Contains(failure, *reason) {
+			kept++
+			continue
+		}
+		if err := ch.Publish("", queue.OriginalQueue(msg), false, false, queue.Replay(msg)); err != nil {
+			ut.Fatalf("Failed to replay dead letter: %v", err)
+		}
+		if confirm := <-confirms; !confirm.Ack {
+			ut.Fatalf("RabbitMQ refused the replayed dead letter, it is kept: %s", msg.Body)
+		}
+		if err := msg.Ack(false); err != nil {
+			ut.Fatal(err)
+		}
+		replayed++
+	}
+	ut.Infof("%d dead letters replayed, %d kept", replayed, kept)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	database "sync_score/cmd/database/db"
+	"sync_score/config"
+	"sync_score/export"
+	ut "sync_score/utils"
+)
+
+// Exports a game or the statistics of the players from the SQLite database,
+// for example:
+//
+//	export -report boxscore -game Boston_Knicks -format xlsx -out boston.xlsx
+//	export -report stats -season 2024-25 -format csv
+
+var (
+	report     = flag.String("report", "stats", "what to export: playbyplay, boxscore or stats")
+	format     = flag.String("format", "csv", "the format of the export: csv, json or xlsx")
+	out        = flag.String("out", "", "the file to write, the standard output when empty")
+	game       = flag.String("game", "", "the game of the playbyplay and boxscore reports")
+	fromMinute = flag.Int("fromMinute", 0, "the first minute of the game exported")
+	toMinute   = flag.Int("toMinute", -1, "the last minute of the game exported, -1 for the end of the game")
+	season     = flag.String("season", "", "the season of the stats report, as 2024-25")
+	from       = flag.String("from", "", "the stats report only counts the games started from this date, as 2025-01-31")
+	to         = flag.String("to", "", "the stats report only counts the games started before this date, as 2025-01-31")
+	cfg        = config.Default()
+)
+
+func init() {
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
+func main() {
+	flag.Parse()
+	ut.SetLogLevel(ut.InfoLevel)
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Debugf("Configuration:\n%s", cfg)
+
+	opts := export.Options{
+		GamePoster: *game,
+		FromMinute: int32(*fromMinute),
+		ToMinute:   int32(*toMinute),
+		Season:     *season,
+		From:       parseDate(*from),
+		To:         parseDate(*to),
+	}
+
+	db := database.NewDBWrapper(cfg.Database.Path)
+	defer db.Close()
+	db.SetArchiveDir(cfg.Database.ArchiveDir)
+
+	var table export.Table
+	var err error
+	switch *report {
+	case "playbyplay":
+		table, err = export.PlayByPlay(db, opts)
+	case "boxscore":
+		table, err = export.BoxScore(db, opts)
+	case "stats":
+		table, err = export.PlayerStatistics(db, opts)
+	default:
+		ut.Fatalf("Unknown report %q", *report)
+	}
+	if err != nil {
+		ut.Fatalf("Failed to read the %s report: %v", *report, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			ut.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := export.Write(w, table, export.Format(*format)); err != nil {
+		ut.Fatalf("Failed to write the export: %v", err)
+	}
+}
+
+func parseDate(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		ut.Fatalf("Invalid date %q: %v", value, err)
+	}
+	return date
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	database "sync_score/cmd/database/db"
+	"sync_score/config"
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+)
+
+// Recomputes the playerStatistic table from the per game tables, which are
+// the source of truth. Stop the database server before running it.
+
+var (
+	dryRun = flag.Bool("dryRun", false, "only report the players whose statistic would change")
+	splits = flag.Bool("splits", true, "also rebuild the per game and per season statistics")
+	cfg    = config.Default()
+)
+
+func init() {
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
+func main() {
+	flag.Parse()
+	ut.SetLogLevel(ut.InfoLevel)
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Debugf("Configuration:\n%s", cfg)
+
+	// ut.Fatalf exits without the deferred calls: the database is closed
+	// before.
+	db := database.NewDBWrapper(cfg.Database.Path)
+	db.SetArchiveDir(cfg.Database.ArchiveDir)
+	err := rebuild(db)
+	db.Close()
+	if err != nil {
+		ut.Fatalf("%v", err)
+	}
+}
+
+// rebuild recomputes playerStatistic, and the splits unless disabled.
+func rebuild(db *database.DBWrapper) error {
+	diffs, err := db.RebuildPlayerStatistic(*dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild playerStatistic: %v", err)
+	}
+	printDiffs(diffs)
+
+	if *dryRun {
+		ut.Infof("Dry run: %d players would change", len(diffs))
+		return nil
+	}
+	ut.Infof("playerStatistic rebuilt: %d players changed", len(diffs))
+	if *splits {
+		if err := db.RebuildSplits(); err != nil {
+			return fmt.Errorf("failed to rebuild the per game and per season statistics: %v", err)
+		}
+		ut.Info("Per game and per season statistics rebuilt")
+	}
+	return nil
+}
+
+func printDiffs(diffs []database.PlayerStatisticDiff) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "player\t\t2pts try\t2pts\t3pts try\t3pts\tft try\tft\tfoul")
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s\tstored\t%s\n", d.PlayerName, statRow(d.Stored))
+		fmt.Fprintf(w, "\trebuilt\t%s\n", statRow(d.Rebuilt))
+	}
+	w.Flush()
+}
+
+func statRow(s sp.PlayerStatistic) string {
+	return fmt.Sprintf("%d\t%d\t%d\t%d\t%d\t%d\t%d", s.TwoPointTry, s.TwoPointSuccess, s.ThreePointTry,
+		s.ThreePointSuccess, s.FreeThrowTry, s.FreeThrowSuccess, s.Foul)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+// loadFunc returns the actions of a game stored so far, none for a game not
+// stored yet.
+type loadFunc func(ctx context.Context, gamePoster string) ([]sp.Action, error)
+
+// CacheBackend keeps the live scores of the games. CacheGameRecorded keeps
+// them in the memory of one server, RedisCache in a Redis server shared by
+// the replicas of the server.
+type CacheBackend interface {
+	// record counts the action in the score of its game, see
+	// CacheGameRecorded.record.
+	record(ctx context.Context, action sp.Action, sequence int64, storedAt time.Time) error
+	// getScore returns the score of the game, rebuilt from the database
+	// when it is missing.
+	getScore(ctx context.Context, gamePoster string) (sp.ScoreRecord, error)
+	// setLoad sets the read of the actions of a missing game.
+	setLoad(load loadFunc)
+}
+
+// CacheGameRecorded keeps the live scores of the games. It is read-through:
+// a game missing from the cache, evicted or not seen since the server
+// started, is rebuilt from the actions stored in the database.
+//
+// A game expires once it is not read nor updated for ttl, LastRead records
+// its last use. Beyond maxGames, the least recently used game is evicted.
+type CacheGameRecorded struct {
+	games map[string]sp.ScoreRecord
+	// recency holds the names of the games, the most recently used first,
+	// and elements their element by name.
+	recency  *list.List
+	elements map[string]*list.Element
+	// sequences are the sequences of the actions counted by game.
+	sequences map[string]*sequenceSet
+	mu        sync.Mutex
+	ttl       time.Duration
+	// maxGames bounds the games in the cache, none when 0.
+	maxGames int
+	// now is the clock of the expiry.
+	now   func() time.Time
+	stats CacheStats
+	// load reads the actions of a missing game, a game starts from zero
+	// without it.
+	load loadFunc
+	// loading are the loads in flight by game: the misses of a game wait
+	// for the same load.
+	loading map[string]*gameLoad
+}
+
+// gameLoad is a load of a game, done is closed once it ends. started is
+// the time it started, an action stored before it is counted by the load.
+type gameLoad struct {
+	done    chan struct{}
+	started time.Time
+	err     error
+}
+
+// maxSequences bounds the sequences of a game counted out of order.
+const maxSequences = 1024
+
+// sequenceSet is the sequences of the actions of a game counted: every one
+// up to low, and the ones above it counted out of order, as the actions
+// following an action requeued. Beyond maxSequences above low, low moves up
+// to the lowest of them: the actions missing below are taken for counted.
+type sequenceSet struct {
+	low   int64
+	above map[int64]bool
+}
+
+// has reports whether the sequence was counted.
+func (s *sequenceSet) has(sequence int64) bool {
+	return sequence <= s.low || s.above[sequence]
+}
+
+// add records the sequence as counted.
+func (s *sequenceSet) add(sequence int64) {
+	if s.has(sequence) {
+		return
+	}
+	if s.above == nil {
+		s.above = make(map[int64]bool)
+	}
+	s.above[sequence] = true
+	if len(s.above) > maxSequences {
+		lowest := sequence
+		for n := range s.above {
+			lowest = min(lowest, n)
+		}
+		s.low = lowest - 1
+	}
+	for s.above[s.low+1] {
+		delete(s.above, s.low+1)
+		s.low++
+	}
+}
+
+// sorted returns the sequences counted above low, in order.
+func (s *sequenceSet) sorted() []int64 {
+	sequences := make([]int64, 0, len(s.above))
+	for n := range s.above {
+		sequences = append(sequences, n)
+	}
+	slices.Sort(sequences)
+	return sequences
+}
+
+// CacheStats counts the lookups of the games in the cache, and the games
+// removed from it.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	// Evictions are the games removed to make room, Expirations the games
+	// not used for a ttl.
+	Evictions   int64
+	Expirations int64
+	// Redeliveries are the actions skipped as counted already.
+	Redeliveries int64
+	Games        int
+}
+
+// NewCacheGameRecorded returns a cache of at most maxGames games, unbounded
+// when 0, each one kept for ttl after its last use.
+func NewCacheGameRecorded(ttl time.Duration, maxGames int) *CacheGameRecorded {
+	return &CacheGameRecorded{
+		games:     make(map[string]sp.ScoreRecord),
+		recency:   list.New(),
+		elements:  make(map[string]*list.Element),
+		sequences: make(map[string]*sequenceSet),
+		ttl:       ttl,
+		maxGames:  maxGames,
+		now:       time.Now,
+		loading:   make(map[string]*gameLoad),
+	}
+}
+
+func (c *CacheGameRecorded) setLoad(load loadFunc) {
+	c.load = load
+}
+
+// points returns the points an action scores.
+func points(description string) int32 {
+	switch description {
+	case "free throw succes":
+		return 1
+	case "2pts succes":
+		return 2
+	case "3pts succes":
+		return 3
+	}
+	return 0
+}
+
+// newScoreRecord returns the score of a game before its first action.
+func newScoreRecord(gamePoster string) sp.ScoreRecord {
+	team1, team2, _ := strings.Cut(gamePoster, "_") // team1 = "Boston", team2 = "Knicks"
+	return sp.ScoreRecord{GameName: gamePoster, TeamA: team1, TeamB: team2}
+}
+
+// addAction counts the points of the action in the score.
+func addAction(record *sp.ScoreRecord, action sp.Action) {
+	if action.Team == record.TeamA {
+		record.ScoreA += points(action.Description)
+	} else {
+		record.ScoreB += points(action.Description)
+	}
+}
+
+// updateCache counts the points of the action in the score of its game, a
+// game missing from the cache starts from zero.
+func (c *CacheGameRecorded) updateCache(action sp.Action) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(action)
+}
+
+// add counts the points of the action, the lock is held.
+func (c *CacheGameRecorded) add(action sp.Action) {
+	if points(action.Description) == 0 {
+		return
+	}
+	record, ok := c.games[action.GamePoster]
+	if !ok {
+		record = newScoreRecord(action.GamePoster)
+	}
+	addAction(&record, action)
+	c.put(record)
+}
+
+// put stores the record of a game as its last use, and evicts the least
+// recently used game when the cache is full. The lock is held.
+func (c *CacheGameRecorded) put(record sp.ScoreRecord) {
+	record.LastRead = c.now()
+	if _, ok := c.games[record.GameName]; !ok && c.maxGames > 0 {
+		for len(c.games) >= c.maxGames {
+			if !c.evictOldest() {
+				break
+			}
+		}
+	}
+	c.games[record.GameName] = record
+	if e, ok := c.elements[record.GameName]; ok {
+		c.recency.MoveToFront(e)
+	} else {
+		c.elements[record.GameName] = c.recency.PushFront(record.GameName)
+	}
+}
+
+// evictOldest removes the game used the longest ago, and reports whether
+// there was one. The lock is held.
+func (c *CacheGameRecorded) evictOldest() bool {
+	e := c.recency.Back()
+	if e == nil {
+		return false
+	}
+	name := e.Value.(string)
+	if _, ok := c.games[name]; ok {
+		c.stats.Evictions++
+	}
+	c.remove(name)
+	return true
+}
+
+// remove removes the game from the cache. The lock is held.
+func (c *CacheGameRecorded) remove(name string) {
+	delete(c.games, name)
+	delete(c.sequences, name)
+	if e, ok := c.elements[name]; ok {
+		c.recency.Remove(e)
+		delete(c.elements, name)
+	}
+}
+
+// record counts the action in the score of its game, rebuilding a missing
+// game from the database first. storedAt is the time the database stored the
+// action, zero when not stored yet: a score rebuilt by a load started after
+// it counts the action already. sequence is the place of the action in its
+// game, an action of a sequence counted is a redelivery and is skipped. An
+// action without sequence, 0, is counted.
+func (c *CacheGameRecorded) record(ctx context.Context, action sp.Action, sequence int64, storedAt time.Time) error {
+	if c.counted(action.GamePoster, sequence) {
+		return nil
+	}
+	loadedAt, err := c.fetch(ctx, action.GamePoster)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if storedAt.IsZero() || !loadedAt.After(storedAt) {
+		c.add(action)
+	}
+	if _, ok := c.games[action.GamePoster]; ok && sequence > 0 {
+		set, ok := c.sequences[action.GamePoster]
+		if !ok {
+			set = &sequenceSet{}
+			c.sequences[action.GamePoster] = set
+		}
+		set.add(sequence)
+	}
+	return nil
+}
+
+// counted reports whether the action of the sequence was counted already.
+func (c *CacheGameRecorded) counted(gamePoster string, sequence int64) bool {
+	if sequence <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if set, ok := c.sequences[gamePoster]; !ok || !set.has(sequence) {
+		return false
+	}
+	c.stats.Redeliveries++
+	return true
+}
+
+// getScore returns the score of the game, rebuilt from the database when
+// it is missing from the cache.
+func (c *CacheGameRecorded) getScore(ctx context.Context, gamePoster string) (sp.ScoreRecord, error) {
+	if _, err := c.fetch(ctx, gamePoster); err != nil {
+		return sp.ScoreRecord{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	record, ok := c.games[gamePoster]
+	if !ok {
+		return sp.ScoreRecord{}, nil
+	}
+	// A read keeps the game in the cache for another ttl, as its most
+	// recent use.
+	c.put(record)
+	record.LastRead = time.Time{}
+	return record, nil
+}
+
+// fetch puts the game in the cache when it is missing, and returns the start
+// of the load which put it, zero when the game was in the cache. The
+// concurrent misses of a game wait for the load in flight, which may have
+// read the database before their action was stored.
+func (c *CacheGameRecorded) fetch(ctx context.Context, gamePoster string) (time.Time, error) {
+	c.mu.Lock()
+	_, ok := c.games[gamePoster]
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	if ok || c.load == nil {
+		c.mu.Unlock()
+		return time.Time{}, nil
+	}
+	l, inFlight := c.loading[gamePoster]
+	if !inFlight {
+		// The wall clock, as the time of the store, not the clock of the
+		// expiry.
+		l = &gameLoad{done: make(chan struct{}), started: time.Now()}
+		c.loading[gamePoster] = l
+	}
+	c.mu.Unlock()
+
+	if inFlight {
+		select {
+		case <-l.done:
+			return l.started, l.err
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		}
+	}
+
+	actions, err := c.load(ctx, gamePoster)
+	record := newScoreRecord(gamePoster)
+	for _, action := range actions {
+		addAction(&record, action)
+	}
+	c.mu.Lock()
+	l.err = err
+	if err == nil {
+		c.put(record)
+	}
+	delete(c.loading, gamePoster)
+	c.mu.Unlock()
+	close(l.done)
+	return l.started, err
+}
+
+// clearCacheIfExpired removes the games not used for a ttl. A last use in
+// the future, after the clock went back, counts as a use now.
+func (c *CacheGameRecorded) clearCacheIfExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, v := range c.games {
+		if idle := now.Sub(v.LastRead); idle > c.ttl {
+			c.remove(k)
+			c.stats.Expirations++
+		} else if idle < 0 {
+			v.LastRead = now
+			c.games[k] = v
+		}
+	}
+}
+
+// Stats returns the counts of the cache.
+func (c *CacheGameRecorded) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Games = len(c.games)
+	return stats
+}
+
+// Run clears the expired games every ttl until the context is done.
+func (c *CacheGameRecorded) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.clearCacheIfExpired()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "sync_score/proto"
+	sp "sync_score/sport"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeHistory returns the actions stored for each game, and counts the
+// loads. Once release is set, a load waits for it to be closed.
+type fakeHistory struct {
+	mu      sync.Mutex
+	actions map[string][]sp.Action
+	err     error
+	loads   int
+	release chan struct{}
+}
+
+func (h *fakeHistory) load(ctx context.Context, gamePoster string) ([]sp.Action, error) {
+	h.mu.Lock()
+	h.loads++
+	release := h.release
+	h.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.actions[gamePoster], h.err
+}
+
+func (h *fakeHistory) Loads() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.loads
+}
+
+func TestCacheRebuildsAnEvictedGame(t *testing.T) {
+	first := []sp.Action{
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: 1},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Jalen Brunson", Description: "3pts succes", Minute: 2},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Jalen Brunson", Description: "foul", Minute: 3},
+	}
+	next := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "free throw succes", Minute: 4}
+
+	tests := map[string]struct {
+		history   []sp.Action
+		stored    bool
+		wantScore [2]int32
+	}{
+		"Action not stored yet": {history: first, stored: false, wantScore: [2]int32{3, 3}},
+		"Action stored":         {history: append(first[:len(first):len(first)], next), stored: true, wantScore: [2]int32{3, 3}},
+		"Game not stored yet":   {history: nil, stored: false, wantScore: [2]int32{1, 0}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			history := &fakeHistory{actions: map[string][]sp.Action{"Boston_Knicks": tc.history}}
+			cache := NewCacheGameRecorded(time.Minute, 0)
+			cache.load = history.load
+			for _, action := range tc.history {
+				cache.updateCache(action)
+			}
+			// The game is evicted mid-game.
+			delete(cache.games, "Boston_Knicks")
+
+			var storedAt time.Time
+			if tc.stored {
+				storedAt = time.Now().Add(-time.Millisecond)
+			}
+			if err := cache.record(context.Background(), next, 0, storedAt); err != nil {
+				t.Fatalf("record() error = %v", err)
+			}
+			got, err := cache.getScore(context.Background(), "Boston_Knicks")
+			if err != nil {
+				t.Fatalf("getScore() error = %v", err)
+			}
+			if got.ScoreA != tc.wantScore[0] || got.ScoreB != tc.wantScore[1] {
+				t.Errorf("getScore() = %d-%d, want %d-%d", got.ScoreA, got.ScoreB, tc.wantScore[0], tc.wantScore[1])
+			}
+			if got.TeamA != "Boston" || got.TeamB != "Knicks" {
+				t.Errorf("getScore() teams = %s-%s, want Boston-Knicks", got.TeamA, got.TeamB)
+			}
+			if loads := history.Loads(); loads != 1 {
+				t.Errorf("%d loads, want 1", loads)
+			}
+		})
+	}
+}
+
+func TestCacheLoadsAMissOnce(t *testing.T) {
+	history := &fakeHistory{
+		actions: map[string][]sp.Action{"Boston_Knicks": {
+			{GamePoster: "Boston_Knicks", Team: "Boston", Description: "3pts succes"},
+		}},
+		release: make(chan struct{}),
+	}
+	cache := NewCacheGameRecorded(time.Minute, 0)
+	cache.load = history.load
+
+	const readers = 10
+	var wg sync.WaitGroup
+	scores := make(chan sp.ScoreRecord, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			score, err := cache.getScore(context.Background(), "Boston_Knicks")
+			if err != nil {
+				t.Errorf("getScore() error = %v", err)
+			}
+			scores <- score
+		}()
+	}
+	// Every reader misses before the load ends.
+	for deadline := time.Now().Add(time.Second); history.Loads() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no load after 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(history.release)
+	wg.Wait()
+	close(scores)
+
+	if loads := history.Loads(); loads != 1 {
+		t.Errorf("%d loads for %d concurrent misses, want 1", loads, readers)
+	}
+	for score := range scores {
+		if score.ScoreA != 3 {
+			t.Errorf("getScore() = %+v, want 3 points for Boston", score)
+		}
+	}
+}
+
+func TestCacheWaitsForTheLoadInFlight(t *testing.T) {
+	first := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "3pts succes"}
+	next := sp.Action{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "2pts succes"}
+
+	tests := map[string]struct {
+		// storedBefore stores the action before the load starts, which
+		// reads it.
+		storedBefore bool
+		wantScore    [2]int32
+	}{
+		"Stored before the load": {storedBefore: true, wantScore: [2]int32{3, 2}},
+		"Stored during the load": {storedBefore: false, wantScore: [2]int32{3, 2}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			history := &fakeHistory{
+				actions: map[string][]sp.Action{"Boston_Knicks": {first}},
+				release: make(chan struct{}),
+			}
+			var storedAt time.Time
+			if tc.storedBefore {
+				history.actions["Boston_Knicks"] = append(history.actions["Boston_Knicks"], next)
+				storedAt = time.Now().Add(-time.Millisecond)
+			}
+			cache := NewCacheGameRecorded(time.Minute, 0)
+			cache.load = history.load
+
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if _, err := cache.getScore(context.Background(), "Boston_Knicks"); err != nil {
+					t.Errorf("getScore() error = %v", err)
+				}
+			}()
+			for deadline := time.Now().Add(time.Second); history.Loads() == 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("no load after 1s")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if !tc.storedBefore {
+				storedAt = time.Now()
+			}
+			go func() {
+				defer wg.Done()
+				if err := cache.record(context.Background(), next, 0, storedAt); err != nil {
+					t.Errorf("record() error = %v", err)
+				}
+			}()
+			// The action waits for the load in flight.
+			for deadline := time.Now().Add(time.Second); cache.Stats().Misses < 2; {
+				if time.Now().After(deadline) {
+					t.Fatal("the action did not miss after 1s")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			close(history.release)
+			wg.Wait()
+
+			got, err := cache.getScore(context.Background(), "Boston_Knicks")
+			if err != nil {
+				t.Fatalf("getScore() error = %v", err)
+			}
+			if got.ScoreA != tc.wantScore[0] || got.ScoreB != tc.wantScore[1] {
+				t.Errorf("getScore() = %d-%d, want %d-%d", got.ScoreA, got.ScoreB, tc.wantScore[0], tc.wantScore[1])
+			}
+		})
+	}
+}
+
+func TestCacheLoadFailure(t *testing.T) {
+	history := &fakeHistory{err: errors.New("database down")}
+	cache := NewCacheGameRecorded(time.Minute, 0)
+	cache.load = history.load
+	action := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"}
+
+	if err := cache.record(context.Background(), action, 0, time.Time{}); err == nil {
+		t.Fatal("record() should fail when the game cannot be rebuilt")
+	}
+	if _, ok := cache.games["Boston_Knicks"]; ok {
+		t.Errorf("a game which failed to load should not be cached")
+	}
+
+	// The next action loads the game again.
+	history.mu.Lock()
+	history.err = nil
+	history.actions = map[string][]sp.Action{"Boston_Knicks": {action}}
+	history.mu.Unlock()
+	if err := cache.record(context.Background(), action, 0, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	if got, _ := cache.getScore(context.Background(), "Boston_Knicks"); got.ScoreA != 4 {
+		t.Errorf("getScore() = %d for Boston, want 4", got.ScoreA)
+	}
+	if loads := history.Loads(); loads != 2 {
+		t.Errorf("%d loads, want 2", loads)
+	}
+}
+
+// storedGames is a database which stores every action, and returns them
+// with GetGameRecord.
+type storedGames struct {
+	pb.GameCenterDatabaseClient
+	mu      sync.Mutex
+	actions []*pb.Action
+}
+
+func (d *storedGames) SendGameAction(ctx context.Context, in *pb.Action, opts ...grpc.CallOption) (*pb.ActionReply, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.actions = append(d.actions, in)
+	return &pb.ActionReply{Status: "received"}, nil
+}
+
+func (d *storedGames) GetGameRecord(ctx context.Context, in *pb.GameTitle, opts ...grpc.CallOption) (*pb.Actions, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var reply pb.Actions
+	for _, a := range d.actions {
+		if a.GamePoster == in.GamePoster {
+			reply.Elements = append(reply.Elements, a)
+		}
+	}
+	if len(reply.Elements) == 0 {
+		return nil, status.Error(codes.NotFound, "game not found")
+	}
+	return &reply, nil
+}
+
+func TestStartRebuildsTheLiveScoreAfterAnEviction(t *testing.T) {
+	half := func(from, to int32) [][]byte {
+		var bodies [][]byte
+		for minute := from; minute < to; minute++ {
+			body, err := json.Marshal(sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies = append(bodies, body)
+		}
+		return bodies
+	}
+	database := &storedGames{}
+	messages := newTestBroker(t, testTopology, half(0, 10))
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          4,
+	}
+	server.cacheGameRecorded.setLoad(server.gameHistory)
+	startUntilSettled(t, server, messages)
+
+	// The server restarts mid-game, with an empty cache.
+	messages = newTestBroker(t, testTopology, half(10, 15))
+	server.broker = messages
+	server.cacheGameRecorded = NewCacheGameRecorded(time.Minute, 0)
+	server.cacheGameRecorded.setLoad(server.gameHistory)
+	startUntilSettled(t, server, messages)
+
+	if len(database.actions) != 15 {
+		t.Fatalf("the database stored %d actions, want 15", len(database.actions))
+	}
+	score, err := server.cacheGameRecorded.getScore(context.Background(), "Boston_Knicks")
+	if err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	if score.ScoreA != 30 {
+		t.Errorf("live score of Boston = %d, want 30 for the 15 actions stored", score.ScoreA)
+	}
+}
+
+// fakeClock is the clock of a cache, moved by the tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func score(game, team string) sp.Action {
+	return sp.Action{GamePoster: game, Team: team, Description: "2pts succes"}
+}
+
+func TestCacheEvictsTheLeastRecentlyUsedGame(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)}
+	cache := NewCacheGameRecorded(time.Hour, 2)
+	cache.now = clock.Now
+	ctx := context.Background()
+
+	cache.updateCache(score("Boston_Knicks", "Boston"))
+	clock.Advance(time.Second)
+	cache.updateCache(score("Lakers_Bulls", "Lakers"))
+	clock.Advance(time.Second)
+	// Reading Boston_Knicks makes Lakers_Bulls the least recently used.
+	if _, err := cache.getScore(ctx, "Boston_Knicks"); err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	clock.Advance(time.Second)
+	cache.updateCache(score("Heat_Celtics", "Heat"))
+
+	for game, want := range map[string]bool{"Boston_Knicks": true, "Lakers_Bulls": false, "Heat_Celtics": true} {
+		if _, ok := cache.games[game]; ok != want {
+			t.Errorf("%s in the cache = %v, want %v", game, ok, want)
+		}
+	}
+	want := CacheStats{Hits: 1, Evictions: 1, Games: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheEvictsInTheOrderOfUse(t *testing.T) {
+	// The clock does not move: the order of the uses tells the games apart,
+	// not their names.
+	cache := NewCacheGameRecorded(time.Hour, 2)
+	cache.now = (&fakeClock{now: time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)}).Now
+
+	cache.updateCache(score("Lakers_Bulls", "Lakers"))
+	cache.updateCache(score("Boston_Knicks", "Boston"))
+	cache.updateCache(score("Heat_Celtics", "Heat"))
+
+	for game, want := range map[string]bool{"Boston_Knicks": true, "Lakers_Bulls": false, "Heat_Celtics": true} {
+		if _, ok := cache.games[game]; ok != want {
+			t.Errorf("%s in the cache = %v, want %v", game, ok, want)
+		}
+	}
+}
+
+func TestCacheSlidingExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)}
+	ttl := time.Minute
+	cache := NewCacheGameRecorded(ttl, 0)
+	cache.now = clock.Now
+	ctx := context.Background()
+
+	cache.updateCache(score("Boston_Knicks", "Boston"))
+	cache.updateCache(score("Lakers_Bulls", "Lakers"))
+	// Each use of Boston_Knicks keeps it for another ttl, a write as a read.
+	clock.Advance(40 * time.Second)
+	cache.updateCache(score("Boston_Knicks", "Knicks"))
+	clock.Advance(40 * time.Second)
+	if _, err := cache.getScore(ctx, "Boston_Knicks"); err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	clock.Advance(40 * time.Second)
+	cache.clearCacheIfExpired()
+
+	if _, ok := cache.games["Boston_Knicks"]; !ok {
+		t.Errorf("Boston_Knicks used %v ago should be kept", 40*time.Second)
+	}
+	if _, ok := cache.games["Lakers_Bulls"]; ok {
+		t.Errorf("Lakers_Bulls not used for %v should have expired", 120*time.Second)
+	}
+	// An expired game is a miss.
+	if _, err := cache.getScore(ctx, "Lakers_Bulls"); err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	want := CacheStats{Hits: 1, Misses: 1, Expirations: 1, Games: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheRunStopsWithTheContext(t *testing.T) {
+	cache := NewCacheGameRecorded(time.Millisecond, 0)
+	cache.updateCache(score("Boston_Knicks", "Boston"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.Run(ctx)
+		close(done)
+	}()
+
+	for deadline := time.Now().Add(time.Second); cache.Stats().Expirations == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the game did not expire after 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return once the context was done")
+	}
+}
+
+func TestCacheSkipsTheActionsCountedAlready(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)}
+	cache := NewCacheGameRecorded(time.Minute, 0)
+	cache.now = clock.Now
+	ctx := context.Background()
+	deliveries := []struct {
+		action   sp.Action
+		sequence int64
+	}{
+		{score("Boston_Knicks", "Boston"), 1},
+		{score("Boston_Knicks", "Knicks"), 2},
+		// Redelivered after a requeue.
+		{score("Boston_Knicks", "Knicks"), 2},
+		{score("Boston_Knicks", "Boston"), 1},
+		// Without sequence, it is counted.
+		{score("Boston_Knicks", "Knicks"), 0},
+		{score("Boston_Knicks", "Boston"), 3},
+		// Counted before the action 4, requeued by the live cache.
+		{score("Boston_Knicks", "Boston"), 5},
+		{score("Boston_Knicks", "Knicks"), 4},
+		{score("Boston_Knicks", "Boston"), 5},
+	}
+	for _, d := range deliveries {
+		if err := cache.record(ctx, d.action, d.sequence, time.Time{}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	if got := cache.games["Boston_Knicks"]; got.ScoreA != 6 || got.ScoreB != 6 {
+		t.Errorf("score = %d-%d, want 6-6", got.ScoreA, got.ScoreB)
+	}
+	if got := cache.Stats().Redeliveries; got != 3 {
+		t.Errorf("Stats().Redeliveries = %d, want 3", got)
+	}
+
+	// An expired game forgets its sequence with its score.
+	clock.Advance(2 * time.Minute)
+	cache.clearCacheIfExpired()
+	if _, ok := cache.sequences["Boston_Knicks"]; ok {
+		t.Error("the sequence of an expired game should be removed")
+	}
+}
+
+func TestSequenceSetIsBounded(t *testing.T) {
+	var set sequenceSet
+	set.add(1)
+	// The action 2 is late, the ones after it are counted.
+	for sequence := int64(3); sequence < 3+2*maxSequences; sequence++ {
+		set.add(sequence)
+	}
+	if len(set.above) > maxSequences {
+		t.Errorf("%d sequences kept above %d, want at most %d", len(set.above), set.low, maxSequences)
+	}
+	for _, sequence := range []int64{1, 2, 3 + 2*maxSequences - 1} {
+		if !set.has(sequence) {
+			t.Errorf("has(%d) = false, want true", sequence)
+		}
+	}
+	if set.has(3 + 2*maxSequences) {
+		t.Errorf("has(%d) = true, want false", 3+2*maxSequences)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+
+	"sync_score/broker"
+	"sync_score/queue"
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+)
+
+// HeaderMQTTTopic is the header of a dead letter received over MQTT, with
+// the topic of the device which published it.
+const HeaderMQTTTopic = "x-mqtt-topic"
+
+// mqttActions counts the actions of the scorer devices received over MQTT.
+var mqttActions = expvar.NewInt("mqttActions")
+
+// parseDeviceAction decodes and validates an action a scorer device
+// published on games/{gameID}/actions. The devices may leave out the game
+// poster, which is the game of the topic.
+func parseDeviceAction(gameID string, payload []byte) (sp.Action, error) {
+	var action sp.Action
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&action); err != nil {
+		return sp.Action{}, fmt.Errorf("failed to unmarshal action: %v", err)
+	}
+	if action.GamePoster == "" {
+		action.GamePoster = gameID
+	}
+	if action.GamePoster != gameID {
+		return sp.Action{}, fmt.Errorf("action of %s published for %s", action.GamePoster, gameID)
+	}
+	teams := strings.Split(gameID, "_")
+	if len(teams) != 2 || teams[0] == "" || teams[1] == "" {
+		return sp.Action{}, fmt.Errorf("game %q is not Team1_Team2", gameID)
+	}
+
+	var errs []error
+	if action.Description == "" {
+		errs = append(errs, errors.New("the description is empty"))
+	}
+	if action.Minute < 0 {
+		errs = append(errs, fmt.Errorf("minute %d is negative", action.Minute))
+	}
+	// The end of the game has no team and no player.
+	if action.Description != sp.EndOfGame {
+		if action.Team != teams[0] && action.Team != teams[1] {
+			errs = append(errs, fmt.Errorf("team %q does not play %s", action.Team, gameID))
+		}
+		if action.PlayerName == "" {
+			errs = append(errs, errors.New("the player is empty"))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return sp.Action{}, err
+	}
+	return action, nil
+}
+
+// ingest is the mqtt.Handler of the server: a valid action is published on
+// the exchange of the actions, where it follows the pipeline of the actions
+// of RabbitMQ, and an invalid one goes to the dead letters. It only fails when
+// the broker does not take the message, so the device action is delivered
+// again.
+func (s *QueueServer) ingest(gameID string, payload []byte) error {
+	mqttActions.Add(1)
+	action, err := parseDeviceAction(gameID, payload)
+	if err != nil {
+		msg := broker.Message{
+			Headers:     map[string]any{HeaderMQTTTopic: "games/" + gameID + "/actions"},
+			ContentType: "application/json",
+			Body:        payload,
+		}
+		return s.publishDeadLetter(msg, fmt.Sprintf("invalid action of a scorer device: %v", err))
+	}
+	body, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	ut.Debugf("MQTT action of %s: %s", gameID, body)
+	key := queue.RoutingKey(action.GamePoster, action.Description)
+	err = s.broker.Publish(s.topology.Exchange, broker.Message{RoutingKey: key, ContentType: "application/json", Body: body})
+	if err != nil {
+		return fmt.Errorf("failed to publish the action of %s: %w", gameID, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sync_score/broker"
+	"sync_score/queue"
+	sp "sync_score/sport"
+)
+
+func TestParseDeviceAction(t *testing.T) {
+	tests := map[string]struct {
+		gameID  string
+		payload string
+		want    sp.Action
+		wantErr string
+	}{
+		"Game of the topic": {
+			gameID:  "Boston_Knicks",
+			payload: `{"team": "Boston", "playername": "JD Davison", "description": "2pts succes", "minute": 3}`,
+			want:    sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: 3},
+		},
+		"Game of the action": {
+			gameID:  "Boston_Knicks",
+			payload: `{"gameposter": "Boston_Knicks", "team": "Knicks", "playername": "Jalen Brunson", "description": "3pts succes", "minute": 12}`,
+			want:    sp.Action{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Jalen Brunson", Description: "3pts succes", Minute: 12},
+		},
+		"End of game": {
+			gameID:  "Boston_Knicks",
+			payload: `{"description": "end of game", "minute": 48}`,
+			want:    sp.Action{GamePoster: "Boston_Knicks", Description: sp.EndOfGame, Minute: 48},
+		},
+		"Not JSON":         {gameID: "Boston_Knicks", payload: `2pts`, wantErr: "failed to unmarshal"},
+		"Unknown field":    {gameID: "Boston_Knicks", payload: `{"team": "Boston", "points": 2}`, wantErr: "unknown field"},
+		"Other game":       {gameID: "Boston_Knicks", payload: `{"gameposter": "Lakers_Bulls", "team": "Lakers"}`, wantErr: "action of Lakers_Bulls published for Boston_Knicks"},
+		"Not two teams":    {gameID: "Boston", payload: `{"team": "Boston"}`, wantErr: "is not Team1_Team2"},
+		"Team not playing": {gameID: "Boston_Knicks", payload: `{"team": "Lakers", "playername": "LeBron James", "description": "2pts succes"}`, wantErr: `team "Lakers" does not play Boston_Knicks`},
+		"Every missing one": {
+			gameID:  "Boston_Knicks",
+			payload: `{"team": "Boston", "minute": -1}`,
+			wantErr: "the description is empty\nminute -1 is negative\nthe player is empty",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseDeviceAction(tc.gameID, []byte(tc.payload))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("parseDeviceAction() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDeviceAction() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("parseDeviceAction() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIngestFeedsTheLiveGamePipeline(t *testing.T) {
+	messages := newTestBroker(t, testTopology, nil)
+	database := &flakyDatabase{}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          2,
+	}
+	before := mqttActions.Value()
+
+	payloads := []string{
+		`{"team": "Boston", "playername": "JD Davison", "description": "2pts succes", "minute": 1}`,
+		`{"team": "Lakers", "playername": "LeBron James", "description": "2pts succes", "minute": 2}`,
+		`{"team": "Knicks", "playername": "Jalen Brunson", "description": "3pts succes", "minute": 3}`,
+	}
+	for _, payload := range payloads {
+		if err := server.ingest("Boston_Knicks", []byte(payload)); err != nil {
+			t.Fatalf("ingest() error = %v", err)
+		}
+	}
+	if got := mqttActions.Value() - before; got != 3 {
+		t.Errorf("MQTT action counter increased by %d, want 3", got)
+	}
+
+	startUntilSettled(t, server, messages)
+
+	if len(database.actions) != 2 {
+		t.Fatalf("the database stored %d actions, want the 2 valid ones", len(database.actions))
+	}
+	for i, want := range []int32{1, 3} {
+		if got := database.actions[i]; got.GamePoster != "Boston_Knicks" || got.Minute != want {
+			t.Errorf("action %d = %s minute %d, want Boston_Knicks minute %d", i, got.GamePoster, got.Minute, want)
+		}
+	}
+	if score, _ := server.cacheGameRecorded.getScore(context.Background(), "Boston_Knicks"); score.ScoreA != 2 || score.ScoreB != 3 {
+		t.Errorf("live score = %d-%d, want 2-3", score.ScoreA, score.ScoreB)
+	}
+
+	dead := messages.Messages(queue.DeadLetters)
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dead))
+	}
+	if reason, _ := dead[0].Headers[queue.HeaderReason].(string); !strings.Contains(reason, "does not play") {
+		t.Errorf("dead letter reason = %q, want the invalid team", reason)
+	}
+	if topic := dead[0].Headers[HeaderMQTTTopic]; topic != "games/Boston_Knicks/actions" {
+		t.Errorf("dead letter topic = %v, want games/Boston_Knicks/actions", topic)
+	}
+	if string(dead[0].Body) != payloads[1] {
+		t.Errorf("dead letter body = %s, want %s", dead[0].Body, payloads[1])
+	}
+}
+
+func TestIngestFailsWhenTheBrokerIsClosed(t *testing.T) {
+	messages := newTestBroker(t, testTopology, nil)
+	server := &QueueServer{broker: messages, topology: testTopology}
+	messages.Close()
+
+	// The device action is not acknowledged, the MQTT broker delivers it
+	// again.
+	err := server.ingest("Boston_Knicks", []byte(`{"team": "Boston", "playername": "JD Davison", "description": "2pts succes"}`))
+	if !errors.Is(err, broker.ErrClosed) {
+		t.Errorf("ingest() error = %v, want %v", err, broker.ErrClosed)
+	}
+}
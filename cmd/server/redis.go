@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"sync_score/config"
+	sp "sync_score/sport"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// countScript counts the points of an action in the score of its game,
+// unless the sequence of the action was counted already. KEYS[1] is the
+// score of the game and KEYS[2] the sequences counted, ARGV[1] is the field
+// of the team, ARGV[2] the points, ARGV[3] the sequence, 0 without, ARGV[4]
+// the ttl in milliseconds, ARGV[5] 1 when a missing score starts from zero
+// and ARGV[6] maxSequences. It returns 0 for a redelivered action, and -1
+// without counting it when the score is missing and ARGV[5] is 0.
+//
+// The sequences are kept as the sequenceSet of CacheGameRecorded: the field
+// low of the score, every sequence up to it counted, and the sorted set
+// KEYS[2] of the ones above it.
+var countScript = redis.NewScript(`
+if ARGV[5] == "0" and redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+if ARGV[3] ~= "0" then
+	local sequence = tonumber(ARGV[3])
+	local low = tonumber(redis.call("HGET", KEYS[1], "low") or "0")
+	if sequence <= low or redis.call("ZADD", KEYS[2], sequence, ARGV[3]) == 0 then
+		return 0
+	end
+	if redis.call("ZCARD", KEYS[2]) > tonumber(ARGV[6]) then
+		low = tonumber(redis.call("ZRANGE", KEYS[2], 0, 0)[1]) - 1
+	end
+	while true do
+		local lowest = redis.call("ZRANGE", KEYS[2], 0, 0)[1]
+		if lowest == nil or tonumber(lowest) > low + 1 then
+			break
+		end
+		redis.call("ZREM", KEYS[2], lowest)
+		low = math.max(low, tonumber(lowest))
+	end
+	redis.call("HSET", KEYS[1], "low", low)
+	redis.call("PEXPIRE", KEYS[2], ARGV[4])
+end
+if ARGV[2] ~= "0" then
+	redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2])
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return 1
+`)
+
+// rebuildScript puts the score of a game rebuilt from the database, unless
+// another replica put it first. KEYS[1] is the score of the game, ARGV[1]
+// and ARGV[2] the scores of the teams and ARGV[3] the ttl in milliseconds.
+// It returns 1 when the score is put.
+var rebuildScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "scoreA", ARGV[1], "scoreB", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// maxRebuilds bounds the rebuilds of a game expiring again before its
+// action is counted.
+const maxRebuilds = 3
+
+// RedisCache keeps the live scores in a Redis server shared by the replicas
+// of the server: each action increments the score of its team atomically,
+// whichever replica consumes it. A game expires once it is not read nor
+// updated for ttl. Redis bounds the games with its maxmemory and its
+// eviction policy, as allkeys-lru.
+//
+// The score of a game is the hash <prefix>:{<game>} of the fields scoreA and
+// scoreB, and the sequences of its actions counted are its field low and the
+// sorted set <prefix>:{<game>}:counted. The braces keep both keys in the same
+// slot of a Redis Cluster. The replicas consume the same queue, the actions
+// of a game may be counted out of order: the sequences counted above low are
+// kept, up to maxSequences of them.
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	// load reads the actions of a missing game, a game starts from zero
+	// without it.
+	load loadFunc
+}
+
+// openRedis returns a client of the Redis server of the configuration.
+func openRedis(r config.Redis) (*redis.Client, error) {
+	opts, err := redis.ParseURL(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	if r.Password != "" {
+		opts.Password = r.Password
+	}
+	return redis.NewClient(opts), nil
+}
+
+// NewRedisCache returns a cache of the live scores in the Redis server of
+// the client, its keys start with prefix and each game is kept for ttl after
+// its last use.
+func NewRedisCache(client *redis.Client, prefix string, ttl time.Duration) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix, ttl: ttl}
+}
+
+func (c *RedisCache) setLoad(load loadFunc) {
+	c.load = load
+}
+
+// Close closes the client.
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// key returns the key of the score of the game.
+func (c *RedisCache) key(gamePoster string) string {
+	return c.prefix + ":{" + gamePoster + "}"
+}
+
+// record counts the action in the score of its game like
+// CacheGameRecorded.record. A game missing from Redis, or expiring before
+// its action is counted, is rebuilt and the action counted again.
+func (c *RedisCache) record(ctx context.Context, action sp.Action, sequence int64, storedAt time.Time) error {
+	record := newScoreRecord(action.GamePoster)
+	field := "scoreB"
+	if action.Team == record.TeamA {
+		field = "scoreA"
+	}
+	// Without load, a missing game starts from zero.
+	fromZero := 0
+	if c.load == nil {
+		fromZero = 1
+	}
+	key := c.key(action.GamePoster)
+	rebuilt := false
+	for rebuilds := 0; ; rebuilds++ {
+		points := points(action.Description)
+		if rebuilt && !storedAt.IsZero() {
+			// The rebuilt score counts it, whichever replica put it: only
+			// its sequence is kept.
+			points = 0
+		}
+		n, err := countScript.Run(ctx, c.client, []string{key, key + ":counted"}, field, points, sequence, c.ttl.Milliseconds(), fromZero, maxSequences).Int()
+		if err != nil {
+			return fmt.Errorf("failed to count the action of %s: %v", action.GamePoster, err)
+		}
+		if n >= 0 {
+			return nil
+		}
+		if rebuilds == maxRebuilds {
+			return fmt.Errorf("the live score of %s expired after %d rebuilds", action.GamePoster, rebuilds)
+		}
+		if err := c.rebuild(ctx, action.GamePoster); err != nil {
+			return err
+		}
+		rebuilt = true
+	}
+}
+
+// getScore returns the score of the game, rebuilt from the database when
+// it is missing from Redis.
+func (c *RedisCache) getScore(ctx context.Context, gamePoster string) (sp.ScoreRecord, error) {
+	fields, err := c.readScore(ctx, gamePoster)
+	if err == nil && len(fields) == 0 && c.load != nil {
+		if err = c.rebuild(ctx, gamePoster); err == nil {
+			fields, err = c.readScore(ctx, gamePoster)
+		}
+	}
+	if err != nil {
+		return sp.ScoreRecord{}, err
+	}
+	if len(fields) == 0 {
+		return sp.ScoreRecord{}, nil
+	}
+	record := newScoreRecord(gamePoster)
+	for field, score := range map[string]*int32{"scoreA": &record.ScoreA, "scoreB": &record.ScoreB} {
+		if fields[field] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[field], 10, 32)
+		if err != nil {
+			return sp.ScoreRecord{}, fmt.Errorf("invalid %s of %s: %v", field, gamePoster, err)
+		}
+		*score = int32(n)
+	}
+	return record, nil
+}
+
+// readScore returns the fields of the score of the game, none when it is
+// missing.
+func (c *RedisCache) readScore(ctx context.Context, gamePoster string) (map[string]string, error) {
+	key := c.key(gamePoster)
+	var scores *redis.MapStringStringCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		scores = pipe.HGetAll(ctx, key)
+		// A read keeps the game in the cache for another ttl.
+		pipe.PExpire(ctx, key, c.ttl)
+		pipe.PExpire(ctx, key+":counted", c.ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the live score of %s: %v", gamePoster, err)
+	}
+	return scores.Val(), nil
+}
+
+// rebuild puts the score of the game rebuilt from the database in Redis.
+// When replicas miss the same game, the first to put it wins.
+func (c *RedisCache) rebuild(ctx context.Context, gamePoster string) error {
+	key := c.key(gamePoster)
+	actions, err := c.load(ctx, gamePoster)
+	if err != nil {
+		return err
+	}
+	record := newScoreRecord(gamePoster)
+	for _, action := range actions {
+		addAction(&record, action)
+	}
+	if err := rebuildScript.Run(ctx, c.client, []string{key}, record.ScoreA, record.ScoreB, c.ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to put the live score of %s: %v", gamePoster, err)
+	}
+	return nil
+}
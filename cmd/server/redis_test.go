@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sp "sync_score/sport"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis returns an in-process Redis server and a cache of its live
+// scores.
+func newTestRedis(t *testing.T, ttl time.Duration) (*miniredis.Miniredis, *RedisCache) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	return server, newTestRedisCache(t, server, ttl)
+}
+
+// newTestRedisCache returns a cache of the live scores of the Redis server,
+// as each replica of the server opens.
+func newTestRedisCache(t *testing.T, server *miniredis.Miniredis, ttl time.Duration) *RedisCache {
+	t.Helper()
+	cache := NewRedisCache(redis.NewClient(&redis.Options{Addr: server.Addr()}), "live", ttl)
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestRedisCacheCountsTheActions(t *testing.T) {
+	server, cache := newTestRedis(t, time.Minute)
+	ctx := context.Background()
+	deliveries := []struct {
+		action   sp.Action
+		sequence int64
+	}{
+		{score("Boston_Knicks", "Boston"), 1},
+		{sp.Action{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "3pts succes"}, 3},
+		// Counted out of order by another replica.
+		{score("Boston_Knicks", "Knicks"), 2},
+		// Redelivered after a requeue.
+		{score("Boston_Knicks", "Boston"), 1},
+		{sp.Action{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "3pts succes"}, 3},
+		{sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "foul"}, 4},
+		// Without sequence, it is counted.
+		{score("Boston_Knicks", "Boston"), 0},
+		{sp.Action{GamePoster: "Lakers_Bulls", Team: "Bulls", Description: "free throw succes"}, 1},
+	}
+	for _, d := range deliveries {
+		if err := cache.record(ctx, d.action, d.sequence, time.Time{}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+
+	want := map[string]sp.ScoreRecord{
+		"Boston_Knicks": {GameName: "Boston_Knicks", TeamA: "Boston", TeamB: "Knicks", ScoreA: 4, ScoreB: 5},
+		"Lakers_Bulls":  {GameName: "Lakers_Bulls", TeamA: "Lakers", TeamB: "Bulls", ScoreB: 1},
+		"Heat_Celtics":  {},
+	}
+	for game, wantScore := range want {
+		got, err := cache.getScore(ctx, game)
+		if err != nil {
+			t.Fatalf("getScore() error = %v", err)
+		}
+		if got != wantScore {
+			t.Errorf("getScore(%s) = %+v, want %+v", game, got, wantScore)
+		}
+	}
+	if got := server.HGet("live:{Boston_Knicks}", "scoreA"); got != "4" {
+		t.Errorf("scoreA of live:{Boston_Knicks} = %q, want 4", got)
+	}
+	// Every sequence up to 4 is counted, none is kept above it.
+	if got := server.HGet("live:{Boston_Knicks}", "low"); got != "4" {
+		t.Errorf("low of live:{Boston_Knicks} = %q, want 4", got)
+	}
+	if server.Exists("live:{Boston_Knicks}:counted") {
+		t.Error("live:{Boston_Knicks}:counted should be empty")
+	}
+}
+
+func TestRedisCacheBoundsTheSequences(t *testing.T) {
+	server, cache := newTestRedis(t, time.Minute)
+	ctx := context.Background()
+	action := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "foul"}
+	if err := cache.record(ctx, action, 1, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	// The action 2 is late, the ones after it are counted.
+	for sequence := int64(3); sequence < 3+maxSequences+10; sequence++ {
+		if err := cache.record(ctx, action, sequence, time.Time{}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	if server.Exists("live:{Boston_Knicks}:counted") {
+		if got, _ := server.ZMembers("live:{Boston_Knicks}:counted"); len(got) > maxSequences {
+			t.Errorf("%d sequences kept, want at most %d", len(got), maxSequences)
+		}
+	}
+
+	// The late action is taken for counted, a new one is counted.
+	late := score("Boston_Knicks", "Boston")
+	if err := cache.record(ctx, late, 2, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	if err := cache.record(ctx, late, 3+maxSequences+10, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	if got := server.HGet("live:{Boston_Knicks}", "scoreA"); got != "2" {
+		t.Errorf("scoreA of live:{Boston_Knicks} = %q, want 2", got)
+	}
+}
+
+func TestRedisCacheSlidingExpiry(t *testing.T) {
+	ttl := time.Minute
+	server, cache := newTestRedis(t, ttl)
+	ctx := context.Background()
+
+	for _, game := range []string{"Boston_Knicks", "Lakers_Bulls"} {
+		if err := cache.record(ctx, score(game, "Boston"), 1, time.Time{}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	// Each use of Boston_Knicks keeps it for another ttl, a write as a read.
+	server.FastForward(40 * time.Second)
+	// Counted before the action 2, its sequence is kept.
+	if err := cache.record(ctx, score("Boston_Knicks", "Knicks"), 3, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	server.FastForward(40 * time.Second)
+	if _, err := cache.getScore(ctx, "Boston_Knicks"); err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	server.FastForward(40 * time.Second)
+
+	for key, want := range map[string]bool{
+		"live:{Boston_Knicks}":         true,
+		"live:{Boston_Knicks}:counted": true,
+		"live:{Lakers_Bulls}":          false,
+		"live:{Lakers_Bulls}:counted":  false,
+	} {
+		if got := server.Exists(key); got != want {
+			t.Errorf("%s exists = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestRedisCacheRebuildsAMissingGame(t *testing.T) {
+	first := []sp.Action{
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: 1},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Jalen Brunson", Description: "3pts succes", Minute: 2},
+	}
+	next := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "free throw succes", Minute: 3}
+
+	tests := map[string]struct {
+		history   []sp.Action
+		stored    bool
+		wantScore [2]int32
+	}{
+		"Action not stored yet": {history: first, stored: false, wantScore: [2]int32{3, 3}},
+		"Action stored":         {history: append(first[:len(first):len(first)], next), stored: true, wantScore: [2]int32{3, 3}},
+		"Game not stored yet":   {history: nil, stored: false, wantScore: [2]int32{1, 0}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, cache := newTestRedis(t, time.Minute)
+			history := &fakeHistory{actions: map[string][]sp.Action{"Boston_Knicks": tc.history}}
+			cache.setLoad(history.load)
+
+			var storedAt time.Time
+			if tc.stored {
+				storedAt = time.Now()
+			}
+			if err := cache.record(context.Background(), next, 3, storedAt); err != nil {
+				t.Fatalf("record() error = %v", err)
+			}
+			got, err := cache.getScore(context.Background(), "Boston_Knicks")
+			if err != nil {
+				t.Fatalf("getScore() error = %v", err)
+			}
+			if got.ScoreA != tc.wantScore[0] || got.ScoreB != tc.wantScore[1] {
+				t.Errorf("getScore() = %d-%d, want %d-%d", got.ScoreA, got.ScoreB, tc.wantScore[0], tc.wantScore[1])
+			}
+			if loads := history.Loads(); loads != 1 {
+				t.Errorf("%d loads, want 1", loads)
+			}
+		})
+	}
+}
+
+// expireOnce deletes the key before the first script run, as a score which
+// expires between two calls.
+type expireOnce struct {
+	server *miniredis.Miniredis
+	key    string
+	once   sync.Once
+}
+
+func (h *expireOnce) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *expireOnce) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if name := cmd.Name(); name == "evalsha" || name == "eval" {
+			h.once.Do(func() { h.server.Del(h.key) })
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *expireOnce) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRedisCacheRebuildsAGameExpiringBeforeTheCount(t *testing.T) {
+	server, cache := newTestRedis(t, time.Minute)
+	history := &fakeHistory{actions: map[string][]sp.Action{"Boston_Knicks": {
+		{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "3pts succes"},
+	}}}
+	cache.setLoad(history.load)
+	server.HSet("live:{Boston_Knicks}", "scoreA", "2", "scoreB", "3")
+	cache.client.AddHook(&expireOnce{server: server, key: "live:{Boston_Knicks}"})
+
+	next := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "free throw succes"}
+	if err := cache.record(context.Background(), next, 3, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	got, err := cache.getScore(context.Background(), "Boston_Knicks")
+	if err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	if got.ScoreA != 3 || got.ScoreB != 3 {
+		t.Errorf("getScore() = %d-%d, want 3-3 rebuilt, not the action alone", got.ScoreA, got.ScoreB)
+	}
+	if loads := history.Loads(); loads != 1 {
+		t.Errorf("%d loads, want 1", loads)
+	}
+}
+
+func TestRedisCacheLosesTheRebuildRace(t *testing.T) {
+	server, cache := newTestRedis(t, time.Minute)
+	history := []sp.Action{
+		{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "3pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Boston", Description: "free throw succes"},
+	}
+	// Another replica puts the score rebuilt with the action stored while
+	// this one loads the game.
+	cache.setLoad(func(ctx context.Context, gamePoster string) ([]sp.Action, error) {
+		server.HSet("live:{Boston_Knicks}", "scoreA", "3", "scoreB", "3")
+		return history, nil
+	})
+
+	if err := cache.record(context.Background(), history[2], 3, time.Now()); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	got, err := cache.getScore(context.Background(), "Boston_Knicks")
+	if err != nil {
+		t.Fatalf("getScore() error = %v", err)
+	}
+	if got.ScoreA != 3 || got.ScoreB != 3 {
+		t.Errorf("getScore() = %d-%d, want 3-3, the action counted once", got.ScoreA, got.ScoreB)
+	}
+}
+
+func TestRedisCacheFailure(t *testing.T) {
+	server, cache := newTestRedis(t, time.Minute)
+	server.SetError("LOADING Redis is loading the dataset in memory")
+
+	err := cache.record(context.Background(), score("Boston_Knicks", "Boston"), 1, time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "Boston_Knicks") {
+		t.Errorf("record() error = %v, want the failure of Redis", err)
+	}
+	if _, err := cache.getScore(context.Background(), "Boston_Knicks"); err == nil {
+		t.Error("getScore() should fail while Redis fails")
+	}
+}
+
+func TestReplicasShareTheLiveScore(t *testing.T) {
+	var bodies [][]byte
+	for minute := int32(0); minute < 20; minute++ {
+		for _, game := range []string{"Boston_Knicks", "Lakers_Bulls", "Heat_Celtics"} {
+			team, _, _ := strings.Cut(game, "_")
+			body, err := json.Marshal(sp.Action{GamePoster: game, Team: team, PlayerName: "Player", Description: "2pts succes", Minute: minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies = append(bodies, body)
+		}
+	}
+	messages := newTestBroker(t, testTopology, bodies)
+	redisServer := miniredis.RunT(t)
+	database := &flakyDatabase{}
+
+	// Two replicas consume the queue of the stats group.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		replica := &QueueServer{
+			grpcDBClient:      database,
+			broker:            messages,
+			topology:          testTopology,
+			cacheGameRecorded: newTestRedisCache(t, redisServer, time.Minute),
+			prefetch:          2,
+			workers:           2,
+			shardQueue:        1,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := replica.Start(ctx); err != nil {
+				errs <- fmt.Errorf("Start() error = %v", err)
+			}
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stats := messages.Stats(statsQueue); stats.Ready > 0 || stats.Unacked > 0; stats = messages.Stats(statsQueue) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d actions ready and %d unacked after 5s", stats.Ready, stats.Unacked)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if len(database.actions) != len(bodies) {
+		t.Fatalf("the database stored %d actions, want %d", len(database.actions), len(bodies))
+	}
+	cache := newTestRedisCache(t, redisServer, time.Minute)
+	for _, game := range []string{"Boston_Knicks", "Lakers_Bulls", "Heat_Celtics"} {
+		score, err := cache.getScore(context.Background(), game)
+		if err != nil {
+			t.Fatalf("getScore() error = %v", err)
+		}
+		if score.ScoreA != 40 || score.ScoreB != 0 {
+			t.Errorf("live score of %s = %d-%d, want 40-0 for the 20 actions of both replicas", game, score.ScoreA, score.ScoreB)
+		}
+	}
+}
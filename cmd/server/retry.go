@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"math/rand"
+	"sync"
+	"time"
+
+	ut "sync_score/utils"
+)
+
+var (
+	// breakerStateVar is the state of the circuit breaker of the database.
+	breakerStateVar = expvar.NewString("databaseBreaker")
+	// breakerOpenings counts the times the database was found unhealthy.
+	breakerOpenings = expvar.NewInt("databaseBreakerOpenings")
+	// retryCount counts the calls to the database sent again.
+	retryCount = expvar.NewInt("databaseRetries")
+)
+
+// retryPolicy spaces the calls sent again after a transient error. The n-th
+// retry waits a random duration between half and all of Initial*2^n, capped
+// at Max. No call is sent again after MaxElapsed, the zero policy sends each
+// call once.
+type retryPolicy struct {
+	Initial    time.Duration
+	Max        time.Duration
+	MaxElapsed time.Duration
+	// jitter returns a random number in [0, n), rand.Int63n when nil.
+	jitter func(n int64) int64
+}
+
+// backoff returns the wait before the retry numbered attempt, from 0.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Initial
+	for i := 0; i < attempt && delay < p.Max; i++ {
+		delay *= 2
+	}
+	if delay > p.Max {
+		delay = p.Max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	jitter := p.jitter
+	if jitter == nil {
+		jitter = rand.Int63n
+	}
+	half := delay / 2
+	return half + time.Duration(jitter(int64(delay-half)+1))
+}
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// breaker is the circuit breaker of the database server. It opens after
+// threshold transient errors in a row, and the workers wait while it is open,
+// which pauses the consumption of the queue. After cooldown one call goes
+// through: the breaker closes when it succeeds and opens again when it fails.
+// A nil breaker never opens.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// closed is closed when the breaker closes, to wake up the workers.
+	closed chan struct{}
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	b := &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+	b.setState(breakerClosed)
+	return b
+}
+
+// setState must be called with the mutex held.
+func (b *breaker) setState(state breakerState) {
+	if b.state != "" {
+		ut.Infof("Database circuit breaker %s -> %s", b.state, state)
+	}
+	switch state {
+	case breakerOpen:
+		b.openedAt = b.now()
+		if b.state != breakerHalfOpen {
+			b.closed = make(chan struct{})
+			breakerOpenings.Add(1)
+		}
+	case breakerClosed:
+		if b.closed != nil {
+			close(b.closed)
+		}
+		b.closed = nil
+		b.failures = 0
+	}
+	b.state = state
+	breakerStateVar.Set(string(state))
+}
+
+// State returns the state of the breaker.
+func (b *breaker) State() breakerState {
+	if b == nil {
+		return breakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// wait blocks while the breaker is open, or half open with the trial call
+// running, and returns the time it waited. It returns early once the context
+// is done.
+func (b *breaker) wait(ctx context.Context) time.Duration {
+	if b == nil {
+		return 0
+	}
+	start := b.now()
+	for {
+		b.mu.Lock()
+		if b.state == breakerClosed {
+			b.mu.Unlock()
+			return b.now().Sub(start)
+		}
+		delay := b.cooldown
+		if b.state == breakerOpen {
+			delay = b.openedAt.Add(b.cooldown).Sub(b.now())
+			if delay <= 0 {
+				// This call is the trial of the database.
+				b.setState(breakerHalfOpen)
+				b.mu.Unlock()
+				return b.now().Sub(start)
+			}
+		}
+		closed := b.closed
+		b.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-closed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return b.now().Sub(start)
+		}
+		timer.Stop()
+	}
+}
+
+// record counts the result of a call to the database. Only the transient
+// errors and the timeouts tell that the database is unhealthy.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isUnhealthy(err) {
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.setState(breakerOpen)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sp "sync_score/sport"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{Initial: 100 * time.Millisecond, Max: time.Second}
+	tests := map[string]struct {
+		attempt int
+		jitter  func(n int64) int64
+		want    time.Duration
+	}{
+		"First retry without jitter":   {0, func(n int64) int64 { return 0 }, 50 * time.Millisecond},
+		"First retry with all jitter":  {0, func(n int64) int64 { return n - 1 }, 100 * time.Millisecond},
+		"Doubled at every retry":       {2, func(n int64) int64 { return n - 1 }, 400 * time.Millisecond},
+		"Capped at the maximum":        {10, func(n int64) int64 { return n - 1 }, time.Second},
+		"Half the maximum when capped": {10, func(n int64) int64 { return 0 }, 500 * time.Millisecond},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := policy
+			p.jitter = tc.jitter
+			if got := p.backoff(tc.attempt); got != tc.want {
+				t.Errorf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+			}
+		})
+	}
+	if got := (retryPolicy{}).backoff(3); got != 0 {
+		t.Errorf("backoff() of the zero policy = %v, want 0", got)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "database server down")
+	now := time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.record(unavailable)
+	if b.State() != breakerClosed {
+		t.Fatalf("State() = %s after 1 failure, want closed", b.State())
+	}
+	b.record(status.Error(codes.InvalidArgument, "invalid game"))
+	b.record(unavailable)
+	if b.State() != breakerClosed {
+		t.Fatalf("State() = %s, a rejected action should reset the failures", b.State())
+	}
+	openings := breakerOpenings.Value()
+	b.record(unavailable)
+	if b.State() != breakerOpen || breakerStateVar.Value() != "open" {
+		t.Fatalf("State() = %s after 2 failures in a row, want open", b.State())
+	}
+
+	// Once the cooldown is over, a trial call goes through.
+	now = now.Add(time.Minute)
+	if waited := b.wait(context.Background()); waited != 0 || b.State() != breakerHalfOpen {
+		t.Fatalf("wait() = %v with state %s, want the trial call", waited, b.State())
+	}
+	b.record(unavailable)
+	if b.State() != breakerOpen {
+		t.Fatalf("State() = %s after a failed trial, want open", b.State())
+	}
+	now = now.Add(time.Minute)
+	b.wait(context.Background())
+	b.record(nil)
+	if b.State() != breakerClosed || breakerStateVar.Value() != "closed" {
+		t.Errorf("State() = %s after a successful trial, want closed", b.State())
+	}
+	if got := breakerOpenings.Value() - openings; got != 1 {
+		t.Errorf("the breaker opened %d times, want 1", got)
+	}
+}
+
+func TestSendActionRetries(t *testing.T) {
+	action := sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"}
+	tests := map[string]struct {
+		down        int
+		maxElapsed  time.Duration
+		wantErr     codes.Code
+		wantStored  int
+		wantRetries int64
+	}{
+		"Stored after the retries": {down: 3, maxElapsed: time.Minute, wantErr: codes.OK, wantStored: 1, wantRetries: 3},
+		"Given up after the time":  {down: 100, maxElapsed: 20 * time.Millisecond, wantErr: codes.Unavailable},
+		"Not retried by default":   {down: 1, wantErr: codes.Unavailable},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			database := &flakyDatabase{down: tc.down}
+			server := &QueueServer{
+				grpcDBClient: database,
+				retry:        retryPolicy{Initial: time.Millisecond, Max: 4 * time.Millisecond, MaxElapsed: tc.maxElapsed},
+			}
+			retries := retryCount.Value()
+
+			err := server.sendAction(context.Background(), action)
+			if status.Code(err) != tc.wantErr {
+				t.Errorf("sendAction() error = %v, want code %v", err, tc.wantErr)
+			}
+			if len(database.actions) != tc.wantStored {
+				t.Errorf("the database stored %d actions, want %d", len(database.actions), tc.wantStored)
+			}
+			if got := retryCount.Value() - retries; tc.wantRetries > 0 && got != tc.wantRetries {
+				t.Errorf("%d retries, want %d", got, tc.wantRetries)
+			}
+		})
+	}
+}
+
+func TestSendActionWaitsForTheBreaker(t *testing.T) {
+	database := &flakyDatabase{down: 4}
+	server := &QueueServer{
+		grpcDBClient: database,
+		retry:        retryPolicy{Initial: time.Millisecond, Max: time.Millisecond, MaxElapsed: 10 * time.Millisecond},
+		breaker:      newBreaker(2, 30*time.Millisecond),
+	}
+	start := time.Now()
+
+	// The breaker opens after 2 failures and lets a trial call through
+	// every 30ms: the retries stop at the pauses and the action is stored.
+	err := server.sendAction(context.Background(), sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"})
+	if err != nil {
+		t.Fatalf("sendAction() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("sendAction() took %v, want the pauses of the breaker", elapsed)
+	}
+	if server.breaker.State() != breakerClosed {
+		t.Errorf("State() = %s, want closed once the database is back", server.breaker.State())
+	}
+}
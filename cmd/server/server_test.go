@@ -1,10 +1,21 @@
 package main
 
 import (
-	sp "statistic-syncer/sport"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync_score/broker"
+	pb "sync_score/proto"
+	"sync_score/queue"
+	sp "sync_score/sport"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestUpdateCache(t *testing.T) {
@@ -376,7 +387,9 @@ func TestUpdateCache(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			cache := NewCacheGameRecorded()
+			now := time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)
+			cache := NewCacheGameRecorded(time.Minute, 0)
+			cache.now = func() time.Time { return now }
 			cache.games = tc.initialCache
 
 			cache.updateCache(tc.action)
@@ -390,6 +403,10 @@ func TestUpdateCache(t *testing.T) {
 				if !exists {
 					t.Fatalf("game %s not found in cache", gameName)
 				}
+				if gameName == tc.action.GamePoster && points(tc.action.Description) > 0 {
+					// An update is a use of the game.
+					wantGame.LastRead = now
+				}
 				if gotGame != wantGame {
 					t.Errorf("game %s = %+v, want %+v", gameName, gotGame, wantGame)
 				}
@@ -458,7 +475,7 @@ func TestGetScore(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			cache := NewCacheGameRecorded()
+			cache := NewCacheGameRecorded(time.Minute, 0)
 			cache.games = tc.initialCache
 
 			// Test concurrent access for each test case
@@ -469,9 +486,9 @@ func TestGetScore(t *testing.T) {
 			for i := 0; i < numGoroutines; i++ {
 				go func() {
 					defer wg.Done()
-					got := cache.getScore(tc.gamePoster)
-					if got != tc.want {
-						t.Errorf("getScore() = %v, want %v", got, tc.want)
+					got, err := cache.getScore(context.Background(), tc.gamePoster)
+					if err != nil || got != tc.want {
+						t.Errorf("getScore() = %v, %v, want %v", got, err, tc.want)
 					}
 				}()
 			}
@@ -482,7 +499,7 @@ func TestGetScore(t *testing.T) {
 }
 
 func Test_updateCache_concurent(t *testing.T) {
-	cache := NewCacheGameRecorded()
+	cache := NewCacheGameRecorded(time.Minute, 0)
     
     // Initialize cache with a game record
     initialGame := sp.ScoreRecord{
@@ -533,7 +550,7 @@ func Test_updateCache_concurent(t *testing.T) {
     wg.Wait()
 
     // Verify final state
-    finalScore := cache.getScore("Boston_Knicks")
+    finalScore, _ := cache.getScore(context.Background(), "Boston_Knicks")
     
     // Each goroutine adds: 2 + 3 + 1 = 6 points for each team
     // Total points per team = 6 * numGoroutines
@@ -549,8 +566,9 @@ func Test_updateCache_concurent(t *testing.T) {
 }
 
 func TestClearCacheIfExpired(t *testing.T) {
-	// Use a short TTL for testing
+	// The clock of the cache is frozen at now.
 	ttl := 100 * time.Millisecond
+	now := time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)
 
 	tests := map[string]struct {
 		setup    func(*CacheGameRecorded)
@@ -558,7 +576,7 @@ func TestClearCacheIfExpired(t *testing.T) {
 	}{
 		"Expired entries should be removed": {
 			setup: func(c *CacheGameRecorded) {
-				expiredTime := time.Now().Add(-2 * ttl)
+				expiredTime := now.Add(-2 * ttl)
 				c.games = map[string]sp.ScoreRecord{
 					"Game1": {
 						GameName: "Game1",
@@ -578,7 +596,7 @@ func TestClearCacheIfExpired(t *testing.T) {
 		},
 		"Valid entries should be kept": {
 			setup: func(c *CacheGameRecorded) {
-				validTime := time.Now()
+				validTime := now.Add(-ttl / 2)
 				c.games = map[string]sp.ScoreRecord{
 					"Game1": {
 						GameName: "Game1",
@@ -598,8 +616,8 @@ func TestClearCacheIfExpired(t *testing.T) {
 		},
 		"Mixed expired and valid entries": {
 			setup: func(c *CacheGameRecorded) {
-				validTime := time.Now()
-				expiredTime := time.Now().Add(-2 * ttl)
+				validTime := now.Add(-ttl / 2)
+				expiredTime := now.Add(-2 * ttl)
 				c.games = map[string]sp.ScoreRecord{
 					"ExpiredGame": {
 						GameName: "ExpiredGame",
@@ -625,7 +643,7 @@ func TestClearCacheIfExpired(t *testing.T) {
 		},
 		"Future timestamps should be reset": {
 			setup: func(c *CacheGameRecorded) {
-				futureTime := time.Now().Add(24 * time.Hour)
+				futureTime := now.Add(24 * time.Hour)
 				c.games = map[string]sp.ScoreRecord{
 					"FutureGame": {
 						GameName: "FutureGame",
@@ -637,7 +655,7 @@ func TestClearCacheIfExpired(t *testing.T) {
 			},
 			validate: func(t *testing.T, c *CacheGameRecorded) {
 				if game, exists := c.games["FutureGame"]; exists {
-					if !game.LastRead.Before(time.Now().Add(time.Second)) {
+					if !game.LastRead.Equal(now) {
 						t.Error("Future timestamp should have been reset to current time")
 					}
 					// Verify other data remains unchanged
@@ -663,7 +681,8 @@ func TestClearCacheIfExpired(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			cache := NewCacheGameRecorded(ttl)
+			cache := NewCacheGameRecorded(ttl, 0)
+			cache.now = func() time.Time { return now }
 			tc.setup(cache)
 			
 			// Execute clearCacheIfExpired
@@ -674,3 +693,511 @@ func TestClearCacheIfExpired(t *testing.T) {
 		})
 	}
 }
+
+// testTopology routes every action to the stats writer, which feeds the
+// live cache.
+var testTopology = queue.NewTopology("actions", map[string][]string{queue.GroupStats: {"game.#"}})
+
+const statsQueue = "actions.stats"
+
+// newTestBroker returns an in-memory broker with the queues of the topology
+// and the dead letters, and the bodies published on the exchange with their
+// sequence in their game, as the client does.
+func newTestBroker(t *testing.T, topology queue.Topology, bodies [][]byte) *broker.Memory {
+	t.Helper()
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	if err := declareQueues(b, topology, queue.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	sequences := make(map[string]int64)
+	for _, body := range bodies {
+		// An undecodable body is routed as an action without game.
+		var action sp.Action
+		json.Unmarshal(body, &action)
+		key := queue.RoutingKey(action.GamePoster, action.Description)
+		sequences[action.GamePoster]++
+		headers := map[string]any{queue.HeaderSequence: sequences[action.GamePoster]}
+		if err := b.Publish(topology.Exchange, broker.Message{RoutingKey: key, Headers: headers, Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+// startUntilSettled runs the server until every action of the queues it
+// consumes is settled, then stops it.
+func startUntilSettled(t *testing.T, server *QueueServer, b *broker.Memory) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, group := range []string{queue.GroupStats, queue.GroupLiveCache} {
+		name, ok := server.topology.Queue(group)
+		if !ok {
+			continue
+		}
+		for stats := b.Stats(name); stats.Ready > 0 || stats.Unacked > 0; stats = b.Stats(name) {
+			select {
+			case err := <-done:
+				t.Fatalf("Start() = %v with %d actions ready and %d unacked in %s", err, stats.Ready, stats.Unacked, name)
+			default:
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d actions ready and %d unacked in %s after 5s", stats.Ready, stats.Unacked, name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+}
+
+// flakyDatabase stores up actions, then fails down calls as a database
+// server which is down, then stores again. The actions of the game rejected
+// are refused for good. Every call takes delay, and maxCalls records the
+// most calls running at once.
+type flakyDatabase struct {
+	pb.GameCenterDatabaseClient
+	mu       sync.Mutex
+	up       int
+	down     int
+	rejected string
+	// timedOut is a game whose actions time out.
+	timedOut string
+	timeouts int
+	delay    time.Duration
+	calls    int
+	maxCalls int
+	actions  []*pb.Action
+}
+
+func (d *flakyDatabase) SendGameAction(ctx context.Context, in *pb.Action, opts ...grpc.CallOption) (*pb.ActionReply, error) {
+	d.mu.Lock()
+	d.calls++
+	d.maxCalls = max(d.maxCalls, d.calls)
+	d.mu.Unlock()
+	time.Sleep(d.delay)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls--
+	if in.GamePoster == d.rejected {
+		return nil, status.Error(codes.InvalidArgument, "invalid game")
+	}
+	if in.GamePoster == d.timedOut {
+		d.timeouts++
+		return nil, status.Error(codes.DeadlineExceeded, "context deadline exceeded")
+	}
+	if len(d.actions) >= d.up && d.down > 0 {
+		d.down--
+		return nil, status.Error(codes.Unavailable, "database server down")
+	}
+	d.actions = append(d.actions, in)
+	return &pb.ActionReply{Status: "received"}, nil
+}
+
+func TestStartLosesNoActionWhileDatabaseIsDown(t *testing.T) {
+	var bodies [][]byte
+	var want []sp.Action
+	for minute := int32(0); minute < 20; minute++ {
+		for _, game := range []string{"Boston_Knicks", "Bulls_cavaliers"} {
+			action := sp.Action{GamePoster: game, Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: minute}
+			body, err := json.Marshal(action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies = append(bodies, body)
+			want = append(want, action)
+		}
+	}
+	messages := newTestBroker(t, testTopology, bodies)
+	database := &flakyDatabase{up: 5, down: 7}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          4,
+		retryDelay:        time.Millisecond,
+	}
+
+	startUntilSettled(t, server, messages)
+
+	if len(database.actions) != len(want) {
+		t.Fatalf("the database stored %d actions, want %d", len(database.actions), len(want))
+	}
+	// A game waits for its action requeued, the other one goes on.
+	next := make(map[string]int32)
+	for _, got := range database.actions {
+		if got.Minute != next[got.GamePoster] {
+			t.Errorf("action of %s minute %d stored after minute %d", got.GamePoster, got.Minute, next[got.GamePoster]-1)
+		}
+		next[got.GamePoster] = got.Minute + 1
+	}
+	stats := messages.Stats(statsQueue)
+	if stats.Acked != len(want) {
+		t.Errorf("%d actions acknowledged, want %d", stats.Acked, len(want))
+	}
+	if stats.MaxUnacked > server.prefetch {
+		t.Errorf("%d actions were not acknowledged at once, the prefetch is %d", stats.MaxUnacked, server.prefetch)
+	}
+	// Every action is counted once in the live score.
+	if score, _ := server.cacheGameRecorded.getScore(context.Background(), "Boston_Knicks"); score.ScoreA != 40 {
+		t.Errorf("live score of Boston = %d, want 40", score.ScoreA)
+	}
+}
+
+func TestStartStopsDuringTheRetryDelay(t *testing.T) {
+	body, _ := json.Marshal(sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"})
+	messages := newTestBroker(t, testTopology, [][]byte{body})
+	database := &flakyDatabase{down: 1000}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          1,
+		retryDelay:        time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		database.mu.Lock()
+		down := database.down
+		database.mu.Unlock()
+		if down < 1000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no call to the database after 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start() still waits for the retry delay 1s after the stop")
+	}
+	if acked := messages.Stats(statsQueue).Acked; acked != 0 {
+		t.Errorf("%d actions acknowledged, want the action left to the broker", acked)
+	}
+}
+
+func TestStartSendsPoisonMessagesToDeadLetters(t *testing.T) {
+	valid := func(minute int32) []byte {
+		body, _ := json.Marshal(sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: minute})
+		return body
+	}
+	rejected, _ := json.Marshal(sp.Action{GamePoster: "Bad game", Description: "2pts succes"})
+	timedOut, _ := json.Marshal(sp.Action{GamePoster: "Slow game", Description: "2pts succes"})
+	bodies := [][]byte{valid(0), []byte(`{"gameposter": `), valid(1), rejected, valid(2), timedOut, valid(3)}
+	messages := newTestBroker(t, testTopology, bodies)
+	database := &flakyDatabase{rejected: "Bad game", timedOut: "Slow game"}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          2,
+		// A timed out action may be stored, it is not sent again.
+		retry: retryPolicy{Initial: time.Millisecond, Max: time.Millisecond, MaxElapsed: time.Second},
+	}
+	before := deadLetterCount.Value()
+
+	startUntilSettled(t, server, messages)
+
+	if len(database.actions) != 4 {
+		t.Errorf("the database stored %d actions, want the 4 valid ones", len(database.actions))
+	}
+	if database.timeouts != 1 {
+		t.Errorf("the timed out action was sent %d times, want 1", database.timeouts)
+	}
+	dead := messages.Messages(queue.DeadLetters)
+	if len(dead) != 3 {
+		t.Fatalf("got %d dead letters, want 3", len(dead))
+	}
+	reasons := map[int]string{0: "failed to unmarshal", 1: "rejected by the database", 2: "maybe stored by the database"}
+	for i, want := range reasons {
+		reason, _ := dead[i].Headers[queue.HeaderReason].(string)
+		if !strings.Contains(reason, want) {
+			t.Errorf("dead letter %d reason = %q, want %q", i, reason, want)
+		}
+		if string(dead[i].Body) != string(bodies[2*i+1]) {
+			t.Errorf("dead letter %d body = %s, want %s", i, dead[i].Body, bodies[2*i+1])
+		}
+	}
+	if got := deadLetterCount.Value() - before; got != 3 {
+		t.Errorf("dead letter counter increased by %d, want 3", got)
+	}
+	if acked := messages.Stats(statsQueue).Acked; acked != len(bodies) {
+		t.Errorf("%d messages acknowledged, want %d", acked, len(bodies))
+	}
+}
+
+func TestStartKeepsTheOrderOfEachGame(t *testing.T) {
+	var bodies [][]byte
+	for minute := int32(0); minute < 15; minute++ {
+		for game := 0; game < 12; game++ {
+			action := sp.Action{GamePoster: fmt.Sprintf("Team%d_Team%d", game, game+100), Team: fmt.Sprintf("Team%d", game), Description: "2pts succes", Minute: minute}
+			body, err := json.Marshal(action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies = append(bodies, body)
+		}
+	}
+	messages := newTestBroker(t, testTopology, bodies)
+	database := &flakyDatabase{up: 40, down: 10, delay: 100 * time.Microsecond}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          12,
+		retryDelay:        time.Millisecond,
+		workers:           4,
+		shardQueue:        2,
+	}
+
+	startUntilSettled(t, server, messages)
+
+	if len(database.actions) != len(bodies) {
+		t.Fatalf("the database stored %d actions, want %d", len(database.actions), len(bodies))
+	}
+	next := make(map[string]int32)
+	for _, got := range database.actions {
+		if got.Minute != next[got.GamePoster] {
+			t.Fatalf("action of %s minute %d stored after minute %d", got.GamePoster, got.Minute, next[got.GamePoster]-1)
+		}
+		next[got.GamePoster]++
+	}
+	stats := messages.Stats(statsQueue)
+	if stats.Acked != len(bodies) {
+		t.Errorf("%d actions acknowledged, want %d", stats.Acked, len(bodies))
+	}
+	if stats.MaxUnacked > server.prefetch {
+		t.Errorf("%d actions were not acknowledged at once, the prefetch is %d", stats.MaxUnacked, server.prefetch)
+	}
+	if database.maxCalls < 2 {
+		t.Errorf("at most %d action stored at once, want the games stored in parallel", database.maxCalls)
+	}
+	for game := 0; game < 12; game++ {
+		poster := fmt.Sprintf("Team%d_Team%d", game, game+100)
+		if score, _ := server.cacheGameRecorded.getScore(context.Background(), poster); score.ScoreA != 30 {
+			t.Errorf("live score of %s = %d, want 30", poster, score.ScoreA)
+		}
+	}
+}
+
+// settlements records the acks and the requeues of the deliveries of a test.
+type settlements struct {
+	log []string
+}
+
+// scriptedDelivery is an action of the game at minute delivered to a worker,
+// with the header of its sequence unless it is 0.
+type scriptedDelivery struct {
+	game        string
+	minute      int32
+	sequence    int64
+	redelivered bool
+}
+
+// delivery returns the delivery of the action, settled in s.
+func (s *settlements) delivery(game string, minute int32, sequence int64, redelivered bool) delivery {
+	action := sp.Action{GamePoster: game, Team: "Boston", Description: "2pts succes", Minute: minute}
+	body, _ := json.Marshal(action)
+	msg := broker.Message{Body: body}
+	if sequence > 0 {
+		msg.Headers = map[string]any{queue.HeaderSequence: sequence}
+	}
+	name := fmt.Sprintf("%s %d", game, minute)
+	return delivery{msg: broker.Delivery{Message: msg, Redelivered: redelivered, Acknowledger: settlementAcknowledger{s, name}}, action: action}
+}
+
+type settlementAcknowledger struct {
+	s    *settlements
+	name string
+}
+
+func (a settlementAcknowledger) Ack() error {
+	a.s.log = append(a.s.log, "ack "+a.name)
+	return nil
+}
+
+func (a settlementAcknowledger) Nack(requeue bool) error {
+	a.s.log = append(a.s.log, "requeue "+a.name)
+	return nil
+}
+
+func TestWorkHoldsOnlyTheGameRequeued(t *testing.T) {
+	tests := map[string]struct {
+		holdTimeout time.Duration
+		// The first action delivered fails on a transient error.
+		deliveries []scriptedDelivery
+		want       []string
+	}{
+		"Held until it comes back": {
+			deliveries: []scriptedDelivery{{"Boston_Knicks", 1, 1, false}, {"Lakers_Bulls", 1, 1, false}, {"Boston_Knicks", 2, 2, false}, {"Boston_Knicks", 1, 1, true}, {"Boston_Knicks", 2, 2, true}},
+			want: []string{"requeue Boston_Knicks 1", "ack Lakers_Bulls 1", "requeue Boston_Knicks 2", "ack Boston_Knicks 1", "ack Boston_Knicks 2"},
+		},
+		"Without sequence": {
+			deliveries: []scriptedDelivery{{"Boston_Knicks", 1, 0, false}, {"Boston_Knicks", 2, 0, false}},
+			want:       []string{"requeue Boston_Knicks 1", "ack Boston_Knicks 2"},
+		},
+		"Given up after the timeout": {
+			// The action requeued went to another replica.
+			holdTimeout: time.Nanosecond,
+			deliveries:  []scriptedDelivery{{"Boston_Knicks", 1, 1, false}, {"Boston_Knicks", 2, 2, false}},
+			want: []string{"requeue Boston_Knicks 1", "ack Boston_Knicks 2"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := &QueueServer{
+				grpcDBClient:      &flakyDatabase{down: 1},
+				topology:          testTopology,
+				cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+				holdTimeout:       tc.holdTimeout,
+			}
+			settled := &settlements{}
+			deliveries := make(chan delivery, len(tc.deliveries))
+			for _, d := range tc.deliveries {
+				deliveries <- settled.delivery(d.game, d.minute, d.sequence, d.redelivered)
+			}
+			close(deliveries)
+			if err := server.work(context.Background(), deliveries); err != nil {
+				t.Fatalf("work() error = %v", err)
+			}
+			if strings.Join(settled.log, ", ") != strings.Join(tc.want, ", ") {
+				t.Errorf("work() settled %v, want %v", settled.log, tc.want)
+			}
+		})
+	}
+}
+
+func TestStartRequeuesTheActionsOnShutdown(t *testing.T) {
+	var bodies [][]byte
+	for minute := int32(0); minute < 50; minute++ {
+		body, _ := json.Marshal(sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes", Minute: minute})
+		bodies = append(bodies, body)
+	}
+	messages := newTestBroker(t, testTopology, bodies)
+	database := &flakyDatabase{delay: time.Millisecond}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          testTopology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          8,
+		workers:           2,
+		shardQueue:        4,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	for {
+		database.mu.Lock()
+		stored := len(database.actions)
+		database.mu.Unlock()
+		if stored >= 10 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start() did not return once the context is done")
+	}
+	server.Close()
+
+	database.mu.Lock()
+	defer database.mu.Unlock()
+	stats := messages.Stats(statsQueue)
+	if stats.Unacked != 0 {
+		t.Errorf("%d actions neither acknowledged nor requeued", stats.Unacked)
+	}
+	if stats.Acked != len(database.actions) || stats.Acked == len(bodies) {
+		t.Errorf("%d actions acknowledged for %d stored, want the stored ones of a part of the %d actions", stats.Acked, len(database.actions), len(bodies))
+	}
+	if stats.Acked+stats.Ready != len(bodies) {
+		t.Errorf("%d acknowledged and %d in the queue, want %d", stats.Acked, stats.Ready, len(bodies))
+	}
+}
+
+func TestStartRoutesTheActionsToEachGroup(t *testing.T) {
+	topology := queue.NewTopology("actions", map[string][]string{
+		queue.GroupStats:     {"game.#"},
+		queue.GroupLiveCache: {"game.*.2pts-succes", "game.*.3pts-succes"},
+		queue.GroupNotifier:  {"game.*.end-of-game"},
+	})
+	var bodies [][]byte
+	for _, action := range []sp.Action{
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "2pts succes", Minute: 1},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Jalen Brunson", Description: "foul", Minute: 2},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Jalen Brunson", Description: "3pts succes", Minute: 3},
+		{GamePoster: "Boston_Knicks", Description: sp.EndOfGame, Minute: 48},
+	} {
+		body, err := json.Marshal(action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, body)
+	}
+	messages := newTestBroker(t, topology, bodies)
+	// The live cache has its own queue: it counts the scores even when the
+	// database rejects the game.
+	database := &flakyDatabase{rejected: "Boston_Knicks"}
+	server := &QueueServer{
+		grpcDBClient:      database,
+		broker:            messages,
+		topology:          topology,
+		cacheGameRecorded: NewCacheGameRecorded(time.Minute, 0),
+		prefetch:          2,
+	}
+
+	startUntilSettled(t, server, messages)
+
+	queues := map[string]int{queue.GroupStats: 4, queue.GroupLiveCache: 2}
+	for group, want := range queues {
+		name, _ := topology.Queue(group)
+		if acked := messages.Stats(name).Acked; acked != want {
+			t.Errorf("%d actions acknowledged in %s, want %d", acked, name, want)
+		}
+	}
+	if score, _ := server.cacheGameRecorded.getScore(context.Background(), "Boston_Knicks"); score.ScoreA != 2 || score.ScoreB != 3 {
+		t.Errorf("live score = %d-%d, want 2-3", score.ScoreA, score.ScoreB)
+	}
+	if dead := messages.Messages(queue.DeadLetters); len(dead) != 4 {
+		t.Errorf("got %d dead letters, want the 4 actions rejected by the database", len(dead))
+	} else if q := dead[0].Headers[queue.HeaderQueue]; q != statsQueue {
+		t.Errorf("dead letter queue = %v, want %s", q, statsQueue)
+	}
+	notifier, _ := topology.Queue(queue.GroupNotifier)
+	if got := messages.Messages(notifier); len(got) != 1 || got[0].RoutingKey != "game.Boston_Knicks.end-of-game" {
+		t.Errorf("notifier messages = %+v, want the end of the game", got)
+	}
+}
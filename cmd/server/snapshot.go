@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+)
+
+// snapshotFormat starts the first line of a snapshot file, followed by the
+// CRC-32 of the JSON content which makes up the rest of the file.
+const snapshotFormat = "live-scores/v1"
+
+// cacheSnapshot is the content of a snapshot of the live scores.
+type cacheSnapshot struct {
+	SavedAt time.Time      `json:"savedAt"`
+	Games   []snapshotGame `json:"games"`
+}
+
+// snapshotGame is the score of a game and the sequences of the actions it
+// counts: every one up to Sequence, 0 when the actions of the game have none,
+// and Sequences above it.
+type snapshotGame struct {
+	Score     sp.ScoreRecord `json:"score"`
+	Sequence  int64          `json:"sequence,omitempty"`
+	Sequences []int64        `json:"sequences,omitempty"`
+}
+
+// snapshot returns the games of the cache, by name.
+func (c *CacheGameRecorded) snapshot() cacheSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap := cacheSnapshot{SavedAt: c.now(), Games: make([]snapshotGame, 0, len(c.games))}
+	for name, record := range c.games {
+		record.LastRead = time.Time{}
+		game := snapshotGame{Score: record}
+		if set, ok := c.sequences[name]; ok {
+			game.Sequence, game.Sequences = set.low, set.sorted()
+		}
+		snap.Games = append(snap.Games, game)
+	}
+	sort.Slice(snap.Games, func(i, j int) bool { return snap.Games[i].Score.GameName < snap.Games[j].Score.GameName })
+	return snap
+}
+
+// restore puts the games of the snapshot in the cache, as used now.
+func (c *CacheGameRecorded) restore(snap cacheSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, game := range snap.Games {
+		c.put(game.Score)
+		if game.Sequence > 0 || len(game.Sequences) > 0 {
+			set := &sequenceSet{low: game.Sequence}
+			for _, sequence := range game.Sequences {
+				set.add(sequence)
+			}
+			c.sequences[game.Score.GameName] = set
+		}
+	}
+}
+
+// SaveSnapshot writes the games of the cache to the file at path. The
+// snapshot is written to a temporary file of the same directory renamed
+// over path, a crash leaves the previous snapshot whole.
+func (c *CacheGameRecorded) SaveSnapshot(path string) error {
+	content, err := json.Marshal(c.snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to marshal the live scores: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = fmt.Fprintf(tmp, "%s %08x\n", snapshotFormat, crc32.ChecksumIEEE(content))
+	if err == nil {
+		_, err = tmp.Write(content)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %v", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot puts the games of the snapshot at path in the cache, and
+// returns their number. A missing file restores nothing. A file whose
+// checksum does not match is rejected as a whole.
+func (c *CacheGameRecorded) RestoreSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	header, content, _ := bytes.Cut(data, []byte("\n"))
+	var checksum uint32
+	if _, err := fmt.Sscanf(string(header), snapshotFormat+" %08x", &checksum); err != nil {
+		return 0, fmt.Errorf("snapshot %s is not a %s snapshot", path, snapshotFormat)
+	}
+	if got := crc32.ChecksumIEEE(content); got != checksum {
+		return 0, fmt.Errorf("snapshot %s is corrupted: checksum %08x, want %08x", path, got, checksum)
+	}
+	var snap cacheSnapshot
+	if err := json.Unmarshal(content, &snap); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal snapshot: %v", err)
+	}
+	c.restore(snap)
+	return len(snap.Games), nil
+}
+
+// RunSnapshots saves a snapshot to path every interval until the context
+// is done. After a crash, the scores restored miss the actions acknowledged
+// since the last snapshot: the final snapshot is saved on shutdown once the
+// actions in flight are counted.
+func (c *CacheGameRecorded) RunSnapshots(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SaveSnapshot(path); err != nil {
+				ut.Infof("Live scores not saved: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sp "sync_score/sport"
+)
+
+func TestSnapshotRestoresTheLiveScores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "live-scores")
+	ctx := context.Background()
+	saved := NewCacheGameRecorded(time.Minute, 0)
+	actions := []sp.Action{
+		{GamePoster: "Boston_Knicks", Team: "Boston", Description: "2pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "3pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Boston", Description: "foul"},
+	}
+	for i, action := range actions {
+		if err := saved.record(ctx, action, int64(i+1), time.Time{}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	// The action 5 is counted before the action 4.
+	if err := saved.record(ctx, sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "foul"}, 5, time.Time{}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	// The actions of the devices have no sequence.
+	saved.updateCache(sp.Action{GamePoster: "Lakers_Bulls", Team: "Bulls", Description: "free throw succes"})
+	if err := saved.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	// The server restarts.
+	now := time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)
+	cache := NewCacheGameRecorded(time.Minute, 0)
+	cache.now = func() time.Time { return now }
+	n, err := cache.RestoreSnapshot(path)
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("RestoreSnapshot() = %d games, want 2", n)
+	}
+	want := map[string]sp.ScoreRecord{
+		"Boston_Knicks": {GameName: "Boston_Knicks", TeamA: "Boston", TeamB: "Knicks", ScoreA: 2, ScoreB: 3, LastRead: now},
+		"Lakers_Bulls":  {GameName: "Lakers_Bulls", TeamA: "Lakers", TeamB: "Bulls", ScoreB: 1, LastRead: now},
+	}
+	for name, wantGame := range want {
+		if got := cache.games[name]; got != wantGame {
+			t.Errorf("game %s = %+v, want %+v", name, got, wantGame)
+		}
+	}
+	if got := cache.sequences["Boston_Knicks"]; len(cache.sequences) != 1 || got == nil || got.low != 3 || !got.has(5) || got.has(4) {
+		t.Errorf("sequences of Boston_Knicks = %+v, want up to 3 and 5", got)
+	}
+
+	// The actions not acknowledged before the restart are delivered again.
+	for i, action := range append(actions[1:], sp.Action{GamePoster: "Boston_Knicks", Team: "Knicks", Description: "2pts succes"}) {
+		if err := cache.record(ctx, action, int64(i+2), time.Time{}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	if got := cache.games["Boston_Knicks"]; got.ScoreA != 2 || got.ScoreB != 5 {
+		t.Errorf("score after the redeliveries = %d-%d, want 2-5", got.ScoreA, got.ScoreB)
+	}
+	if got := cache.Stats().Redeliveries; got != 2 {
+		t.Errorf("Stats().Redeliveries = %d, want 2", got)
+	}
+}
+
+func TestRestoreSnapshotRejectsABrokenFile(t *testing.T) {
+	dir := t.TempDir()
+	saved := NewCacheGameRecorded(time.Minute, 0)
+	saved.updateCache(sp.Action{GamePoster: "Boston_Knicks", Team: "Boston", Description: "3pts succes"})
+	path := filepath.Join(dir, "live-scores")
+	if err := saved.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		data    []byte
+		wantErr string
+	}{
+		"Corrupted": {data: bytes.Replace(data, []byte(`"scoreA":3`), []byte(`"scoreA":9`), 1), wantErr: "is corrupted"},
+		"Truncated": {data: data[:len(data)-5], wantErr: "is corrupted"},
+		"No header": {data: data[bytes.IndexByte(data, '\n')+1:], wantErr: "is not a live-scores/v1 snapshot"},
+		"Empty":     {data: nil, wantErr: "is not a live-scores/v1 snapshot"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-"))
+			if err := os.WriteFile(path, tc.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cache := NewCacheGameRecorded(time.Minute, 0)
+			n, err := cache.RestoreSnapshot(path)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("RestoreSnapshot() error = %v, want %q", err, tc.wantErr)
+			}
+			if n != 0 || len(cache.games) != 0 {
+				t.Errorf("RestoreSnapshot() restored %d games, want none", len(cache.games))
+			}
+		})
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		cache := NewCacheGameRecorded(time.Minute, 0)
+		if n, err := cache.RestoreSnapshot(filepath.Join(dir, "missing")); n != 0 || err != nil {
+			t.Errorf("RestoreSnapshot() = %d, %v, want 0, nil", n, err)
+		}
+	})
+}
+
+func TestSaveSnapshotReplacesThePreviousOne(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "live-scores")
+	cache := NewCacheGameRecorded(time.Minute, 0)
+	for _, team := range []string{"Boston", "Knicks"} {
+		cache.updateCache(sp.Action{GamePoster: "Boston_Knicks", Team: team, Description: "2pts succes"})
+		if err := cache.SaveSnapshot(path); err != nil {
+			t.Fatalf("SaveSnapshot() error = %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "live-scores" {
+		t.Errorf("files after the snapshots = %v, want only live-scores", entries)
+	}
+	restored := NewCacheGameRecorded(time.Minute, 0)
+	if _, err := restored.RestoreSnapshot(path); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if got := restored.games["Boston_Knicks"]; got.ScoreA != 2 || got.ScoreB != 2 {
+		t.Errorf("restored score = %d-%d, want the last one 2-2", got.ScoreA, got.ScoreB)
+	}
+
+	if err := cache.SaveSnapshot(filepath.Join(dir, "missing", "live-scores")); err == nil {
+		t.Error("SaveSnapshot() in a missing directory should fail")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	database "sync_score/cmd/database/db"
+	"sync_score/config"
+	pb "sync_score/proto"
+	ut "sync_score/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Backs up and restores the database of cmd/database:
+//
+//	snapshot backup -database.addr localhost:50051
+//	snapshot restore -database.path ./games.db snapshots/games-20250115T200000.000Z.db
+//
+// backup asks the running server for a snapshot, written in its snapshot
+// directory. restore swaps a snapshot in place of the database, the server
+// must be stopped.
+
+const usage = "usage: snapshot backup|restore [flags]"
+
+func main() {
+	if len(os.Args) < 2 {
+		ut.Fatal(usage)
+	}
+	switch os.Args[1] {
+	case "backup":
+		backup(os.Args[2:])
+	case "restore":
+		restore(os.Args[2:])
+	default:
+		ut.Fatal(usage)
+	}
+}
+
+// parse parses the flags of a command, with those of the configuration.
+func parse(flags *flag.FlagSet, args []string) *config.Config {
+	cfg := config.Default()
+	cfg.RegisterFlags(flags)
+	flags.Parse(args)
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	ut.Debugf("Configuration:\n%s", cfg)
+	return cfg
+}
+
+func backup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	timeout := flags.Duration("timeout", time.Minute, "the maximum duration of the backup")
+	cfg := parse(flags, args)
+
+	conn, err := grpc.NewClient(cfg.Database.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		ut.Fatalf("Failed to connect to %s: %v", cfg.Database.Addr, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	reply, err := pb.NewGameCenterClient(conn).Backup(ctx, &pb.BackupRequest{})
+	if err != nil {
+		ut.Fatalf("Backup failed: %v", err)
+	}
+	fmt.Println(reply.Path)
+}
+
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	cfg := parse(flags, args)
+	if flags.NArg() != 1 {
+		ut.Fatal("usage: snapshot restore [-database.path path] snapshot")
+	}
+
+	if err := database.Restore(flags.Arg(0), cfg.Database.Path); err != nil {
+		ut.Fatalf("Restore failed: %v", err)
+	}
+	ut.Infof("%s restored from %s", cfg.Database.Path, flags.Arg(0))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 
@@ -13,7 +14,16 @@ import (
 
 
 func main() {
-	db, err := sql.Open("sqlite3", "./monolithe/games.db")
+	flag.Parse()
+	if err := cfg.Load(); err != nil {
+		ut.Fatalf("Invalid configuration: %v", err)
+	}
+	if *standings {
+		printStandings()
+		return
+	}
+
+	db, err := sql.Open("sqlite3", cfg.Database.Path)
 	if err != nil {
 		fmt.Println(err)
 		ut.Fatal(err)
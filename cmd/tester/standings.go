@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"sync_score/config"
+	pb "sync_score/proto"
+	sp "sync_score/sport"
+	ut "sync_score/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Prints the league table and the head to head record of two teams, as
+// served by the database server:
+//
+//	tester -standings -season 2024-25
+//	tester -standings -team Boston -opponent Knicks
+
+var (
+	standings   = flag.Bool("standings", false, "print the standings from the database server instead of the tables of the database")
+	season      = flag.String("season", "", "the season of the standings, as 2024-25, every game when empty")
+	tiebreakers = flag.String("tiebreakers", "", "the tiebreakers of the standings, those of the server when empty")
+	team        = flag.String("team", "", "with -opponent, print the head to head record of the team")
+	opponent    = flag.String("opponent", "", "the opponent of the head to head record")
+	cfg         = config.Default()
+)
+
+func init() {
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
+func streak(n int32) string {
+	return sp.Standing{Streak: n}.StreakString()
+}
+
+func printStandings() {
+	conn, err := grpc.NewClient(cfg.Database.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		ut.Fatalf("Failed to connect to %s: %v", cfg.Database.Addr, err)
+	}
+	defer conn.Close()
+	client := pb.NewGameCenterClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	if *team != "" || *opponent != "" {
+		res, err := client.GetHeadToHead(ctx, &pb.HeadToHeadRequest{Team: *team, Opponent: *opponent, Season: *season})
+		if err != nil {
+			ut.Fatalf("Failed to get the head to head record: %v", err)
+		}
+		fmt.Fprintf(w, "%s against %s: %d-%d, points %d-%d, streak %s\n\n", *team, res.Opponent,
+			res.Record.Wins, res.Record.Losses, res.Record.PointsFor, res.Record.PointsAgainst, streak(res.Record.Streak))
+		fmt.Fprintln(w, "date\tgame\tscore")
+		for _, game := range res.Games {
+			fmt.Fprintf(w, "%s\t%s\t%s %d - %d %s\n", time.Unix(game.StartedAt, 0).Format(time.DateOnly), game.GamePoster,
+				game.TeamA, game.ScoreA, game.ScoreB, game.TeamB)
+		}
+		return
+	}
+
+	req := &pb.StandingsRequest{Season: *season}
+	if *tiebreakers != "" {
+		req.Tiebreakers = strings.Split(*tiebreakers, ",")
+	}
+	res, err := client.GetStandings(ctx, req)
+	if err != nil {
+		ut.Fatalf("Failed to get the standings: %v", err)
+	}
+	fmt.Fprintln(w, "#\tteam\tW\tL\tpct\tPF\tPA\tdiff\tstreak")
+	for i, s := range res.Elements {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%.3f\t%d\t%d\t%+d\t%s\n", i+1, s.Team, s.Wins, s.Losses, s.WinPct,
+			s.PointsFor, s.PointsAgainst, s.PointsFor-s.PointsAgainst, streak(s.Streak))
+	}
+}
@@ -0,0 +1,418 @@
+// Package config holds the addresses and the paths shared by the services.
+// A value comes, by increasing priority, from the defaults, the configuration
+// file, the environment and the command line.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix prefixes the environment variables of the values, as
+	// SYNC_SCORE_RABBITMQ_PORT for rabbitmq.port.
+	EnvPrefix = "SYNC_SCORE_"
+	// EnvFile is the environment variable of the configuration file, used
+	// without the -config flag.
+	EnvFile = EnvPrefix + "CONFIG"
+)
+
+// Config is the configuration of the services. Each one uses its sections.
+type Config struct {
+	RabbitMQ RabbitMQ `yaml:"rabbitmq" toml:"rabbitmq"`
+	Database Database `yaml:"database" toml:"database"`
+	Server   Server   `yaml:"server" toml:"server"`
+	Webapp   Webapp   `yaml:"webapp" toml:"webapp"`
+	Client   Client   `yaml:"client" toml:"client"`
+	MQTT     MQTT     `yaml:"mqtt" toml:"mqtt"`
+	Routing  Routing  `yaml:"routing" toml:"routing"`
+	Redis    Redis    `yaml:"redis" toml:"redis"`
+
+	// file is the configuration file given on the command line.
+	file string
+	// flags are the values given on the command line, by key.
+	flags map[string]string
+}
+
+// RabbitMQ is the broker of the actions.
+type RabbitMQ struct {
+	Host     string `yaml:"host" toml:"host" usage:"the host of RabbitMQ"`
+	Port     int    `yaml:"port" toml:"port" usage:"the port of RabbitMQ"`
+	User     string `yaml:"user" toml:"user" usage:"the user of RabbitMQ"`
+	Password string `yaml:"password" toml:"password" secret:"true" usage:"the password of the RabbitMQ user"`
+	VHost    string `yaml:"vhost" toml:"vhost" usage:"the virtual host of RabbitMQ"`
+}
+
+// URL returns the AMQP URL of the broker.
+func (r RabbitMQ) URL() string {
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(r.User, r.Password),
+		Host:   net.JoinHostPort(r.Host, strconv.Itoa(r.Port)),
+		Path:   "/",
+	}
+	if r.VHost != "" && r.VHost != "/" {
+		u.Path += r.VHost
+		u.RawPath = "/" + url.PathEscape(r.VHost)
+	}
+	return u.String()
+}
+
+// Database is the gRPC database server and its SQLite file.
+type Database struct {
+	Listen          string        `yaml:"listen" toml:"listen" usage:"the address the database server listens on"`
+	Addr            string        `yaml:"addr" toml:"addr" usage:"the address the services dial to reach the database server"`
+	Path            string        `yaml:"path" toml:"path" usage:"the SQLite database of the games"`
+	BatchSize       int           `yaml:"batchSize" toml:"batchSize" usage:"the maximum number of actions written in one transaction, 0 writes every action on its own"`
+	BatchDelay      time.Duration `yaml:"batchDelay" toml:"batchDelay" usage:"the maximum time an action waits for its batch to be written"`
+	SnapshotDir     string        `yaml:"snapshotDir" toml:"snapshotDir" usage:"the directory of the snapshots of the database"`
+	SnapshotEvery   time.Duration `yaml:"snapshotEvery" toml:"snapshotEvery" usage:"the interval between two scheduled snapshots, 0 disables them"`
+	SnapshotKeep    int           `yaml:"snapshotKeep" toml:"snapshotKeep" usage:"the number of snapshots kept in the snapshot directory, 0 keeps them all"`
+	ArchiveDir      string        `yaml:"archiveDir" toml:"archiveDir" usage:"the directory of the archived games, see cmd/archive"`
+	Tiebreakers     string        `yaml:"tiebreakers" toml:"tiebreakers" usage:"the tiebreakers of the standings, in order"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout" usage:"the maximum wait for the calls in flight once the database server is asked to stop"`
+}
+
+// Server stores the actions of the queue of the stats writer through the
+// database server, and keeps the live scores.
+type Server struct {
+	Prefetch        int           `yaml:"prefetch" toml:"prefetch" usage:"the maximum number of actions delivered to the server and not acknowledged yet"`
+	Workers         int           `yaml:"workers" toml:"workers" usage:"the number of games stored in parallel, keep the prefetch above it to feed every worker"`
+	ShardQueue      int           `yaml:"shardQueue" toml:"shardQueue" usage:"the maximum number of actions waiting for a worker"`
+	RetryDelay      time.Duration `yaml:"retryDelay" toml:"retryDelay" usage:"the pause before an action which failed on a transient error is requeued"`
+	HoldTimeout     time.Duration `yaml:"holdTimeout" toml:"holdTimeout" usage:"the maximum wait of the actions of a game for its action requeued, which may go to another replica"`
+	RetryInitial    time.Duration `yaml:"retryInitial" toml:"retryInitial" usage:"the wait before the first retry of a call to the database, doubled at every retry"`
+	RetryMax        time.Duration `yaml:"retryMax" toml:"retryMax" usage:"the maximum wait between two retries of a call to the database"`
+	RetryMaxElapsed time.Duration `yaml:"retryMaxElapsed" toml:"retryMaxElapsed" usage:"the time after which a call to the database is not retried, and the action is requeued"`
+	BreakerFailures int           `yaml:"breakerFailures" toml:"breakerFailures" usage:"the number of transient errors in a row which open the circuit breaker of the database"`
+	BreakerCooldown time.Duration `yaml:"breakerCooldown" toml:"breakerCooldown" usage:"the pause of the consumption once the circuit breaker of the database opens, before a trial call"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout" usage:"the maximum wait for the actions in flight once the server is asked to stop"`
+	MetricsAddr     string        `yaml:"metricsAddr" toml:"metricsAddr" usage:"the address serving the counters of the server on /debug/vars, none when empty"`
+	CacheTTL        time.Duration `yaml:"cacheTTL" toml:"cacheTTL" usage:"the time a game not read nor updated stays in the cache of the live scores"`
+	CacheMaxGames   int           `yaml:"cacheMaxGames" toml:"cacheMaxGames" usage:"the maximum number of games in the cache of the live scores kept in memory, the least recently used is evicted beyond, no maximum when 0"`
+	SnapshotPath    string        `yaml:"snapshotPath" toml:"snapshotPath" usage:"the file the live scores kept in memory are saved to periodically and on shutdown, and restored from at startup, none when empty"`
+	SnapshotPeriod  time.Duration `yaml:"snapshotPeriod" toml:"snapshotPeriod" usage:"the time between two snapshots of the live scores"`
+}
+
+// Webapp is the web page of the live games.
+type Webapp struct {
+	Listen string `yaml:"listen" toml:"listen" usage:"the address the webapp listens on"`
+}
+
+// Client replays recorded games.
+type Client struct {
+	Games string `yaml:"games" toml:"games" usage:"the JSON list of the recorded games the client replays, relative to the list"`
+}
+
+// MQTT is the broker the scorer devices publish their actions on.
+type MQTT struct {
+	URL      string `yaml:"url" toml:"url" usage:"the URL of the MQTT broker of the scorer devices, as tcp://localhost:1883, none when empty"`
+	ClientID string `yaml:"clientID" toml:"clientID" usage:"the client ID of the MQTT session of the server"`
+	User     string `yaml:"user" toml:"user" usage:"the user of the MQTT broker"`
+	Password string `yaml:"password" toml:"password" secret:"true" usage:"the password of the MQTT user"`
+}
+
+// Redis keeps the live scores shared by the replicas of the server.
+type Redis struct {
+	URL      string `yaml:"url" toml:"url" usage:"the URL of the Redis server of the live scores, as redis://localhost:6379/0, each server keeps its own in memory when empty"`
+	Password string `yaml:"password" toml:"password" secret:"true" usage:"the password of the Redis server"`
+	Prefix   string `yaml:"prefix" toml:"prefix" usage:"the prefix of the keys of the live scores"`
+}
+
+// Routing is the topic exchange of the actions, routed with the keys
+// game.<gameID>.<actionType>, and the binding patterns of the queue of each
+// consumer group, separated by commas. A group without pattern has no queue.
+// The producers and the consumers declare the same routing.
+type Routing struct {
+	Exchange  string `yaml:"exchange" toml:"exchange" usage:"the topic exchange of the actions"`
+	Stats     string `yaml:"stats" toml:"stats" usage:"the binding patterns of the queue of the stats writer, the server storing the actions"`
+	LiveCache string `yaml:"liveCache" toml:"liveCache" usage:"the binding patterns of the queue of the live scores cache, fed by the stats writer when empty, the default: with a queue of its own, a game missing from the cache may count an action twice"`
+	Notifier  string `yaml:"notifier" toml:"notifier" usage:"the binding patterns of the queue of the notifier, none when empty"`
+	Webapp    string `yaml:"webapp" toml:"webapp" usage:"the binding patterns of the queue of the webapp, none when empty"`
+}
+
+// Groups returns the binding patterns of the consumer groups, by name. The
+// names are the keys of the section.
+func (r Routing) Groups() map[string][]string {
+	groups := make(map[string][]string)
+	for name, patterns := range map[string]string{"stats": r.Stats, "liveCache": r.LiveCache, "notifier": r.Notifier, "webapp": r.Webapp} {
+		for _, pattern := range strings.Split(patterns, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				groups[name] = append(groups[name], pattern)
+			}
+		}
+	}
+	return groups
+}
+
+// Default returns the configuration of the services running on one machine.
+func Default() *Config {
+	return &Config{
+		RabbitMQ: RabbitMQ{Host: "localhost", Port: 5672, User: "guest", Password: "guest", VHost: "/"},
+		Database: Database{
+			Listen:          ":50051",
+			Addr:            "localhost:50051",
+			Path:            "./games.db",
+			BatchDelay:      5 * time.Millisecond,
+			SnapshotDir:     "./snapshots",
+			SnapshotKeep:    24,
+			ArchiveDir:      "./archive",
+			Tiebreakers:     "headToHead,pointDifference,pointsFor",
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Server: Server{
+			Prefetch:        16,
+			Workers:         8,
+			ShardQueue:      4,
+			RetryDelay:      time.Second,
+			HoldTimeout:     30 * time.Second,
+			RetryInitial:    100 * time.Millisecond,
+			RetryMax:        2 * time.Second,
+			RetryMaxElapsed: 10 * time.Second,
+			BreakerFailures: 5,
+			BreakerCooldown: 5 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			CacheTTL:        5 * time.Second,
+			CacheMaxGames:   1000,
+			SnapshotPeriod:  30 * time.Second,
+		},
+		Webapp:  Webapp{Listen: ":8080"},
+		Client:  Client{Games: "client/gamesRecorded.json"},
+		MQTT:    MQTT{ClientID: "sync-score-server"},
+		Routing: Routing{Exchange: "actions", Stats: "game.#"},
+		Redis:   Redis{Prefix: "live"},
+	}
+}
+
+// field is a value of the configuration.
+type field struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// fields returns the values of the configuration, in the order of the file.
+func (c *Config) fields() []field {
+	var fields []field
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Tag.Get("yaml")
+			if !f.IsExported() || name == "" {
+				continue
+			}
+			key := prefix + name
+			if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			fields = append(fields, field{
+				key:    key,
+				env:    EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_")),
+				usage:  f.Tag.Get("usage"),
+				secret: f.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return fields
+}
+
+func (f field) set(s string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", f.key, s)
+		}
+		f.value.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", f.key, s)
+		}
+		f.value.SetInt(int64(d))
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", f.key, s)
+		}
+		f.value.SetBool(b)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.key, f.value.Type())
+	}
+	return nil
+}
+
+func (f field) String() string {
+	if f.secret && !f.value.IsZero() {
+		return "******"
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// flagValue records a value given on the command line, applied by Load over
+// the file and the environment.
+type flagValue struct {
+	c   *Config
+	key string
+	def string
+}
+
+func (v flagValue) String() string {
+	return v.def
+}
+
+func (v flagValue) Set(s string) error {
+	v.c.flags[v.key] = s
+	return nil
+}
+
+// RegisterFlags defines on fs the -config flag of the configuration file, and
+// a flag by value, as -rabbitmq.port.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	if c.flags == nil {
+		c.flags = make(map[string]string)
+	}
+	fs.StringVar(&c.file, "config", "", "the YAML or TOML configuration file, $"+EnvFile+" when empty")
+	for _, f := range c.fields() {
+		fs.Var(flagValue{c: c, key: f.key, def: f.String()}, f.key, fmt.Sprintf("%s, $%s", f.usage, f.env))
+	}
+}
+
+// Load reads the configuration file, then the environment and then the flags
+// given on the command line, and validates the result.
+func (c *Config) Load() error {
+	file := c.file
+	if file == "" {
+		file = os.Getenv(EnvFile)
+	}
+	if file != "" {
+		if err := c.LoadFile(file); err != nil {
+			return err
+		}
+	}
+	fields := c.fields()
+	for _, f := range fields {
+		if s, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(s); err != nil {
+				return fmt.Errorf("$%s: %w", f.env, err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if s, ok := c.flags[f.key]; ok {
+			if err := f.set(s); err != nil {
+				return fmt.Errorf("-%w", err)
+			}
+		}
+	}
+	return c.Validate()
+}
+
+// LoadFile reads a YAML file, .yaml or .yml, or a TOML file, .toml. The
+// values missing from the file are kept, an unknown key is an error.
+func (c *Config) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(content), c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("%s: unknown configuration format, want .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Validate checks the values, and returns every invalid one.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	validAddr := func(addr string) bool {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return false
+		}
+		n, err := strconv.Atoi(port)
+		return err == nil && n >= 0 && n <= 65535
+	}
+
+	check(c.RabbitMQ.Host != "", "rabbitmq.host is empty")
+	check(c.RabbitMQ.Port > 0 && c.RabbitMQ.Port <= 65535, "rabbitmq.port %d is not a port", c.RabbitMQ.Port)
+	check(c.RabbitMQ.User != "", "rabbitmq.user is empty")
+	check(validAddr(c.Database.Listen), "database.listen %q is not a host:port address", c.Database.Listen)
+	check(validAddr(c.Database.Addr), "database.addr %q is not a host:port address", c.Database.Addr)
+	check(c.Database.Path != "", "database.path is empty")
+	check(validAddr(c.Webapp.Listen), "webapp.listen %q is not a host:port address", c.Webapp.Listen)
+	check(c.Client.Games != "", "client.games is empty")
+	check(c.Server.CacheTTL > 0, "server.cacheTTL %v is not positive", c.Server.CacheTTL)
+	check(c.Server.SnapshotPeriod > 0, "server.snapshotPeriod %v is not positive", c.Server.SnapshotPeriod)
+	if c.MQTT.URL != "" {
+		u, err := url.Parse(c.MQTT.URL)
+		check(err == nil && u.Scheme != "" && u.Host != "", "mqtt.url %q is not a broker URL", c.MQTT.URL)
+		check(c.MQTT.ClientID != "", "mqtt.clientID is empty")
+	}
+	if c.Redis.URL != "" {
+		u, err := url.Parse(c.Redis.URL)
+		check(err == nil && (u.Scheme == "redis" || u.Scheme == "rediss") && u.Host != "", "redis.url %q is not a redis:// URL", c.Redis.URL)
+		check(c.Redis.Prefix != "", "redis.prefix is empty")
+	}
+	check(c.Routing.Exchange != "", "routing.exchange is empty")
+	groups := c.Routing.Groups()
+	check(len(groups["stats"]) > 0, "routing.stats is empty")
+	for _, name := range []string{"stats", "liveCache", "notifier", "webapp"} {
+		for _, pattern := range groups[name] {
+			check(!strings.Contains("."+pattern+".", ".."), "routing.%s pattern %q has an empty word", name, pattern)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String prints the configuration, one key = value by line, with the secrets
+// redacted.
+func (c *Config) String() string {
+	var b strings.Builder
+	for _, f := range c.fields() {
+		fmt.Fprintf(&b, "%s = %s\n", f.key, f)
+	}
+	return b.String()
+}
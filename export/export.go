@@ -0,0 +1,124 @@
+// Package export turns the games and statistics of a store into tables, and
+// writes them as CSV, JSON or XLSX. The columns have stable names and the rows
+// a stable order, so two exports of the same data are identical.
+package export
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	database "sync_score/cmd/database/db"
+	sp "sync_score/sport"
+)
+
+// Table is a report ready to be written in any format. The values of the rows
+// are strings or int32.
+type Table struct {
+	Name    string
+	Columns []string
+	Rows    [][]any
+}
+
+// Options selects what is exported. The minutes apply to the reports of a
+// game, the season and the dates to the statistics of the players.
+type Options struct {
+	GamePoster string
+	// FromMinute and ToMinute bound the actions of the game, both included.
+	// A negative ToMinute leaves the range open.
+	FromMinute, ToMinute int32
+	Season               string
+	// From and To bound the start of the games, To is excluded.
+	From, To time.Time
+}
+
+// AllMinutes are the options exporting every action of a game.
+func AllMinutes(gamePoster string) Options {
+	return Options{GamePoster: gamePoster, ToMinute: -1}
+}
+
+func (o Options) inMinutes(action sp.Action) bool {
+	return action.Minute >= o.FromMinute && (o.ToMinute < 0 || action.Minute <= o.ToMinute)
+}
+
+var statColumns = []string{"twoPointTry", "twoPointSuccess", "threePointTry", "threePointSuccess",
+	"freeThrowTry", "freeThrowSuccess", "foul", "points"}
+
+func statValues(s sp.PlayerStatistic) []any {
+	return []any{s.TwoPointTry, s.TwoPointSuccess, s.ThreePointTry, s.ThreePointSuccess,
+		s.FreeThrowTry, s.FreeThrowSuccess, s.Foul, s.Points()}
+}
+
+// PlayByPlay returns the actions of a game, in the order they happened.
+func PlayByPlay(store database.Store, opts Options) (Table, error) {
+	actions, err := gameActions(store, opts)
+	if err != nil {
+		return Table{}, err
+	}
+	table := Table{
+		Name:    "playByPlay",
+		Columns: []string{"game", "minute", "team", "player", "description"},
+	}
+	for _, a := range actions {
+		table.Rows = append(table.Rows, []any{a.GamePoster, a.Minute, a.Team, a.PlayerName, a.Description})
+	}
+	return table, nil
+}
+
+// BoxScore returns the line of every player of a game, by team and player.
+func BoxScore(store database.Store, opts Options) (Table, error) {
+	actions, err := gameActions(store, opts)
+	if err != nil {
+		return Table{}, err
+	}
+	table := Table{
+		Name:    "boxScore",
+		Columns: append([]string{"game", "team", "player"}, statColumns...),
+	}
+	for _, line := range sp.BoxScore(actions) {
+		table.Rows = append(table.Rows, append([]any{opts.GamePoster, line.Team, line.PlayerName}, statValues(line.PlayerStatistic)...))
+	}
+	return table, nil
+}
+
+// PlayerStatistics returns the statistics of the players, by player. Without
+// season nor dates, it is the playerStatistic table.
+func PlayerStatistics(store database.Store, opts Options) (Table, error) {
+	var stats []sp.PlayerStatistic
+	var err error
+	if opts.Season == "" && opts.From.IsZero() && opts.To.IsZero() {
+		stats, err = store.QueryPlayerStatistics()
+	} else {
+		stats, err = store.QueryPlayerSplits(database.StatisticFilter{Season: opts.Season, From: opts.From, To: opts.To})
+	}
+	if err != nil {
+		return Table{}, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].PlayerName < stats[j].PlayerName })
+
+	table := Table{
+		Name:    "playerStatistic",
+		Columns: append([]string{"player"}, statColumns...),
+	}
+	for _, s := range stats {
+		table.Rows = append(table.Rows, append([]any{s.PlayerName}, statValues(s)...))
+	}
+	return table, nil
+}
+
+func gameActions(store database.Store, opts Options) (sp.Actions, error) {
+	if opts.GamePoster == "" {
+		return nil, fmt.Errorf("no game to export")
+	}
+	actions, err := store.QueryGameHistoric(opts.GamePoster)
+	if err != nil {
+		return nil, err
+	}
+	var selected sp.Actions
+	for _, a := range actions {
+		if opts.inMinutes(a) {
+			selected = append(selected, a)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,131 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	database "sync_score/cmd/database/db"
+	sp "sync_score/sport"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func newTestStore(t *testing.T) database.Store {
+	store := database.NewMemoryStore()
+	actions := sp.Actions{
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Donte divicenzo", Description: "2pts succes", Minute: 0},
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "3pts succes", Minute: 1},
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "free throw try", Minute: 3},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Donte divicenzo", Description: "foul", Minute: 5},
+	}
+	for _, a := range actions {
+		if err := store.SendToTables(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestWriteCSV(t *testing.T) {
+	tests := map[string]struct {
+		report func(database.Store, Options) (Table, error)
+		opts   Options
+		want   string
+	}{
+		"Play by play": {
+			report: PlayByPlay,
+			opts:   AllMinutes("Boston_Knicks"),
+			want: "game,minute,team,player,description\n" +
+				"Boston_Knicks,0,Knicks,Donte divicenzo,2pts succes\n" +
+				"Boston_Knicks,1,Boston,JD Davison,3pts succes\n" +
+				"Boston_Knicks,3,Boston,JD Davison,free throw try\n" +
+				"Boston_Knicks,5,Knicks,Donte divicenzo,foul\n",
+		},
+		"Play by play in a time range": {
+			report: PlayByPlay,
+			opts:   Options{GamePoster: "Boston_Knicks", FromMinute: 1, ToMinute: 3},
+			want: "game,minute,team,player,description\n" +
+				"Boston_Knicks,1,Boston,JD Davison,3pts succes\n" +
+				"Boston_Knicks,3,Boston,JD Davison,free throw try\n",
+		},
+		"Box score": {
+			report: BoxScore,
+			opts:   AllMinutes("Boston_Knicks"),
+			want: "game,team,player,twoPointTry,twoPointSuccess,threePointTry,threePointSuccess,freeThrowTry,freeThrowSuccess,foul,points\n" +
+				"Boston_Knicks,Boston,JD Davison,0,0,1,1,1,0,0,3\n" +
+				"Boston_Knicks,Knicks,Donte divicenzo,1,1,0,0,0,0,1,2\n",
+		},
+		"Player statistics": {
+			report: PlayerStatistics,
+			opts:   Options{},
+			want: "player,twoPointTry,twoPointSuccess,threePointTry,threePointSuccess,freeThrowTry,freeThrowSuccess,foul,points\n" +
+				"Donte divicenzo,1,1,0,0,0,0,1,2\n" +
+				"JD Davison,0,0,1,1,1,0,0,3\n",
+		},
+	}
+
+	store := newTestStore(t)
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			table, err := tc.report(store, tc.opts)
+			if err != nil {
+				t.Fatalf("report error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := WriteCSV(&buf, table); err != nil {
+				t.Fatalf("WriteCSV() error = %v", err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("WriteCSV() =\n%s\nwant\n%s", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	table, err := PlayByPlay(newTestStore(t), Options{GamePoster: "Boston_Knicks", ToMinute: 0})
+	if err != nil {
+		t.Fatalf("PlayByPlay() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, table); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	want := `[
+  {"game": "Boston_Knicks", "minute": 0, "team": "Knicks", "player": "Donte divicenzo", "description": "2pts succes"}
+]
+`
+	if buf.String() != want {
+		t.Errorf("WriteJSON() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteXLSXIsDeterministic(t *testing.T) {
+	table, err := BoxScore(newTestStore(t), AllMinutes("Boston_Knicks"))
+	if err != nil {
+		t.Fatalf("BoxScore() error = %v", err)
+	}
+	var first, second bytes.Buffer
+	if err := WriteXLSX(&first, table); err != nil {
+		t.Fatalf("WriteXLSX() error = %v", err)
+	}
+	if err := WriteXLSX(&second, table); err != nil {
+		t.Fatalf("WriteXLSX() error = %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("two exports of the same table differ")
+	}
+
+	f, err := excelize.OpenReader(&first)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("boxScore")
+	if err != nil {
+		t.Fatalf("GetRows() error = %v", err)
+	}
+	if len(rows) != 3 || rows[0][2] != "player" || rows[1][2] != "JD Davison" || rows[1][10] != "3" {
+		t.Errorf("GetRows() = %v", rows)
+	}
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Format is a file format of the exports.
+type Format string
+
+const (
+	CSV  Format = "csv"
+	JSON Format = "json"
+	XLSX Format = "xlsx"
+)
+
+// Write writes the table in the given format.
+func Write(w io.Writer, table Table, format Format) error {
+	switch format {
+	case CSV:
+		return WriteCSV(w, table)
+	case JSON:
+		return WriteJSON(w, table)
+	case XLSX:
+		return WriteXLSX(w, table)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// WriteCSV writes the columns as header, then a line per row.
+func WriteCSV(w io.Writer, table Table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(table.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(table.Columns))
+	for _, row := range table.Rows {
+		for i, v := range row {
+			record[i] = fmt.Sprint(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes an array with an object per row. The keys of the objects
+// keep the order of the columns.
+func WriteJSON(w io.Writer, table Table) error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, row := range table.Rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for j, v := range row {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			key, _ := json.Marshal(table.Columns[j])
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(": ")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+	}
+	if len(table.Rows) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// WriteXLSX writes a workbook with a single sheet named after the table.
+func WriteXLSX(w io.Writer, table Table) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := table.Name
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return err
+	}
+	for i, column := range table.Columns {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		if err := f.SetCellValue(sheet, cell, column); err != nil {
+			return err
+		}
+	}
+	for r, row := range table.Rows {
+		cell, _ := excelize.CoordinatesToCellName(1, r+2)
+		values := row
+		if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+			return err
+		}
+	}
+	_, err := f.WriteTo(w)
+	return err
+}
@@ -0,0 +1,202 @@
+package mqtt
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is an in-process MQTT 3.1.1 broker, enough for QoS 1 with the
+// persistent sessions: the messages not acknowledged by a subscriber are
+// delivered again, in order, when it connects again.
+type testBroker struct {
+	listener net.Listener
+	mu       sync.Mutex
+	sessions map[string]*testSession
+	wg       sync.WaitGroup
+}
+
+type testSession struct {
+	conn    net.Conn
+	filters []string
+	// inflight are the messages sent and not acknowledged, in order.
+	inflight []*packets.PublishPacket
+	nextID   uint16
+	writeMu  sync.Mutex
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{listener: listener, sessions: make(map[string]*testSession)}
+	b.wg.Add(1)
+	go b.accept()
+	t.Cleanup(b.close)
+	return b
+}
+
+// URL returns the URL the clients connect to.
+func (b *testBroker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Inflight returns the number of messages the client did not acknowledge.
+func (b *testBroker) Inflight(clientID string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s, ok := b.sessions[clientID]; ok {
+		return len(s.inflight)
+	}
+	return 0
+}
+
+func (b *testBroker) close() {
+	b.listener.Close()
+	b.mu.Lock()
+	for _, s := range b.sessions {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *testBroker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer conn.Close()
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	s, present := b.sessions[connect.ClientIdentifier]
+	if !present || connect.CleanSession {
+		s = &testSession{}
+		b.sessions[connect.ClientIdentifier] = s
+	}
+	s.conn = conn
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.SessionPresent = present && !connect.CleanSession
+	s.write(conn, connack)
+	for _, p := range s.inflight {
+		p.Dup = true
+		s.write(conn, p)
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		if s.conn == conn {
+			s.conn = nil
+		}
+		b.mu.Unlock()
+	}()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.(type) {
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			b.mu.Lock()
+			for i, filter := range p.Topics {
+				s.filters = append(s.filters, filter)
+				suback.ReturnCodes = append(suback.ReturnCodes, min(p.Qoss[i], 1))
+			}
+			b.mu.Unlock()
+			s.write(conn, suback)
+		case *packets.PublishPacket:
+			b.publish(p)
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				s.write(conn, puback)
+			}
+		case *packets.PubackPacket:
+			b.mu.Lock()
+			for i, inflight := range s.inflight {
+				if inflight.MessageID == p.MessageID {
+					s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+					break
+				}
+			}
+			b.mu.Unlock()
+		case *packets.PingreqPacket:
+			s.write(conn, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// publish sends the message with QoS 1 to the sessions subscribed to its
+// topic, connected or not.
+func (b *testBroker) publish(p *packets.PublishPacket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.sessions {
+		for _, filter := range s.filters {
+			if !match(filter, p.TopicName) {
+				continue
+			}
+			s.nextID++
+			msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			msg.Qos = 1
+			msg.TopicName = p.TopicName
+			msg.MessageID = s.nextID
+			msg.Payload = p.Payload
+			s.inflight = append(s.inflight, msg)
+			if s.conn != nil {
+				s.write(s.conn, msg)
+			}
+			break
+		}
+	}
+}
+
+func (s *testSession) write(conn net.Conn, p packets.ControlPacket) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	p.Write(conn)
+}
+
+// match reports whether the topic matches the filter, with the single level
+// wildcard + only.
+func match(filter, topic string) bool {
+	filters, levels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	if len(filters) != len(levels) {
+		return false
+	}
+	for i, f := range filters {
+		if f != "+" && f != levels[i] {
+			return false
+		}
+	}
+	return true
+}
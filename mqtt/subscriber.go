@@ -0,0 +1,172 @@
+// Package mqtt receives the actions the scorer devices, the courtside tablets
+// and the buttons, publish over MQTT on games/{gameID}/actions.
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ActionsTopic is the topic filter of the actions of every game.
+const ActionsTopic = "games/+/actions"
+
+// GameID returns the game of a topic games/{gameID}/actions.
+func GameID(topic string) (string, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 3 || parts[0] != "games" || parts[1] == "" || parts[2] != "actions" {
+		return "", fmt.Errorf("topic %q is not games/{gameID}/actions", topic)
+	}
+	return parts[1], nil
+}
+
+// Handler handles an action published for a game. The message is
+// acknowledged once the handler returns nil. When it returns an error the
+// message is not, and the MQTT broker delivers it again, with the messages
+// which followed it, once the subscriber connected again.
+type Handler func(gameID string, payload []byte) error
+
+// Options configure the connection to the MQTT broker.
+type Options struct {
+	// Broker is the URL of the MQTT broker, as tcp://localhost:1883.
+	Broker string
+	// ClientID names the session of the subscriber. The broker keeps the
+	// session while the subscriber is away, with the actions published
+	// meanwhile.
+	ClientID string
+	Username string
+	Password string
+	// ConnectTimeout bounds the connection and the subscription.
+	ConnectTimeout time.Duration
+	// ReconnectDelay is the pause before connecting again, it doubles after
+	// every failure up to ReconnectMaxDelay.
+	ReconnectDelay    time.Duration
+	ReconnectMaxDelay time.Duration
+}
+
+// DefaultOptions connect to a broker on the local machine.
+var DefaultOptions = Options{
+	Broker:            "tcp://localhost:1883",
+	ClientID:          "sync-score-server",
+	ConnectTimeout:    10 * time.Second,
+	ReconnectDelay:    time.Second,
+	ReconnectMaxDelay: 30 * time.Second,
+}
+
+// Subscriber subscribes to the actions of every game with QoS 1: an action
+// is delivered at least once, and in the order of its device.
+type Subscriber struct {
+	opts   Options
+	handle Handler
+	logf   func(format string, args ...any)
+}
+
+// NewSubscriber returns the subscriber passing the actions to handle. logf
+// reports the connections lost.
+func NewSubscriber(opts Options, handle Handler, logf func(format string, args ...any)) *Subscriber {
+	return &Subscriber{opts: opts, handle: handle, logf: logf}
+}
+
+// Run receives the actions until the context is done, and connects again
+// with backoff when the connection is lost.
+func (s *Subscriber) Run(ctx context.Context) error {
+	delay := s.opts.ReconnectDelay
+	for {
+		connected, err := s.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if connected {
+			delay = s.opts.ReconnectDelay
+		}
+		s.logf("MQTT connection to %s lost, connecting again in %v: %v", s.opts.Broker, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		delay = min(delay*2, s.opts.ReconnectMaxDelay)
+	}
+}
+
+// session connects, subscribes and handles the actions until the context
+// is done, the connection is lost or an action is not handled. It reports
+// whether it connected.
+func (s *Subscriber) session(ctx context.Context) (bool, error) {
+	lost := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case lost <- err:
+		default:
+		}
+	}
+	// Once an action is not handled, the next ones are not either: the
+	// broker delivers them all again in order on the next session.
+	var failed atomic.Bool
+	receive := func(_ paho.Client, msg paho.Message) {
+		if failed.Load() {
+			return
+		}
+		gameID, err := GameID(msg.Topic())
+		if err != nil {
+			// The topic filter only matches the topics of the actions.
+			s.logf("Dropped the MQTT message of %s: %v", msg.Topic(), err)
+			msg.Ack()
+			return
+		}
+		if err := s.handle(gameID, msg.Payload()); err != nil {
+			failed.Store(true)
+			report(fmt.Errorf("action of %s not handled: %w", gameID, err))
+			return
+		}
+		msg.Ack()
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(s.opts.Broker).
+		SetClientID(s.opts.ClientID).
+		SetUsername(s.opts.Username).
+		SetPassword(s.opts.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(false).
+		SetAutoAckDisabled(true).
+		SetOrderMatters(true).
+		SetConnectTimeout(s.opts.ConnectTimeout).
+		// The broker sends the actions kept in the session before the
+		// subscription is renewed: the default handler receives them.
+		SetDefaultPublishHandler(receive).
+		SetConnectionLostHandler(func(_ paho.Client, err error) { report(err) })
+	client := paho.NewClient(opts)
+	if err := wait(ctx, client.Connect(), s.opts.ConnectTimeout); err != nil {
+		return false, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Disconnect(250)
+	if err := wait(ctx, client.Subscribe(ActionsTopic, 1, nil), s.opts.ConnectTimeout); err != nil {
+		return true, fmt.Errorf("failed to subscribe to %s: %w", ActionsTopic, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return true, nil
+	case err := <-lost:
+		return true, err
+	}
+}
+
+// wait waits for the token until the timeout or the context is done.
+func wait(ctx context.Context, token paho.Token, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-timer.C:
+		return fmt.Errorf("no answer after %v", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
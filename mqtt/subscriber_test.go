@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestGameID(t *testing.T) {
+	tests := map[string]struct {
+		topic   string
+		want    string
+		wantErr bool
+	}{
+		"Actions":       {"games/Boston_Knicks/actions", "Boston_Knicks", false},
+		"Other topic":   {"games/Boston_Knicks/scores", "", true},
+		"Without game":  {"games//actions", "", true},
+		"Too many":      {"games/Boston/Knicks/actions", "", true},
+		"Other prefix":  {"matches/Boston_Knicks/actions", "", true},
+		"Without level": {"games", "", true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := GameID(tc.topic)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("GameID() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("GameID() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// device publishes the payloads with QoS 1 on the actions of the game, as a
+// scorer device.
+func device(t *testing.T, b *testBroker, gameID string, payloads ...string) {
+	t.Helper()
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(b.URL()).SetClientID("device-" + gameID))
+	if err := wait(context.Background(), client.Connect(), time.Second); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Disconnect(0)
+	for _, payload := range payloads {
+		if err := wait(context.Background(), client.Publish("games/"+gameID+"/actions", 1, false, payload), time.Second); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+}
+
+// recorder records the actions handled, and fails the ones given to fail
+// once.
+type recorder struct {
+	mu       sync.Mutex
+	fail     map[string]bool
+	attempts map[string]int
+	handled  []string
+}
+
+func (r *recorder) handle(gameID string, payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	action := gameID + ":" + string(payload)
+	r.attempts[action]++
+	if r.fail[action] {
+		delete(r.fail, action)
+		return errors.New("pipeline unavailable")
+	}
+	r.handled = append(r.handled, action)
+	return nil
+}
+
+func (r *recorder) Handled() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.handled...)
+}
+
+// runSubscriber runs a subscriber of the broker until the test ends, and
+// waits for its subscription.
+func runSubscriber(t *testing.T, b *testBroker, handle Handler) Options {
+	t.Helper()
+	opts := DefaultOptions
+	opts.Broker = b.URL()
+	opts.ConnectTimeout = time.Second
+	opts.ReconnectDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- NewSubscriber(opts, handle, t.Logf).Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	})
+
+	// The broker keeps the actions of the session from its subscription on.
+	eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		s, ok := b.sessions[opts.ClientID]
+		return ok && len(s.filters) > 0
+	})
+	return opts
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met after 2s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubscriberHandlesTheActions(t *testing.T) {
+	b := newTestBroker(t)
+	r := &recorder{attempts: make(map[string]int)}
+	opts := runSubscriber(t, b, r.handle)
+
+	device(t, b, "Boston_Knicks", "1", "2", "3")
+	device(t, b, "Lakers_Bulls", "1")
+
+	want := []string{"Boston_Knicks:1", "Boston_Knicks:2", "Boston_Knicks:3", "Lakers_Bulls:1"}
+	eventually(t, func() bool { return len(r.Handled()) == len(want) })
+	if got := r.Handled(); !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+	eventually(t, func() bool { return b.Inflight(opts.ClientID) == 0 })
+}
+
+func TestSubscriberRedeliversTheActionsNotHandled(t *testing.T) {
+	b := newTestBroker(t)
+	r := &recorder{attempts: make(map[string]int), fail: map[string]bool{"Boston_Knicks:2": true}}
+	opts := runSubscriber(t, b, r.handle)
+
+	device(t, b, "Boston_Knicks", "1", "2", "3")
+
+	// The action which failed and the ones after it come back in order
+	// with the next session.
+	want := []string{"Boston_Knicks:1", "Boston_Knicks:2", "Boston_Knicks:3"}
+	eventually(t, func() bool { return len(r.Handled()) == len(want) })
+	if got := r.Handled(); !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+	r.mu.Lock()
+	if got := r.attempts["Boston_Knicks:2"]; got != 2 {
+		t.Errorf("Boston_Knicks:2 attempted %d times, want 2", got)
+	}
+	if got := r.attempts["Boston_Knicks:1"]; got != 1 {
+		t.Errorf("Boston_Knicks:1 attempted %d times, want 1", got)
+	}
+	r.mu.Unlock()
+	eventually(t, func() bool { return b.Inflight(opts.ClientID) == 0 })
+}
@@ -0,0 +1,475 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrManagerClosed is returned by the calls on a closed Manager.
+var ErrManagerClosed = errors.New("connection manager closed")
+
+// Connection is the part of *amqp.Connection used by a Manager.
+type Connection interface {
+	Channel() (AMQPChannel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// AMQPChannel is the part of *amqp.Channel used by the producers and the
+// consumers of the queues.
+type AMQPChannel interface {
+	Channel
+	PublishChannel
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// Dial returns the dialer of the RabbitMQ server at url.
+func Dial(url string) func() (Connection, error) {
+	return func() (Connection, error) {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+		return amqpConnection{conn}, nil
+	}
+}
+
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c amqpConnection) Channel() (AMQPChannel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Manager is an AMQP channel which survives the restarts of the broker. It
+// watches the connection and the channel, dials again with backoff when one
+// of them closes, and replays on the new channel the declarations, the
+// prefetch, the confirm mode and the consumers registered so far.
+//
+// The deliveries of a consumer come on one Go channel for the life of the
+// Manager. The deliveries of a lost channel are redelivered by the broker
+// and reported by Stale, and a publish waiting for its confirm gets a nack,
+// so that a Publisher publishes it again. The delivery tags of the confirms
+// count the publishes across the channels, as on a single channel.
+type Manager struct {
+	dial func() (Connection, error)
+	opts Options
+	logf func(format string, args ...any)
+
+	mu   sync.Mutex
+	conn Connection
+	ch   AMQPChannel
+	// generation counts the channels opened, ready is closed once the
+	// channel of the generation is open.
+	generation uint64
+	ready      chan struct{}
+	closed     bool
+	done       chan struct{}
+
+	// setup replays the declarations on a new channel.
+	setup     []func(ch AMQPChannel) error
+	consumers []*consumer
+	// forwarding counts the goroutines forwarding deliveries to the
+	// consumers, which must end before the deliveries are closed.
+	forwarding sync.WaitGroup
+	confirms   []chan amqp.Confirmation
+	// pending counts the publishes waiting for their confirm, the last
+	// ones of published. published counts the publishes on every channel,
+	// base those before the current channel: a confirm of the channel is
+	// forwarded with its delivery tag after base.
+	pending   int
+	published uint64
+	base      uint64
+}
+
+type consumer struct {
+	queue, name                         string
+	autoAck, exclusive, noLocal, noWait bool
+	args                                amqp.Table
+	deliveries                          chan amqp.Delivery
+}
+
+// NewManager returns a manager connecting with dial. It connects in the
+// background, the calls wait for the connection. logf reports the losses of
+// the connection.
+func NewManager(dial func() (Connection, error), opts Options, logf func(format string, args ...any)) *Manager {
+	m := &Manager{
+		dial:  dial,
+		opts:  opts,
+		logf:  logf,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go m.supervise()
+	return m
+}
+
+// supervise connects, and connects again whenever the connection is lost,
+// until the manager is closed.
+func (m *Manager) supervise() {
+	delay := m.opts.ReconnectDelay
+	for {
+		lost, err := m.connect()
+		if errors.Is(err, ErrManagerClosed) {
+			return
+		}
+		if err != nil {
+			m.logf("Failed to connect to RabbitMQ, next try in %v: %v", delay, err)
+			select {
+			case <-time.After(delay):
+			case <-m.done:
+				return
+			}
+			delay = min(2*delay, m.opts.ReconnectMaxDelay)
+			continue
+		}
+		delay = m.opts.ReconnectDelay
+
+		select {
+		case err := <-lost:
+			select {
+			case <-m.done:
+				return
+			default:
+			}
+			m.logf("Lost the connection to RabbitMQ, reconnecting: %v", err)
+			m.disconnect()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// connect opens a connection and a channel, replays the setup on it and
+// returns the channel reporting the loss of either.
+func (m *Manager) connect() (<-chan *amqp.Error, error) {
+	conn, err := m.dial()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	lost := make(chan *amqp.Error, 2)
+	forward := func(c chan *amqp.Error) {
+		if err, ok := <-c; ok {
+			lost <- err
+		} else {
+			lost <- amqp.ErrClosed
+		}
+	}
+	go forward(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	go forward(ch.NotifyClose(make(chan *amqp.Error, 1)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		ch.Close()
+		conn.Close()
+		return nil, ErrManagerClosed
+	}
+	for _, setup := range m.setup {
+		if err := setup(ch); err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, err
+		}
+	}
+	m.generation++
+	m.base = m.published
+	for _, confirm := range m.confirms {
+		go m.forwardConfirms(ch.NotifyPublish(make(chan amqp.Confirmation, 1)), confirm, m.base)
+	}
+	for _, c := range m.consumers {
+		deliveries, err := ch.Consume(c.queue, c.name, c.autoAck, c.exclusive, c.noLocal, c.noWait, c.args)
+		if err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, err
+		}
+		m.forwarding.Add(1)
+		go m.forwardDeliveries(deliveries, c.deliveries, m.generation)
+	}
+	m.conn, m.ch = conn, ch
+	close(m.ready)
+	return lost, nil
+}
+
+// disconnect drops the lost connection. The publishes waiting for their
+// confirm are nacked: their confirm is lost with the channel.
+func (m *Manager) disconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ch.Close()
+	m.conn.Close()
+	m.ch, m.conn = nil, nil
+	m.ready = make(chan struct{})
+	for ; m.pending > 0; m.pending-- {
+		nack := amqp.Confirmation{DeliveryTag: m.published - uint64(m.pending) + 1, Ack: false}
+		for _, confirm := range m.confirms {
+			select {
+			case confirm <- nack:
+			default:
+			}
+		}
+	}
+}
+
+// forwardConfirms forwards the confirms of a channel, whose publishes come
+// after base.
+func (m *Manager) forwardConfirms(from <-chan amqp.Confirmation, to chan amqp.Confirmation, base uint64) {
+	for confirm := range from {
+		m.mu.Lock()
+		if m.pending > 0 {
+			m.pending--
+		}
+		m.mu.Unlock()
+		confirm.DeliveryTag += base
+		select {
+		case to <- confirm:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *Manager) forwardDeliveries(from <-chan amqp.Delivery, to chan amqp.Delivery, generation uint64) {
+	defer m.forwarding.Done()
+	for msg := range from {
+		msg.Acknowledger = acknowledger{Acknowledger: msg.Acknowledger, generation: generation}
+		select {
+		case to <- msg:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// acknowledger tags the deliveries with the channel which delivered them.
+type acknowledger struct {
+	amqp.Acknowledger
+	generation uint64
+}
+
+// Stale reports whether the message was delivered on a lost channel. It
+// cannot be acknowledged anymore, and the broker delivers it again.
+func (m *Manager) Stale(msg amqp.Delivery) bool {
+	a, ok := msg.Acknowledger.(acknowledger)
+	if !ok {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ch == nil || a.generation != m.generation
+}
+
+// channel waits for the connection and returns its channel.
+func (m *Manager) channel() (AMQPChannel, error) {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrManagerClosed
+		}
+		ch, ready := m.ch, m.ready
+		m.mu.Unlock()
+		if ch != nil {
+			return ch, nil
+		}
+		select {
+		case <-ready:
+		case <-m.done:
+		}
+	}
+}
+
+// do runs the call on the channel, and records it to replay it on the next
+// channels once it succeeded.
+func (m *Manager) do(call func(ch AMQPChannel) error) error {
+	for {
+		ch, err := m.channel()
+		if err != nil {
+			return err
+		}
+		if err := call(ch); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		// A channel opened during the call missed it, it runs again there.
+		if m.ch == ch {
+			m.setup = append(m.setup, call)
+			m.mu.Unlock()
+			return nil
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *Manager) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return m.do(func(ch AMQPChannel) error {
+		return ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args)
+	})
+}
+
+func (m *Manager) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	var q amqp.Queue
+	err := m.do(func(ch AMQPChannel) error {
+		var err error
+		q, err = ch.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+		return err
+	})
+	return q, err
+}
+
+func (m *Manager) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return m.do(func(ch AMQPChannel) error {
+		return ch.QueueBind(name, key, exchange, noWait, args)
+	})
+}
+
+func (m *Manager) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return m.do(func(ch AMQPChannel) error {
+		return ch.Qos(prefetchCount, prefetchSize, global)
+	})
+}
+
+func (m *Manager) Confirm(noWait bool) error {
+	return m.do(func(ch AMQPChannel) error {
+		return ch.Confirm(noWait)
+	})
+}
+
+// NotifyPublish registers confirm for the confirms of the publishes, on the
+// current channel and on the next ones.
+func (m *Manager) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		close(confirm)
+		return confirm
+	}
+	m.confirms = append(m.confirms, confirm)
+	if m.ch != nil {
+		go m.forwardConfirms(m.ch.NotifyPublish(make(chan amqp.Confirmation, 1)), confirm, m.base)
+	}
+	return confirm
+}
+
+// Publish publishes on the channel, and on the next one when the channel is
+// lost during the publish.
+func (m *Manager) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	for {
+		ch, err := m.channel()
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		if len(m.confirms) > 0 && m.ch == ch {
+			m.pending++
+			m.published++
+		}
+		m.mu.Unlock()
+		err = ch.Publish(exchange, key, mandatory, immediate, msg)
+		if err == nil {
+			return nil
+		}
+		m.mu.Lock()
+		if len(m.confirms) > 0 && m.ch == ch && m.pending > 0 {
+			m.pending--
+			m.published--
+		}
+		m.mu.Unlock()
+		if !errors.Is(err, amqp.ErrClosed) {
+			return err
+		}
+		m.waitLoss(ch)
+	}
+}
+
+// waitLoss waits for the supervisor to drop the lost channel.
+func (m *Manager) waitLoss(ch AMQPChannel) {
+	for {
+		m.mu.Lock()
+		current, closed := m.ch, m.closed
+		m.mu.Unlock()
+		if current != ch || closed {
+			return
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Consume registers a consumer, registered again on the next channels. The
+// deliveries are closed when the manager is closed. Without a connection,
+// the consumer is registered once connected.
+func (m *Manager) Consume(queue, name string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c := &consumer{queue: queue, name: name, autoAck: autoAck, exclusive: exclusive, noLocal: noLocal, noWait: noWait, args: args, deliveries: make(chan amqp.Delivery)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	if m.ch != nil {
+		deliveries, err := m.ch.Consume(queue, name, autoAck, exclusive, noLocal, noWait, args)
+		if err != nil {
+			return nil, err
+		}
+		m.forwarding.Add(1)
+		go m.forwardDeliveries(deliveries, c.deliveries, m.generation)
+	}
+	m.consumers = append(m.consumers, c)
+	return c.deliveries, nil
+}
+
+// NotifyClose closes the receiver once the manager is closed, the losses of
+// the connection are handled by the manager.
+func (m *Manager) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	go func() {
+		<-m.done
+		close(receiver)
+	}()
+	return receiver
+}
+
+// Close closes the connection and stops the supervision. The deliveries of
+// the consumers are closed.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	ch, conn := m.ch, m.conn
+	consumers := m.consumers
+	m.mu.Unlock()
+
+	var err error
+	if ch != nil {
+		ch.Close()
+		err = conn.Close()
+	}
+	m.forwarding.Wait()
+	for _, c := range consumers {
+		close(c.deliveries)
+	}
+	return err
+}
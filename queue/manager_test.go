@@ -0,0 +1,337 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fakeServer dials fake connections of one channel, which drop on demand.
+// The first failDials dials fail.
+type fakeServer struct {
+	mu        sync.Mutex
+	failDials int
+	dials     int
+	opened    chan *fakeConnection
+}
+
+func newFakeServer(failDials int) *fakeServer {
+	return &fakeServer{failDials: failDials, opened: make(chan *fakeConnection, 10)}
+}
+
+func (s *fakeServer) dial() (Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dials++
+	if s.dials <= s.failDials {
+		return nil, errors.New("connection refused")
+	}
+	conn := &fakeConnection{ch: &fakeAMQPChannel{}}
+	s.opened <- conn
+	return conn, nil
+}
+
+// next returns the next connection opened by the manager.
+func (s *fakeServer) next(t *testing.T) *fakeConnection {
+	t.Helper()
+	select {
+	case conn := <-s.opened:
+		return conn
+	case <-time.After(time.Second):
+		t.Fatal("the manager did not connect")
+		return nil
+	}
+}
+
+type fakeConnection struct {
+	mu        sync.Mutex
+	ch        *fakeAMQPChannel
+	receivers []chan *amqp.Error
+}
+
+func (c *fakeConnection) Channel() (AMQPChannel, error) {
+	return c.ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.receivers = append(c.receivers, receiver)
+	return receiver
+}
+
+func (c *fakeConnection) Close() error {
+	c.ch.Close()
+	return nil
+}
+
+// drop closes the connection as a broker which restarts.
+func (c *fakeConnection) drop() {
+	c.mu.Lock()
+	for _, receiver := range c.receivers {
+		receiver <- &amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED - broker shutdown"}
+		close(receiver)
+	}
+	c.receivers = nil
+	c.mu.Unlock()
+	c.ch.Close()
+}
+
+// fakeAMQPChannel records the calls, and confirms every publish unless
+// holdConfirms is set.
+type fakeAMQPChannel struct {
+	mu           sync.Mutex
+	calls        []string
+	deliveries   []chan amqp.Delivery
+	confirms     []chan amqp.Confirmation
+	receivers    []chan *amqp.Error
+	published    []amqp.Publishing
+	acked        []uint64
+	holdConfirms bool
+	closed       bool
+}
+
+func (c *fakeAMQPChannel) record(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+func (c *fakeAMQPChannel) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func (c *fakeAMQPChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.record("ExchangeDeclare " + name + " " + kind)
+	return nil
+}
+
+func (c *fakeAMQPChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.record("QueueDeclare " + name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeAMQPChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.record("QueueBind " + name + " " + key + " " + exchange)
+	return nil
+}
+
+func (c *fakeAMQPChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.record(fmt.Sprintf("Qos %d", prefetchCount))
+	return nil
+}
+
+func (c *fakeAMQPChannel) Confirm(noWait bool) error {
+	c.record("Confirm")
+	return nil
+}
+
+func (c *fakeAMQPChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirms = append(c.confirms, confirm)
+	return confirm
+}
+
+func (c *fakeAMQPChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.receivers = append(c.receivers, receiver)
+	return receiver
+}
+
+func (c *fakeAMQPChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.record("Consume " + queue)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deliveries := make(chan amqp.Delivery, 10)
+	c.deliveries = append(c.deliveries, deliveries)
+	return deliveries, nil
+}
+
+func (c *fakeAMQPChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.published = append(c.published, msg)
+	if !c.holdConfirms {
+		for _, confirm := range c.confirms {
+			confirm <- amqp.Confirmation{DeliveryTag: uint64(len(c.published)), Ack: true}
+		}
+	}
+	return nil
+}
+
+func (c *fakeAMQPChannel) Published() []amqp.Publishing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]amqp.Publishing(nil), c.published...)
+}
+
+func (c *fakeAMQPChannel) deliver(body string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deliveries[0] <- amqp.Delivery{Acknowledger: c, DeliveryTag: uint64(len(c.deliveries[0]) + 1), Body: []byte(body)}
+}
+
+func (c *fakeAMQPChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.closed = true
+	for _, deliveries := range c.deliveries {
+		close(deliveries)
+	}
+	for _, confirm := range c.confirms {
+		close(confirm)
+	}
+	for _, receiver := range c.receivers {
+		close(receiver)
+	}
+	return nil
+}
+
+func (c *fakeAMQPChannel) Ack(tag uint64, multiple bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.acked = append(c.acked, tag)
+	return nil
+}
+
+func (c *fakeAMQPChannel) Nack(tag uint64, multiple, requeue bool) error {
+	return nil
+}
+
+func (c *fakeAMQPChannel) Reject(tag uint64, requeue bool) error {
+	return nil
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case msg := <-deliveries:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+		return amqp.Delivery{}
+	}
+}
+
+func testOptions() Options {
+	opts := DefaultOptions
+	opts.ReconnectDelay = time.Millisecond
+	opts.ReconnectMaxDelay = 5 * time.Millisecond
+	opts.RetryDelay = time.Millisecond
+	opts.ConfirmTimeout = time.Second
+	return opts
+}
+
+func TestManagerConsumesAcrossReconnections(t *testing.T) {
+	server := newFakeServer(2)
+	m := NewManager(server.dial, testOptions(), t.Logf)
+	if _, err := DeclareLiveGame(m, testOptions()); err != nil {
+		t.Fatalf("DeclareLiveGame() error = %v", err)
+	}
+	if err := m.Qos(4, 0, false); err != nil {
+		t.Fatalf("Qos() error = %v", err)
+	}
+	deliveries, err := m.Consume(LiveGame, "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	first := server.next(t)
+	want := []string{"QueueDeclare " + LiveGame, "Qos 4", "Consume " + LiveGame}
+	if got := first.ch.Calls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls on the channel = %v, want %v", got, want)
+	}
+
+	first.ch.deliver("before")
+	before := receive(t, deliveries)
+	if string(before.Body) != "before" || m.Stale(before) {
+		t.Fatalf("delivery = %s stale %v, want before on the live channel", before.Body, m.Stale(before))
+	}
+	if err := before.Ack(false); err != nil {
+		t.Errorf("Ack() error = %v", err)
+	}
+
+	first.drop()
+	second := server.next(t)
+	for deadline := time.Now().Add(time.Second); len(second.ch.Calls()) < len(want) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := second.ch.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls replayed on the new channel = %v, want %v", got, want)
+	}
+	second.ch.deliver("after")
+	after := receive(t, deliveries)
+	if string(after.Body) != "after" {
+		t.Errorf("delivery = %s, want after", after.Body)
+	}
+	if !m.Stale(before) || m.Stale(after) {
+		t.Errorf("Stale() = %v, %v, want only the delivery of the lost channel stale", m.Stale(before), m.Stale(after))
+	}
+	server.mu.Lock()
+	if server.dials != 4 {
+		t.Errorf("%d dials, want 2 failed dials and 2 connections", server.dials)
+	}
+	server.mu.Unlock()
+
+	if err := m.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Errorf("the deliveries should be closed with the manager")
+	}
+}
+
+func TestManagerPublishesAcrossReconnections(t *testing.T) {
+	server := newFakeServer(0)
+	m := NewManager(server.dial, testOptions(), t.Logf)
+	defer m.Close()
+	p, err := NewPublisher(m, LiveGame, testOptions())
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	first := server.next(t)
+	if err := p.Publish([]byte("confirmed")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	// The broker goes down before it confirms the second publish, which
+	// is published again on the next connection.
+	first.ch.mu.Lock()
+	first.ch.holdConfirms = true
+	first.ch.mu.Unlock()
+	published := make(chan error)
+	go func() {
+		published <- p.Publish([]byte("lost"))
+	}()
+	for len(first.ch.Published()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	first.drop()
+	second := server.next(t)
+	if err := <-published; err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := second.ch.Calls(); !reflect.DeepEqual(got, []string{"Confirm"}) {
+		t.Errorf("calls replayed on the new channel = %v, want the confirm mode", got)
+	}
+	if got := second.ch.Published(); len(got) != 1 || string(got[0].Body) != "lost" {
+		t.Errorf("published on the new channel = %v, want the unconfirmed publish", got)
+	}
+}
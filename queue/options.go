@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Options configure the queues of the actions and their publishing. The
+// producers and the consumers must declare the queues with the same options:
+// RabbitMQ refuses to declare an existing queue differently.
+type Options struct {
+	// Durable queues survive a restart of the broker.
+	Durable bool
+	// Persistent messages are written to disk by the broker, they only
+	// survive a restart in a durable queue.
+	Persistent bool
+	// Confirm waits for the broker to confirm every published message.
+	Confirm bool
+	// ConfirmTimeout bounds the wait of a confirm.
+	ConfirmTimeout time.Duration
+	// PublishRetries is the number of times a message refused by the broker
+	// is published again, RetryDelay the pause before the first retry. The
+	// pause doubles at every retry.
+	PublishRetries int
+	RetryDelay     time.Duration
+	// ReconnectDelay is the pause before connecting again to the broker, it
+	// doubles after every failure up to ReconnectMaxDelay.
+	ReconnectDelay    time.Duration
+	ReconnectMaxDelay time.Duration
+}
+
+// DefaultOptions survive a restart of the broker without losing an action.
+var DefaultOptions = Options{
+	Durable:        true,
+	Persistent:     true,
+	Confirm:        true,
+	ConfirmTimeout: 5 * time.Second,
+	PublishRetries: 5,
+	RetryDelay:     100 * time.Millisecond,
+
+	ReconnectDelay:    time.Second,
+	ReconnectMaxDelay: 30 * time.Second,
+}
+
+// RegisterFlags defines the flags of the options on fs, with the options as
+// default values.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Durable, "queueDurable", o.Durable, "declare the queue of the actions durable")
+	fs.BoolVar(&o.Persistent, "queuePersistent", o.Persistent, "publish the actions as persistent messages")
+	fs.BoolVar(&o.Confirm, "queueConfirm", o.Confirm, "wait for the broker to confirm every published action")
+	fs.DurationVar(&o.ConfirmTimeout, "queueConfirmTimeout", o.ConfirmTimeout, "the maximum wait of a publisher confirm")
+	fs.IntVar(&o.PublishRetries, "queuePublishRetries", o.PublishRetries, "the number of times an action refused by the broker is published again")
+	fs.DurationVar(&o.RetryDelay, "queueRetryDelay", o.RetryDelay, "the pause before an action refused by the broker is published again, doubled at every retry")
+	fs.DurationVar(&o.ReconnectDelay, "queueReconnectDelay", o.ReconnectDelay, "the pause before connecting again to the broker, doubled after every failure")
+	fs.DurationVar(&o.ReconnectMaxDelay, "queueReconnectMaxDelay", o.ReconnectMaxDelay, "the maximum pause before connecting again to the broker")
+}
+
+// DeclareLiveGame declares the LiveGame queue. The client, the server and
+// the monolithe declare the Topology in its place.
+//
+// Switching an existing queue to or from durable requires to delete it
+// first, for example with rabbitmqctl delete_queue LiveGame.
+func DeclareLiveGame(ch Channel, o Options) (amqp.Queue, error) {
+	q, err := ch.QueueDeclare(LiveGame, o.Durable, false, false, false, nil)
+	if err != nil {
+		return q, fmt.Errorf("failed to declare queue %s: %v", LiveGame, err)
+	}
+	return q, nil
+}
+
+// deliveryMode returns the delivery mode of the published messages.
+func (o Options) deliveryMode() uint8 {
+	if o.Persistent {
+		return amqp.Persistent
+	}
+	return amqp.Transient
+}
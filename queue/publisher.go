@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNotConfirmed is returned when the broker refused a message on every try.
+var ErrNotConfirmed = errors.New("message not confirmed by the broker")
+
+// PublishChannel is the part of *amqp.Channel used by a Publisher.
+type PublishChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+}
+
+// Publisher publishes messages on a queue, waiting for the confirm of the
+// broker when Options.Confirm is set. A Publisher waits for each confirm
+// before the next publish, so it must not be shared by goroutines, and it
+// must be the only publisher of its channel: the broker numbers the
+// confirms with the publishes of the channel.
+type Publisher struct {
+	ch       PublishChannel
+	queue    string
+	opts     Options
+	confirms chan amqp.Confirmation
+	// published is the delivery tag of the last publish.
+	published uint64
+}
+
+// NewPublisher returns a publisher on the queue, and puts the channel in
+// confirm mode when the options ask for confirms.
+func NewPublisher(ch PublishChannel, queue string, opts Options) (*Publisher, error) {
+	p := &Publisher{ch: ch, queue: queue, opts: opts}
+	if opts.Confirm {
+		if err := ch.Confirm(false); err != nil {
+			return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
+		}
+		p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	}
+	return p, nil
+}
+
+// Publish publishes the body on the queue of the publisher.
+func (p *Publisher) Publish(body []byte) error {
+	return p.PublishMessage("", p.queue, amqp.Publishing{ContentType: "text/plain", Body: body})
+}
+
+// PublishMessage publishes msg on the exchange with the routing key, in the
+// delivery mode of the options unless msg sets one. With confirms, a message
+// the broker refuses is published again, up to Options.PublishRetries times.
+func (p *Publisher) PublishMessage(exchange, key string, msg amqp.Publishing) error {
+	return p.PublishMessageContext(context.Background(), exchange, key, msg)
+}
+
+// PublishMessageContext publishes msg like PublishMessage, and gives up
+// waiting for its confirm once the context is done.
+func (p *Publisher) PublishMessageContext(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if msg.DeliveryMode == 0 {
+		msg.DeliveryMode = p.opts.deliveryMode()
+	}
+	delay := p.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if err := p.ch.Publish(exchange, key, false, false, msg); err != nil {
+			return fmt.Errorf("could not publish: %v", err)
+		}
+		if p.confirms == nil {
+			return nil
+		}
+		p.published++
+		acked, err := p.waitConfirm(ctx, p.published)
+		if err != nil {
+			return err
+		}
+		if acked {
+			return nil
+		}
+		if attempt >= p.opts.PublishRetries {
+			return fmt.Errorf("%w after %d tries", ErrNotConfirmed, attempt+1)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrNotConfirmed, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+// waitConfirm waits for the confirm of the publish of the delivery tag. The
+// confirms of the publishes before it, given up on after the timeout, are
+// skipped.
+func (p *Publisher) waitConfirm(ctx context.Context, tag uint64) (bool, error) {
+	timeout := time.NewTimer(p.opts.ConfirmTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case confirm, ok := <-p.confirms:
+			if !ok {
+				return false, errors.New("channel closed while waiting for a publisher confirm")
+			}
+			if confirm.DeliveryTag < tag {
+				continue
+			}
+			if confirm.DeliveryTag > tag {
+				return false, fmt.Errorf("publisher confirm %d while waiting for %d, the channel has another publisher", confirm.DeliveryTag, tag)
+			}
+			return confirm.Ack, nil
+		case <-timeout.C:
+			return false, fmt.Errorf("no publisher confirm after %v", p.opts.ConfirmTimeout)
+		case <-ctx.Done():
+			return false, fmt.Errorf("stopped waiting for a publisher confirm: %v", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fakeConfirmChannel acks or nacks the publishings in the order of acks, and
+// acks every publishing past them.
+type fakeConfirmChannel struct {
+	acks      []bool
+	published []amqp.Publishing
+	confirms  chan amqp.Confirmation
+	confirm   bool
+}
+
+func (c *fakeConfirmChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.published = append(c.published, msg)
+	if c.confirms == nil {
+		return nil
+	}
+	ack := true
+	if n := len(c.published); n <= len(c.acks) {
+		ack = c.acks[n-1]
+	}
+	c.confirms <- amqp.Confirmation{DeliveryTag: uint64(len(c.published)), Ack: ack}
+	return nil
+}
+
+func (c *fakeConfirmChannel) Confirm(noWait bool) error {
+	c.confirm = true
+	return nil
+}
+
+func (c *fakeConfirmChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirm
+	return confirm
+}
+
+func TestPublisher(t *testing.T) {
+	opts := DefaultOptions
+	opts.PublishRetries = 2
+	opts.RetryDelay = time.Millisecond
+	transient := opts
+	transient.Persistent, transient.Confirm = false, false
+
+	tests := map[string]struct {
+		opts      Options
+		acks      []bool
+		wantErr   error
+		wantTries int
+		wantMode  uint8
+	}{
+		"Acked":                     {opts, []bool{true}, nil, 1, amqp.Persistent},
+		"Published again on a nack": {opts, []bool{false, false, true}, nil, 3, amqp.Persistent},
+		"Nacked on every try":       {opts, []bool{false, false, false, true}, ErrNotConfirmed, 3, amqp.Persistent},
+		"Without confirms":          {transient, []bool{false}, nil, 1, amqp.Transient},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ch := &fakeConfirmChannel{acks: tc.acks}
+			p, err := NewPublisher(ch, LiveGame, tc.opts)
+			if err != nil {
+				t.Fatalf("NewPublisher() error = %v", err)
+			}
+			if ch.confirm != tc.opts.Confirm {
+				t.Errorf("confirm mode = %v, want %v", ch.confirm, tc.opts.Confirm)
+			}
+			err = p.Publish([]byte("action"))
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Publish() error = %v, want %v", err, tc.wantErr)
+			}
+			if len(ch.published) != tc.wantTries {
+				t.Errorf("Publish() tries = %d, want %d", len(ch.published), tc.wantTries)
+			}
+			if mode := ch.published[0].DeliveryMode; mode != tc.wantMode {
+				t.Errorf("Publish() delivery mode = %d, want %d", mode, tc.wantMode)
+			}
+		})
+	}
+}
+
+func TestPublisherConfirmTimeout(t *testing.T) {
+	opts := DefaultOptions
+	opts.ConfirmTimeout = 10 * time.Millisecond
+	ch := &fakeConfirmChannel{}
+	p, err := NewPublisher(ch, LiveGame, opts)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	// The confirm never comes: the channel stops sending them.
+	ch.confirms = nil
+	if err := p.Publish([]byte("action")); err == nil {
+		t.Errorf("Publish() should fail without a confirm")
+	}
+}
+
+func TestPublisherStopsWaitingWithTheContext(t *testing.T) {
+	opts := DefaultOptions
+	opts.ConfirmTimeout = time.Minute
+	ch := &fakeConfirmChannel{}
+	p, err := NewPublisher(ch, LiveGame, opts)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	ch.confirms = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.PublishMessageContext(ctx, "", LiveGame, amqp.Publishing{Body: []byte("action")}); err == nil {
+		t.Errorf("PublishMessageContext() should fail once the context is done")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("PublishMessageContext() waited %v after the context was done", waited)
+	}
+}
+
+func TestPublisherSkipsTheLateConfirms(t *testing.T) {
+	opts := DefaultOptions
+	opts.ConfirmTimeout = 10 * time.Millisecond
+	p, err := NewPublisher(&fakeConfirmChannel{}, LiveGame, opts)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	// The nack of the first publish comes after its timeout, while the
+	// second one waits for its confirm.
+	p.confirms = make(chan amqp.Confirmation, 2)
+	p.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	p.confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	if acked, err := p.waitConfirm(context.Background(), 2); !acked || err != nil {
+		t.Errorf("waitConfirm(2) = %v, %v, want the ack of the second publish", acked, err)
+	}
+
+	p.confirms <- amqp.Confirmation{DeliveryTag: 4, Ack: true}
+	if _, err := p.waitConfirm(context.Background(), 3); err == nil {
+		t.Error("waitConfirm(3) should fail on the confirm of another publisher")
+	}
+}
@@ -0,0 +1,124 @@
+// Package queue holds the names and the declarations of the RabbitMQ queues
+// shared by the producers and the consumers of the actions.
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// LiveGame is the queue of the actions of the games being played, from
+	// before the topic exchange of the Topology.
+	LiveGame = "LiveGame"
+	// DeadLetterExchange receives the actions the server cannot store.
+	DeadLetterExchange = "LiveGame.dead-letter"
+	// DeadLetters is the queue of the dead letters, until they are replayed.
+	DeadLetters = "LiveGame.dead-letters"
+)
+
+// Headers of a dead letter.
+const (
+	// HeaderReason is the reason the action was rejected.
+	HeaderReason = "x-failure-reason"
+	// HeaderFailedAt is the time of the rejection, in RFC 3339.
+	HeaderFailedAt = "x-failed-at"
+	// HeaderQueue is the queue the action was consumed from.
+	HeaderQueue = "x-original-queue"
+)
+
+// HeaderSequence is the place of an action in its game, from 1. The
+// consumers which keep a state per game skip an action whose sequence they
+// applied already, as a redelivered one.
+const HeaderSequence = "x-sequence"
+
+// Sequence returns the sequence of an action from the headers of its
+// message, 0 when it has none.
+func Sequence(headers map[string]any) int64 {
+	// RabbitMQ delivers the integers of the headers as int32 or int64.
+	switch v := headers[HeaderSequence].(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
+// Channel is the part of *amqp.Channel needed to declare the queues.
+type Channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// DeclareDeadLetters declares the dead letter exchange and its queue. They
+// are durable: a dead letter is kept until it is replayed or purged.
+func DeclareDeadLetters(ch Channel) error {
+	if err := ch.ExchangeDeclare(DeadLetterExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %v", DeadLetterExchange, err)
+	}
+	if _, err := ch.QueueDeclare(DeadLetters, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", DeadLetters, err)
+	}
+	if err := ch.QueueBind(DeadLetters, "", DeadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s: %v", DeadLetters, err)
+	}
+	return nil
+}
+
+// DeadLetter returns the message to publish on DeadLetterExchange for a
+// delivery of queueName rejected for reason. The body and the headers of the
+// delivery are kept, so the action can be replayed as it was.
+func DeadLetter(msg amqp.Delivery, queueName, reason string, failedAt time.Time) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderReason] = reason
+	headers[HeaderFailedAt] = failedAt.UTC().Format(time.RFC3339)
+	headers[HeaderQueue] = queueName
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    failedAt,
+		Body:         msg.Body,
+	}
+}
+
+// Replay returns the message to publish on the original queue for a dead
+// letter: the body, without the headers of the failure. The sequence is
+// dropped as well: the replayed action comes after the later actions of its
+// game, it must not be skipped as a redelivery. The message is persistent,
+// like the dead letter, so it survives a restart of RabbitMQ in the durable
+// queue.
+func Replay(msg amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		switch k {
+		case HeaderReason, HeaderFailedAt, HeaderQueue, HeaderSequence:
+		default:
+			headers[k] = v
+		}
+	}
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         msg.Body,
+	}
+}
+
+// OriginalQueue returns the queue a dead letter was consumed from, LiveGame
+// when the header is missing.
+func OriginalQueue(msg amqp.Delivery) string {
+	if name, ok := msg.Headers[HeaderQueue].(string); ok && name != "" {
+		return name
+	}
+	return LiveGame
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestDeadLetterAndReplay(t *testing.T) {
+	msg := amqp.Delivery{
+		Headers:     amqp.Table{"trace": "abc", HeaderSequence: int64(7)},
+		ContentType: "text/plain",
+		Body:        []byte(`{"gameposter": "Boston_Knicks"`),
+	}
+	failedAt := time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC)
+
+	dead := DeadLetter(msg, LiveGame, "undecodable action", failedAt)
+	if dead.Headers[HeaderReason] != "undecodable action" || dead.Headers[HeaderFailedAt] != "2025-01-15T20:00:00Z" {
+		t.Errorf("DeadLetter() headers = %v", dead.Headers)
+	}
+	if dead.Headers["trace"] != "abc" || dead.Headers[HeaderSequence] != int64(7) || string(dead.Body) != string(msg.Body) {
+		t.Errorf("DeadLetter() should keep the headers and the body, got %v %s", dead.Headers, dead.Body)
+	}
+	if dead.DeliveryMode != amqp.Persistent {
+		t.Errorf("DeadLetter() delivery mode = %d, want persistent", dead.DeliveryMode)
+	}
+
+	delivered := amqp.Delivery{Headers: dead.Headers, ContentType: dead.ContentType, Body: dead.Body}
+	if got := OriginalQueue(delivered); got != LiveGame {
+		t.Errorf("OriginalQueue() = %s, want %s", got, LiveGame)
+	}
+	replay := Replay(delivered)
+	if len(replay.Headers) != 1 || replay.Headers["trace"] != "abc" {
+		t.Errorf("Replay() headers = %v, want only the original ones", replay.Headers)
+	}
+	if replay.DeliveryMode != amqp.Persistent {
+		t.Errorf("Replay() delivery mode = %d, want persistent", replay.DeliveryMode)
+	}
+	if string(replay.Body) != string(msg.Body) {
+		t.Errorf("Replay() body = %s, want %s", replay.Body, msg.Body)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	tests := map[string]struct {
+		headers map[string]any
+		want    int64
+	}{
+		"Published":   {map[string]any{HeaderSequence: int64(12)}, 12},
+		"From AMQP":   {map[string]any{HeaderSequence: int32(12)}, 12},
+		"Int":         {map[string]any{HeaderSequence: 12}, 12},
+		"Missing":     {map[string]any{"trace": "abc"}, 0},
+		"No headers":  {nil, 0},
+		"Not integer": {map[string]any{HeaderSequence: "12"}, 0},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := Sequence(tc.headers); got != tc.want {
+				t.Errorf("Sequence() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
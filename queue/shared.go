@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// SharedDial returns a dialer whose connections share one connection of
+// dial, so Managers opened with it each have their own channel on a single
+// connection. The connection is dialed on the first call, and again on the
+// call after its loss. It is closed once every connection sharing it is
+// closed.
+func SharedDial(dial func() (Connection, error)) func() (Connection, error) {
+	s := &sharedDialer{dial: dial}
+	return s.open
+}
+
+type sharedDialer struct {
+	dial func() (Connection, error)
+	mu   sync.Mutex
+	// conn is the connection shared, nil before the first call and once
+	// it is lost.
+	conn *refConnection
+}
+
+// refConnection is a connection and the number of connections sharing it.
+type refConnection struct {
+	Connection
+	refs int
+}
+
+// sharedConnection is a connection sharing conn, which it releases once
+// closed.
+type sharedConnection struct {
+	*refConnection
+	dialer *sharedDialer
+	once   sync.Once
+}
+
+func (s *sharedDialer) open() (Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return nil, err
+		}
+		shared := &refConnection{Connection: conn}
+		s.conn = shared
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		go func() {
+			<-closed
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.conn == shared {
+				s.conn = nil
+			}
+		}()
+	}
+	s.conn.refs++
+	return &sharedConnection{refConnection: s.conn, dialer: s}, nil
+}
+
+// Close releases the connection shared, and closes it when it was the last
+// one sharing it.
+func (c *sharedConnection) Close() error {
+	var err error
+	c.once.Do(func() {
+		s := c.dialer
+		s.mu.Lock()
+		c.refs--
+		last := c.refs == 0
+		if last && s.conn == c.refConnection {
+			s.conn = nil
+		}
+		s.mu.Unlock()
+		if last {
+			err = c.Connection.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSharedDial(t *testing.T) {
+	server := newFakeServer(0)
+	dial := SharedDial(server.dial)
+	mustDial := func() Connection {
+		t.Helper()
+		conn, err := dial()
+		if err != nil {
+			t.Fatalf("dial() error = %v", err)
+		}
+		return conn
+	}
+	isClosed := func(conn *fakeConnection) bool {
+		conn.ch.mu.Lock()
+		defer conn.ch.mu.Unlock()
+		return conn.ch.closed
+	}
+
+	a, b := mustDial(), mustDial()
+	first := server.next(t)
+	a.Close()
+	a.Close()
+	if isClosed(first) {
+		t.Error("the connection should stay open while a game shares it")
+	}
+
+	// RabbitMQ restarts: a later call dials a new connection.
+	first.drop()
+	deadline := time.Now().Add(time.Second)
+	var c Connection
+	for {
+		c = mustDial()
+		if len(server.opened) > 0 {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("no new connection after the loss")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	second := server.next(t)
+	b.Close()
+	if isClosed(second) {
+		t.Error("the new connection should not be closed by a game of the lost one")
+	}
+	c.Close()
+	if !isClosed(second) {
+		t.Error("the connection should be closed with the last game sharing it")
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.dials != 2 {
+		t.Errorf("%d dials, want 2", server.dials)
+	}
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/streadway/amqp"
+)
+
+// Consumer groups of the actions. Each group consumes its own queue, bound
+// to the topic exchange with its patterns, so a group receives every action
+// it is interested in whatever the other groups do. The names are the keys of
+// the routing section of the configuration.
+const (
+	// GroupStats stores the actions in the database.
+	GroupStats = "stats"
+	// GroupLiveCache keeps the live scores of the games.
+	GroupLiveCache = "liveCache"
+	// GroupNotifier notifies the followers of the games.
+	GroupNotifier = "notifier"
+	// GroupWebapp streams the actions to the web page of the live games.
+	GroupWebapp = "webapp"
+)
+
+// Group is a consumer group, its queue receives the actions whose routing
+// key matches one of the patterns.
+type Group struct {
+	Name     string
+	Queue    string
+	Patterns []string
+}
+
+// Topology is the topic exchange of the actions and the queues of the
+// consumer groups. The producers publish on the exchange with RoutingKey,
+// and the consumers consume the queue of their group.
+type Topology struct {
+	Exchange string
+	Groups   []Group
+}
+
+// NewTopology returns the topology of the exchange with the binding
+// patterns of the groups, by name. The queue of a group is named
+// <exchange>.<group>, a group without pattern has no queue.
+func NewTopology(exchange string, patterns map[string][]string) Topology {
+	t := Topology{Exchange: exchange}
+	for name, p := range patterns {
+		if len(p) > 0 {
+			t.Groups = append(t.Groups, Group{Name: name, Queue: exchange + "." + name, Patterns: p})
+		}
+	}
+	sort.Slice(t.Groups, func(i, j int) bool { return t.Groups[i].Name < t.Groups[j].Name })
+	return t
+}
+
+// Queue returns the queue of the group, false when the group has none.
+func (t Topology) Queue(group string) (string, bool) {
+	for _, g := range t.Groups {
+		if g.Name == group {
+			return g.Queue, true
+		}
+	}
+	return "", false
+}
+
+// Declare declares the exchange, the queues of the groups and their
+// bindings. Like DeclareLiveGame, the producers and the consumers all
+// declare the topology: the queues exist, and keep the actions, whichever
+// starts first.
+func (t Topology) Declare(ch Channel, o Options) error {
+	if err := ch.ExchangeDeclare(t.Exchange, amqp.ExchangeTopic, o.Durable, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %v", t.Exchange, err)
+	}
+	for _, g := range t.Groups {
+		if _, err := ch.QueueDeclare(g.Queue, o.Durable, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %v", g.Queue, err)
+		}
+		for _, pattern := range g.Patterns {
+			if err := ch.QueueBind(g.Queue, pattern, t.Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue %s to %s: %v", g.Queue, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RoutingKey returns the routing key of an action of a game,
+// game.<gameID>.<actionType>. The dots of the game are replaced, a routing
+// key has exactly three words.
+func RoutingKey(gameID, description string) string {
+	return "game." + strings.ReplaceAll(gameID, ".", "_") + "." + ActionType(description)
+}
+
+// ActionType returns the type of an action from its description, in lower
+// case with its words joined by dashes, as 2pts-succes or end-of-game.
+func ActionType(description string) string {
+	words := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "unknown"
+	}
+	return strings.Join(words, "-")
+}
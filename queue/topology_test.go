@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoutingKey(t *testing.T) {
+	tests := map[string]struct {
+		gameID      string
+		description string
+		want        string
+	}{
+		"Score":          {"Boston_Knicks", "2pts succes", "game.Boston_Knicks.2pts-succes"},
+		"End of game":    {"Boston_Knicks", "end of game", "game.Boston_Knicks.end-of-game"},
+		"Upper case":     {"Boston_Knicks", "Free Throw  Missed", "game.Boston_Knicks.free-throw-missed"},
+		"Dot in game":    {"St.Louis_Knicks", "foul", "game.St_Louis_Knicks.foul"},
+		"No description": {"Boston_Knicks", " ", "game.Boston_Knicks.unknown"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := RoutingKey(tc.gameID, tc.description); got != tc.want {
+				t.Errorf("RoutingKey() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTopologyDeclare(t *testing.T) {
+	topology := NewTopology("actions", map[string][]string{
+		GroupStats:     {"game.#"},
+		GroupNotifier:  {"game.*.end-of-game", "game.*.technical-foul"},
+		GroupLiveCache: nil,
+	})
+	if queue, ok := topology.Queue(GroupStats); !ok || queue != "actions.stats" {
+		t.Errorf("Queue(%s) = %q, %v, want actions.stats", GroupStats, queue, ok)
+	}
+	if _, ok := topology.Queue(GroupLiveCache); ok {
+		t.Errorf("the group %s without pattern should have no queue", GroupLiveCache)
+	}
+
+	ch := &fakeAMQPChannel{}
+	if err := topology.Declare(ch, testOptions()); err != nil {
+		t.Fatalf("Declare() error = %v", err)
+	}
+	want := []string{
+		"ExchangeDeclare actions topic",
+		"QueueDeclare actions.notifier",
+		"QueueBind actions.notifier game.*.end-of-game actions",
+		"QueueBind actions.notifier game.*.technical-foul actions",
+		"QueueDeclare actions.stats",
+		"QueueBind actions.stats game.# actions",
+	}
+	if got := ch.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
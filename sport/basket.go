@@ -2,10 +2,10 @@ package sport
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
 	"time"
-
-	ut "statistic-syncer/utils"
 )
 
 type Actions []Action
@@ -32,15 +32,135 @@ func (s *ScoreRecord) Reset() {
 	s.LastRead = time.Now()
 }
 
+// PlayerStatistic is the aggregated line of a player, as stored in the
+// playerStatistic table.
+type PlayerStatistic struct {
+	PlayerName        string `json:"playerName"`
+	TwoPointTry       int32  `json:"twoPointTry"`
+	TwoPointSuccess   int32  `json:"twoPointSuccess"`
+	ThreePointTry     int32  `json:"threePointTry"`
+	ThreePointSuccess int32  `json:"threePointSuccess"`
+	FreeThrowTry      int32  `json:"freeThrowTry"`
+	FreeThrowSuccess  int32  `json:"freeThrowSuccess"`
+	Foul              int32  `json:"foul"`
+}
+
+// Apply updates the statistic with the description of an action. It returns
+// false when the description does not count in the statistic.
+func (p *PlayerStatistic) Apply(description string) bool {
+	switch description {
+	case "2pts try":
+		p.TwoPointTry++
+	case "2pts succes":
+		p.TwoPointTry++
+		p.TwoPointSuccess++
+	case "3pts try":
+		p.ThreePointTry++
+	case "3pts succes":
+		p.ThreePointTry++
+		p.ThreePointSuccess++
+	case "free throw try":
+		p.FreeThrowTry++
+	case "free throw succes":
+		p.FreeThrowTry++
+		p.FreeThrowSuccess++
+	case "foul":
+		p.Foul++
+	default:
+		return false
+	}
+	return true
+}
+
+// Points returns the points scored by the player.
+func (p PlayerStatistic) Points() int32 {
+	return 2*p.TwoPointSuccess + 3*p.ThreePointSuccess + p.FreeThrowSuccess
+}
+
+// Add sums the counters of another statistic into p.
+func (p *PlayerStatistic) Add(o PlayerStatistic) {
+	p.TwoPointTry += o.TwoPointTry
+	p.TwoPointSuccess += o.TwoPointSuccess
+	p.ThreePointTry += o.ThreePointTry
+	p.ThreePointSuccess += o.ThreePointSuccess
+	p.FreeThrowTry += o.FreeThrowTry
+	p.FreeThrowSuccess += o.FreeThrowSuccess
+	p.Foul += o.Foul
+}
+
+// BoxScoreLine is the line of a player in the box score of a game.
+type BoxScoreLine struct {
+	Team string `json:"team"`
+	PlayerStatistic
+}
+
+// BoxScore sums the actions of a game into a line per player, ordered by team
+// and player.
+func BoxScore(actions Actions) []BoxScoreLine {
+	type key struct{ team, player string }
+	lines := make(map[key]*BoxScoreLine)
+	for _, a := range actions {
+		if a.Description == EndOfGame {
+			continue
+		}
+		k := key{a.Team, a.PlayerName}
+		line, ok := lines[k]
+		if !ok {
+			line = &BoxScoreLine{Team: a.Team, PlayerStatistic: PlayerStatistic{PlayerName: a.PlayerName}}
+			lines[k] = line
+		}
+		line.Apply(a.Description)
+	}
+	boxScore := make([]BoxScoreLine, 0, len(lines))
+	for _, line := range lines {
+		boxScore = append(boxScore, *line)
+	}
+	sort.Slice(boxScore, func(i, j int) bool {
+		if boxScore[i].Team != boxScore[j].Team {
+			return boxScore[i].Team < boxScore[j].Team
+		}
+		return boxScore[i].PlayerName < boxScore[j].PlayerName
+	})
+	return boxScore
+}
+
+// SeasonStatistic is the line of a player over one season.
+type SeasonStatistic struct {
+	Season string `json:"season"`
+	PlayerStatistic
+}
+
+// GameInfo holds the metadata of a game.
+type GameInfo struct {
+	GamePoster string    `json:"gamePoster"`
+	Season     string    `json:"season"`
+	StartedAt  time.Time `json:"startedAt"`
+}
+
+// seasonFirstMonth is the month the seasons start, they end the next year.
+const seasonFirstMonth = time.August
+
+// SeasonOf returns the season a game played at t belongs to, as "2024-25".
+func SeasonOf(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	year := t.Year()
+	if t.Month() < seasonFirstMonth {
+		year--
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
 func ReadGameFile(path string) (Actions, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
-		ut.Fatal(err)
+		return nil, err
 	}
 	var game Actions
 	err = json.Unmarshal(content, &game)
 	if err != nil {
-		ut.Fatal(err)
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 	return game, nil
 }
@@ -0,0 +1,290 @@
+package sport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EndOfGame is the description of the action closing a game. It has no
+// player, and the final score of the game is stored when it is received.
+const EndOfGame = "end of game"
+
+// Points returns the points scored by an action of the description.
+func Points(description string) int32 {
+	switch description {
+	case "free throw succes":
+		return 1
+	case "2pts succes":
+		return 2
+	case "3pts succes":
+		return 3
+	}
+	return 0
+}
+
+// GameResult is the final score of a game. TeamA and TeamB are the two teams
+// of the game poster, as Boston and Knicks for Boston_Knicks.
+type GameResult struct {
+	GamePoster string    `json:"gamePoster"`
+	Season     string    `json:"season"`
+	StartedAt  time.Time `json:"startedAt"`
+	TeamA      string    `json:"teamA"`
+	TeamB      string    `json:"teamB"`
+	ScoreA     int32     `json:"scoreA"`
+	ScoreB     int32     `json:"scoreB"`
+}
+
+// FinalScore computes the result of a game from its actions. The points of
+// a team which is not TeamA go to TeamB, like in the live cache of the scores.
+func FinalScore(game GameInfo, actions Actions) GameResult {
+	teamA, teamB, _ := strings.Cut(game.GamePoster, "_")
+	result := GameResult{
+		GamePoster: game.GamePoster,
+		Season:     game.Season,
+		StartedAt:  game.StartedAt,
+		TeamA:      teamA,
+		TeamB:      teamB,
+	}
+	for _, a := range actions {
+		if a.Team == teamA {
+			result.ScoreA += Points(a.Description)
+		} else {
+			result.ScoreB += Points(a.Description)
+		}
+	}
+	return result
+}
+
+// Winner returns the team which won the game, or "" for a tie.
+func (r GameResult) Winner() string {
+	switch {
+	case r.ScoreA > r.ScoreB:
+		return r.TeamA
+	case r.ScoreB > r.ScoreA:
+		return r.TeamB
+	}
+	return ""
+}
+
+// score returns the points scored and conceded by the team in the game, and
+// whether it played it.
+func (r GameResult) score(team string) (scored, conceded int32, ok bool) {
+	switch team {
+	case r.TeamA:
+		return r.ScoreA, r.ScoreB, true
+	case r.TeamB:
+		return r.ScoreB, r.ScoreA, true
+	}
+	return 0, 0, false
+}
+
+// Standing is the record of a team over a set of games. A tied game counts
+// in the points but neither as a win nor as a loss.
+type Standing struct {
+	Team          string  `json:"team"`
+	Wins          int32   `json:"wins"`
+	Losses        int32   `json:"losses"`
+	WinPct        float64 `json:"winPct"`
+	PointsFor     int32   `json:"pointsFor"`
+	PointsAgainst int32   `json:"pointsAgainst"`
+	// Streak is the number of games of the current run of wins, or minus
+	// the number of games of the current run of losses.
+	Streak int32 `json:"streak"`
+}
+
+// PointDifference returns the points scored minus the points conceded.
+func (s Standing) PointDifference() int32 {
+	return s.PointsFor - s.PointsAgainst
+}
+
+// StreakString returns the streak as W3 or L2, or - without a streak.
+func (s Standing) StreakString() string {
+	switch {
+	case s.Streak > 0:
+		return fmt.Sprintf("W%d", s.Streak)
+	case s.Streak < 0:
+		return fmt.Sprintf("L%d", -s.Streak)
+	}
+	return "-"
+}
+
+func (s *Standing) add(r GameResult) {
+	scored, conceded, ok := r.score(s.Team)
+	if !ok {
+		return
+	}
+	s.PointsFor += scored
+	s.PointsAgainst += conceded
+	switch {
+	case scored > conceded:
+		s.Wins++
+		if s.Streak < 0 {
+			s.Streak = 0
+		}
+		s.Streak++
+	case scored < conceded:
+		s.Losses++
+		if s.Streak > 0 {
+			s.Streak = 0
+		}
+		s.Streak--
+	default:
+		s.Streak = 0
+	}
+	if s.Wins+s.Losses > 0 {
+		s.WinPct = float64(s.Wins) / float64(s.Wins+s.Losses)
+	}
+}
+
+// Tiebreaker orders the teams of the standings with the same win percentage.
+type Tiebreaker string
+
+const (
+	// HeadToHead ranks first the best win percentage in the games between
+	// the tied teams.
+	HeadToHead Tiebreaker = "headToHead"
+	// PointDifference ranks first the best difference of points.
+	PointDifference Tiebreaker = "pointDifference"
+	// PointsFor ranks first the team which scored the most points.
+	PointsFor Tiebreaker = "pointsFor"
+)
+
+// DefaultTiebreakers are the tiebreakers of the standings when none is configured.
+var DefaultTiebreakers = []Tiebreaker{HeadToHead, PointDifference, PointsFor}
+
+// ParseTiebreakers reads a comma separated list of tiebreakers, as
+// "headToHead,pointDifference". An empty list gives the default tiebreakers.
+func ParseTiebreakers(list string) ([]Tiebreaker, error) {
+	if strings.TrimSpace(list) == "" {
+		return DefaultTiebreakers, nil
+	}
+	var tiebreakers []Tiebreaker
+	for _, name := range strings.Split(list, ",") {
+		tiebreaker := Tiebreaker(strings.TrimSpace(name))
+		switch tiebreaker {
+		case HeadToHead, PointDifference, PointsFor:
+			tiebreakers = append(tiebreakers, tiebreaker)
+		default:
+			return nil, fmt.Errorf("unknown tiebreaker %q", name)
+		}
+	}
+	return tiebreakers, nil
+}
+
+// Standings ranks the teams of the results by win percentage, then by the
+// tiebreakers in order, and last by name. The results are counted by start
+// of the game, which gives the streaks.
+func Standings(results []GameResult, tiebreakers []Tiebreaker) []Standing {
+	results = byStart(results)
+	byTeam := make(map[string]*Standing)
+	for _, r := range results {
+		for _, team := range []string{r.TeamA, r.TeamB} {
+			s, ok := byTeam[team]
+			if !ok {
+				s = &Standing{Team: team}
+				byTeam[team] = s
+			}
+			s.add(r)
+		}
+	}
+	standings := make([]Standing, 0, len(byTeam))
+	for _, s := range byTeam {
+		standings = append(standings, *s)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].WinPct != standings[j].WinPct {
+			return standings[i].WinPct > standings[j].WinPct
+		}
+		return standings[i].Team < standings[j].Team
+	})
+
+	// The tiebreakers apply to every group of teams with the same win percentage.
+	for start := 0; start < len(standings); {
+		end := start + 1
+		for end < len(standings) && standings[end].WinPct == standings[start].WinPct {
+			end++
+		}
+		if end-start > 1 {
+			breakTie(standings[start:end], results, tiebreakers)
+		}
+		start = end
+	}
+	return standings
+}
+
+func breakTie(tied []Standing, results []GameResult, tiebreakers []Tiebreaker) {
+	inGroup := make(map[string]bool)
+	for _, s := range tied {
+		inGroup[s.Team] = true
+	}
+	headToHead := make(map[string]*Standing)
+	for _, s := range tied {
+		headToHead[s.Team] = &Standing{Team: s.Team}
+	}
+	for _, r := range results {
+		if inGroup[r.TeamA] && inGroup[r.TeamB] {
+			headToHead[r.TeamA].add(r)
+			headToHead[r.TeamB].add(r)
+		}
+	}
+
+	sort.SliceStable(tied, func(i, j int) bool {
+		a, b := tied[i], tied[j]
+		for _, tiebreaker := range tiebreakers {
+			switch tiebreaker {
+			case HeadToHead:
+				if pa, pb := headToHead[a.Team].WinPct, headToHead[b.Team].WinPct; pa != pb {
+					return pa > pb
+				}
+			case PointDifference:
+				if a.PointDifference() != b.PointDifference() {
+					return a.PointDifference() > b.PointDifference()
+				}
+			case PointsFor:
+				if a.PointsFor != b.PointsFor {
+					return a.PointsFor > b.PointsFor
+				}
+			}
+		}
+		return a.Team < b.Team
+	})
+}
+
+// HeadToHeadRecord is the record of a team against one opponent.
+type HeadToHeadRecord struct {
+	Standing
+	Opponent string       `json:"opponent"`
+	Games    []GameResult `json:"games"`
+}
+
+// HeadToHeadOf returns the record of the team in its games against the
+// opponent, with the games by start.
+func HeadToHeadOf(results []GameResult, team, opponent string) HeadToHeadRecord {
+	record := HeadToHeadRecord{Standing: Standing{Team: team}, Opponent: opponent, Games: []GameResult{}}
+	for _, r := range byStart(results) {
+		if _, _, ok := r.score(opponent); !ok {
+			continue
+		}
+		if _, _, ok := r.score(team); !ok {
+			continue
+		}
+		record.add(r)
+		record.Games = append(record.Games, r)
+	}
+	return record
+}
+
+// byStart returns a copy of the results sorted by start of the game.
+func byStart(results []GameResult) []GameResult {
+	sorted := make([]GameResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].StartedAt.Equal(sorted[j].StartedAt) {
+			return sorted[i].StartedAt.Before(sorted[j].StartedAt)
+		}
+		return sorted[i].GamePoster < sorted[j].GamePoster
+	})
+	return sorted
+}
@@ -0,0 +1,108 @@
+package sport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func result(poster string, day int, scoreA, scoreB int32) GameResult {
+	r := FinalScore(GameInfo{GamePoster: poster, StartedAt: time.Date(2025, 1, day, 20, 0, 0, 0, time.UTC)}, nil)
+	r.ScoreA, r.ScoreB = scoreA, scoreB
+	return r
+}
+
+func TestFinalScore(t *testing.T) {
+	actions := Actions{
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "3pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Donte divicenzo", Description: "2pts succes"},
+		{GamePoster: "Boston_Knicks", Team: "Knicks", PlayerName: "Donte divicenzo", Description: "2pts try"},
+		{GamePoster: "Boston_Knicks", Team: "Boston", PlayerName: "JD Davison", Description: "free throw succes"},
+		{GamePoster: "Boston_Knicks", Description: EndOfGame},
+	}
+	got := FinalScore(GameInfo{GamePoster: "Boston_Knicks"}, actions)
+	want := GameResult{GamePoster: "Boston_Knicks", TeamA: "Boston", TeamB: "Knicks", ScoreA: 4, ScoreB: 2}
+	if got != want {
+		t.Errorf("FinalScore() = %+v, want %+v", got, want)
+	}
+	if got.Winner() != "Boston" {
+		t.Errorf("Winner() = %s, want Boston", got.Winner())
+	}
+}
+
+func TestStandings(t *testing.T) {
+	// Boston and Knicks both win 2 of 4 games, Boston beat the Knicks but
+	// the Knicks have the best point difference.
+	results := []GameResult{
+		result("Boston_Knicks", 1, 90, 88),
+		result("Knicks_Bulls", 2, 120, 80),
+		result("Bulls_Boston", 3, 100, 95),
+		result("Boston_Bulls", 4, 101, 99),
+		result("Knicks_Bulls", 5, 110, 90),
+		result("Bulls_Boston", 6, 100, 99),
+		result("Raptor_Knicks", 7, 100, 99),
+	}
+	tests := map[string]struct {
+		tiebreakers []Tiebreaker
+		want        []string
+	}{
+		"Head to head first":      {[]Tiebreaker{HeadToHead, PointDifference}, []string{"Raptor", "Boston", "Knicks", "Bulls"}},
+		"Point difference first":  {[]Tiebreaker{PointDifference, HeadToHead}, []string{"Raptor", "Knicks", "Boston", "Bulls"}},
+		"Name without tiebreaker": {nil, []string{"Raptor", "Boston", "Knicks", "Bulls"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			standings := Standings(results, tc.tiebreakers)
+			var got []string
+			for _, s := range standings {
+				got = append(got, s.Team)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Standings() order = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	byTeam := make(map[string]Standing)
+	for _, s := range Standings(results, DefaultTiebreakers) {
+		byTeam[s.Team] = s
+	}
+	knicks := Standing{Team: "Knicks", Wins: 2, Losses: 2, WinPct: 0.5, PointsFor: 417, PointsAgainst: 360, Streak: -1}
+	if byTeam["Knicks"] != knicks {
+		t.Errorf("Knicks standing = %+v, want %+v", byTeam["Knicks"], knicks)
+	}
+	if got := byTeam["Boston"].StreakString(); got != "L1" {
+		t.Errorf("Boston streak = %s, want L1", got)
+	}
+	if got := byTeam["Bulls"].StreakString(); got != "W1" {
+		t.Errorf("Bulls streak = %s, want W1", got)
+	}
+}
+
+func TestHeadToHeadOf(t *testing.T) {
+	results := []GameResult{
+		result("Knicks_Boston", 8, 100, 90),
+		result("Boston_Knicks", 1, 90, 88),
+		result("Boston_Bulls", 4, 101, 99),
+	}
+	got := HeadToHeadOf(results, "Boston", "Knicks")
+	if got.Wins != 1 || got.Losses != 1 || got.PointsFor != 180 || got.PointsAgainst != 188 || got.Streak != -1 {
+		t.Errorf("HeadToHeadOf() = %+v", got.Standing)
+	}
+	if len(got.Games) != 2 || got.Games[0].GamePoster != "Boston_Knicks" {
+		t.Errorf("HeadToHeadOf() games = %+v, want the 2 games by date", got.Games)
+	}
+}
+
+func TestParseTiebreakers(t *testing.T) {
+	got, err := ParseTiebreakers("pointsFor, headToHead")
+	if err != nil || !reflect.DeepEqual(got, []Tiebreaker{PointsFor, HeadToHead}) {
+		t.Errorf("ParseTiebreakers() = %v, %v", got, err)
+	}
+	if got, err := ParseTiebreakers(""); err != nil || !reflect.DeepEqual(got, DefaultTiebreakers) {
+		t.Errorf("ParseTiebreakers(\"\") = %v, %v, want the default tiebreakers", got, err)
+	}
+	if _, err := ParseTiebreakers("coinToss"); err == nil {
+		t.Errorf("ParseTiebreakers(coinToss) should fail")
+	}
+}
@@ -22,6 +22,11 @@ const (
 	FatalLevel
 )
 
+// SetLogLevel changes the level under which the logs are discarded.
+func SetLogLevel(ll level) {
+	logLevel = ll
+}
+
 func shouldLog(ll level) bool {
 	if logLevel <= ll {
 		return true
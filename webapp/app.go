@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand/v2"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
+	"sync_score/config"
+
 	"github.com/gorilla/mux"
 )
 
@@ -60,8 +67,17 @@ func (rr *RouterRegistry) RegisterWithMethod(pattern, method string, handler htt
 var (
 	games = make(map[string]*Game)
 	gamesMux sync.RWMutex
+	// streamsClosing is closed when the server shuts down, to end the streams.
+	streamsClosing = make(chan struct{})
+
+	shutdownTimeout = flag.Duration("shutdownTimeout", 10*time.Second, "the maximum wait for the requests in flight once the server is asked to stop")
+	cfg             = config.Default()
 )
 
+func init() {
+	cfg.RegisterFlags(flag.CommandLine)
+}
+
 // var game GameData
 // var gameStarted bool
 
@@ -181,11 +197,31 @@ func handleGameStream(w http.ResponseWriter, r *http.Request) {
 		
 		fmt.Fprintf(w, "data: %s\n\n", jsonData)
 		w.(http.Flusher).Flush()
-		time.Sleep(1 * time.Second)
+		select {
+		case <-time.After(1 * time.Second):
+		case <-r.Context().Done():
+			return
+		case <-streamsClosing:
+			// The last event tells the page that the server stops, so it
+			// does not connect again.
+			fmt.Fprintf(w, "event: shutdown\ndata: {\"reason\": \"server shutting down\"}\n\n")
+			w.(http.Flusher).Flush()
+			return
+		}
 	}
 }
 
 func main() {
+	flag.Parse()
+	if err := cfg.Load(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	log.Printf("Configuration:\n%s", cfg)
+
+	// SIGINT and SIGTERM close the streams and stop the server.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	registry := NewRouterRegistry()
 
 	// Initialize games dynamically
@@ -213,13 +249,34 @@ func main() {
 
 	// Start the server
 	server := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.Webapp.Listen,
 		Handler: registry.router,
 	}
 
+	server.RegisterOnShutdown(func() {
+		close(streamsClosing)
+	})
+
 	// Start the server
-	log.Println("Server is running at http://localhost:8080")
-	log.Fatal(server.ListenAndServe())
+	log.Printf("Server is running at %s", cfg.Webapp.Listen)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Requests still in flight after %v: %v", *shutdownTimeout, err)
+		server.Close()
+	}
+	log.Println("Server stopped")
 }
 
 func initGame(id string) *Game {